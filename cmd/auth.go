@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sabarim/kitedata/internal/auth"
+	"github.com/spf13/cobra"
+)
+
+func newAuthCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "auth",
+		Short: "Check that the configured credentials can access Kite",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			authManager := auth.NewAuthManager(cfg)
+			kiteClient, err := authManager.GetClient()
+			if err != nil {
+				return fmt.Errorf("failed to authenticate with Kite: %w", err)
+			}
+
+			// The session token is only proven valid by an authenticated call
+			profile, err := kiteClient.GetUserProfile()
+			if err != nil {
+				return fmt.Errorf("credentials were rejected by Kite: %w", err)
+			}
+
+			fmt.Printf("Authenticated as %s (%s) with %s\n", profile.UserName, profile.UserID, profile.Broker)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// secretKeys are config keys masked by "config show" unless --show-secrets is given
+var secretKeys = map[string]bool{
+	"auth_service_api_key": true,
+	"api_key":              true,
+	"api_secret":           true,
+	"session_token":        true,
+	"s3_secret_key":        true,
+	"influx_token":         true,
+	"influx_url":           true, // may carry a user and password
+	"postgres_url":         true, // may carry a password
+}
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the effective configuration",
+	}
+
+	var showSecrets bool
+	show := &cobra.Command{
+		Use:   "show",
+		Short: "Print the configuration after applying environment variables, flags and defaults",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			var settings map[string]interface{}
+			if err := mapstructure.Decode(cfg, &settings); err != nil {
+				return fmt.Errorf("failed to encode configuration: %w", err)
+			}
+			settings["universes"] = cfg.Universes
+			if !showSecrets {
+				maskSecrets(settings)
+			}
+
+			encoder := yaml.NewEncoder(os.Stdout)
+			encoder.SetIndent(2)
+			defer encoder.Close()
+			return encoder.Encode(settings)
+		},
+	}
+	show.Flags().BoolVar(&showSecrets, "show-secrets", false, "Print credentials instead of masking them")
+
+	init := &cobra.Command{
+		Use:   "init",
+		Short: "Create config.yaml from config.yaml.example",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stat(configFile); err == nil {
+				return fmt.Errorf("%s already exists", configFile)
+			}
+			example, err := os.ReadFile("config.yaml.example")
+			if err != nil {
+				return fmt.Errorf("failed to read example config: %w", err)
+			}
+			if err := os.WriteFile(configFile, example, 0600); err != nil {
+				return fmt.Errorf("failed to write config: %w", err)
+			}
+			fmt.Printf("%s created from example. Please edit with your settings.\n", configFile)
+			return nil
+		},
+	}
+
+	cmd.AddCommand(show, init)
+	return cmd
+}
+
+// maskSecrets replaces non-empty credential values in nested settings
+func maskSecrets(settings map[string]interface{}) {
+	for key, value := range settings {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			maskSecrets(value)
+		case string:
+			if secretKeys[key] && value != "" {
+				settings[key] = "********"
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/spf13/cobra"
+)
+
+func newConvertCommand() *cobra.Command {
+	var symbols, target, interval string
+	var workers int
+
+	cmd := &cobra.Command{
+		Use:   "convert",
+		Short: "Convert downloaded candles between CSV and monthly Parquet files",
+		Long: `Converts existing downloads without contacting Kite. With --to parquet, every
+<SYMBOL>_historical.csv in the output directory is written to monthly Parquet
+partitions in the configured layout; with --to csv, the monthly Parquet files
+are combined back into CSV.
+Conversions are safe to rerun.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			if interval == "" {
+				interval = cfg.Historical.Interval
+			}
+			if interval, err = historical.KiteInterval(interval); err != nil {
+				return err
+			}
+			parquetOptions, err := historical.ParquetOptionsFromConfig(cfg.Historical)
+			if err != nil {
+				return err
+			}
+
+			csvOptions, err := historical.CSVOptionsFromConfig(cfg.Historical)
+			if err != nil {
+				return err
+			}
+
+			direction := historical.ConvertDirection(target)
+			list := splitFlag(symbols)
+			if len(list) == 0 {
+				// Symbols are discovered in the directory we convert from
+				if direction == historical.ParquetToCSV {
+					list, err = parquetOptions.Layout.Symbols(cfg.Historical.ParquetDir)
+				} else {
+					list, err = historical.StoredSymbols(cfg.Historical.OutputDir)
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			result, err := historical.Convert(cmd.Context(), historical.ConvertOptions{
+				OutputDir:  cfg.Historical.OutputDir,
+				ParquetDir: cfg.Historical.ParquetDir,
+				Symbols:    list,
+				Direction:  direction,
+				Workers:    workers,
+				Parquet:    parquetOptions,
+				CSV:        csvOptions,
+				Series:     seriesLookup(cfg, interval),
+			})
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Converted %d symbols, skipped %d without source files, %d failed",
+				result.Converted, result.Skipped, len(result.Failed))
+			if len(result.Failed) > 0 {
+				return fmt.Errorf("failed to convert %d symbols", len(result.Failed))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&target, "to", "parquet", "Target format (parquet, csv)")
+	cmd.Flags().StringVar(&symbols, "symbols", "", "Comma-separated list of symbols (default: every stored symbol)")
+	cmd.Flags().StringVar(&interval, "interval", "", "Interval of the stored candles, recorded in Parquet files (default from config)")
+	cmd.Flags().IntVar(&workers, "workers", runtime.NumCPU(), "Number of symbols converted in parallel")
+
+	return cmd
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/daemon"
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/spf13/cobra"
+)
+
+func newDaemonCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run the jobs defined in config on their schedules",
+		Long: `Runs until interrupted, starting each job in daemon.jobs when its schedule is
+due. A job is a kitedata command line, such as a download for a universe, run
+as a separate process with this config file. Schedules are cron expressions
+or shorthands like "weekdays 16:00", read in daemon.timezone (IST by default).
+
+A job with a calendar is skipped on the days that exchange is closed. A job
+is never started while its previous run is still going. Failed and partial
+runs are retried as configured. Every run and skipped time is recorded in
+<daemon dir>/history.jsonl, with the command's output in <daemon dir>/logs.`,
+		Example: `  kitedata daemon
+  kitedata daemon jobs
+  kitedata daemon history --job eod-minute`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, location, jobs, err := loadJobs()
+			if err != nil {
+				return err
+			}
+			executable, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("failed to locate the kitedata binary: %w", err)
+			}
+			// Jobs may run from another working directory than the config's
+			configPath, err := filepath.Abs(configFile)
+			if err != nil {
+				return err
+			}
+
+			d := &daemon.Daemon{
+				Jobs:       jobs,
+				Dir:        cfg.Daemon.Dir,
+				Location:   location,
+				Executable: executable,
+				ConfigFile: configPath,
+				History:    &daemon.History{Path: daemon.HistoryPath(cfg.Daemon.Dir)},
+			}
+			now := time.Now().In(location)
+			for _, job := range jobs {
+				log.Printf("Job %s scheduled %q, next run %s", job.Name, job.Schedule, formatNextRun(job.NextRun(now)))
+			}
+			if err := d.Run(cmd.Context()); err != nil {
+				return err
+			}
+			log.Println("Daemon stopped")
+			return nil
+		},
+	}
+
+	cmd.AddCommand(newDaemonJobsCommand(), newDaemonHistoryCommand())
+	return cmd
+}
+
+func newDaemonJobsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "jobs",
+		Short: "List the configured jobs and when they run next",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, location, jobs, err := loadJobs()
+			if err != nil {
+				return err
+			}
+			history := &daemon.History{Path: daemon.HistoryPath(cfg.Daemon.Dir)}
+			runs, err := history.Read()
+			if err != nil {
+				return err
+			}
+			last := make(map[string]daemon.Run)
+			for _, run := range runs {
+				if run.Status != daemon.StatusSkipped {
+					last[run.Job] = run
+				}
+			}
+
+			now := time.Now().In(location)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "JOB\tSCHEDULE\tCALENDAR\tNEXT RUN\tLAST RUN\tLAST STATUS")
+			for _, job := range jobs {
+				calendar, lastRun, lastStatus := "-", "-", "-"
+				if job.Calendar != nil {
+					calendar = job.Calendar.Name
+				}
+				if run, ok := last[job.Name]; ok {
+					lastRun = run.Scheduled.In(location).Format("2006-01-02 15:04")
+					lastStatus = run.Status
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", job.Name, job.Schedule, calendar, formatNextRun(job.NextRun(now)), lastRun, lastStatus)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newDaemonHistoryCommand() *cobra.Command {
+	var job string
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Print the most recent job runs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			location, err := historical.LoadTimezone(cfg.Daemon.Timezone)
+			if err != nil {
+				return err
+			}
+			history := &daemon.History{Path: daemon.HistoryPath(cfg.Daemon.Dir)}
+			runs, err := history.Read()
+			if err != nil {
+				return err
+			}
+
+			var selected []daemon.Run
+			for _, run := range runs {
+				if job == "" || run.Job == job {
+					selected = append(selected, run)
+				}
+			}
+			if limit > 0 && len(selected) > limit {
+				selected = selected[len(selected)-limit:]
+			}
+			if len(selected) == 0 {
+				fmt.Println("No job runs recorded")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "JOB\tSCHEDULED\tATTEMPT\tSTATUS\tDURATION\tERROR")
+			for _, run := range selected {
+				attempt, duration := "-", "-"
+				if run.Attempt > 0 {
+					attempt = fmt.Sprint(run.Attempt)
+					duration = run.Duration().Round(time.Second).String()
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", run.Job, run.Scheduled.In(location).Format("2006-01-02 15:04"),
+					attempt, run.Status, duration, run.Error)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&job, "job", "", "Only show runs of this job")
+	cmd.Flags().IntVar(&limit, "limit", 20, "Number of runs to show, 0 for all")
+
+	return cmd
+}
+
+// loadJobs loads the configuration and validates its jobs
+func loadJobs() (*config.Config, *time.Location, []*daemon.Job, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	location, err := historical.LoadTimezone(cfg.Daemon.Timezone)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	jobs, err := daemon.NewJobs(cfg.Daemon, cfg.Historical)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cfg, location, jobs, nil
+}
+
+// formatNextRun writes a job's next run, or that it has none within a year
+func formatNextRun(next time.Time) string {
+	if next.IsZero() {
+		return "none within a year"
+	}
+	return next.Format("2006-01-02 15:04 MST")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/sabarim/kitedata/internal/auth"
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/spf13/cobra"
+)
+
+// downloadOptions holds the flags of the download command
+type downloadOptions struct {
+	selection      selectionFlags
+	fromDate       string
+	toDate         string
+	days           int
+	interval       string
+	parquetEnabled bool
+	sinks          string
+	requestDelay   int
+	maxRetries     int
+}
+
+func newDownloadCommand() *cobra.Command {
+	var opts downloadOptions
+
+	cmd := &cobra.Command{
+		Use:   "download",
+		Short: "Download historical candles for the selected instruments",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDownload(cmd, &opts)
+		},
+	}
+
+	opts.selection.register(cmd)
+	cmd.Flags().StringVar(&opts.fromDate, "from", "", "Start date (YYYY-MM-DD)")
+	cmd.Flags().StringVar(&opts.toDate, "to", "", "End date (YYYY-MM-DD)")
+	cmd.Flags().IntVar(&opts.days, "days", 0, "Number of days to fetch")
+	cmd.Flags().StringVar(&opts.interval, "interval", "", "Time interval (minute, 3minute, 5minute, 10minute, 15minute, 30minute, hour, day)")
+	cmd.Flags().BoolVar(&opts.parquetEnabled, "parquet", false, "Convert to Parquet format")
+	cmd.Flags().StringVar(&opts.sinks, "sink", "", "Comma-separated list of outputs to write, e.g. csv,parquet (default from config)")
+	cmd.Flags().IntVar(&opts.requestDelay, "request-delay", 0, "Delay between requests in milliseconds")
+	cmd.Flags().IntVar(&opts.maxRetries, "max-retries", 0, "Maximum number of retries for failed requests")
+
+	return cmd
+}
+
+func runDownload(cmd *cobra.Command, opts *downloadOptions) error {
+	// 1. Load configuration from file, environment and flags
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if opts.fromDate != "" {
+		cfg.Historical.FromDate = opts.fromDate
+	}
+	if opts.toDate != "" {
+		cfg.Historical.ToDate = opts.toDate
+	}
+	if opts.days > 0 {
+		cfg.Historical.DaysToFetch = opts.days
+	}
+	if opts.interval != "" {
+		cfg.Historical.Interval = opts.interval
+	}
+	if opts.parquetEnabled {
+		cfg.Historical.ParquetEnabled = true
+	}
+	if opts.sinks != "" {
+		cfg.Historical.Sinks = splitFlag(opts.sinks)
+	}
+	if opts.requestDelay > 0 {
+		cfg.Historical.RequestDelay = opts.requestDelay
+	}
+	if opts.maxRetries > 0 {
+		cfg.Historical.MaxRetries = opts.maxRetries
+	}
+
+	// Build the outputs first so bad sink settings fail before any network work
+	sink, err := historical.NewSinkFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	// 2. Determine symbols to download before doing any network work
+	selection := opts.selection.selection(cfg)
+	if len(selection.Include) == 0 {
+		return fmt.Errorf("no symbols specified. Use --symbols, --symbol-file, --universe, --filter or the symbols list in config")
+	}
+
+	// 3. Initialize authentication and get authenticated client
+	authManager := auth.NewAuthManager(cfg)
+	fmt.Println("Authenticating with Kite...")
+	kiteClient, err := authManager.GetClient()
+	if err != nil {
+		return fmt.Errorf("failed to authenticate with Kite: %w", err)
+	}
+
+	// 4. Download instruments data and resolve the selection
+	instrumentManager, closeStore, err := newInstrumentManager(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	if err := instrumentManager.DownloadInstruments(cmd.Context()); err != nil {
+		return fmt.Errorf("failed to download instruments: %w", err)
+	}
+
+	instrumentsList, err := instrumentManager.Resolve(selection)
+	if err != nil {
+		return fmt.Errorf("failed to resolve symbols: %w", err)
+	}
+	if len(instrumentsList) == 0 {
+		return fmt.Errorf("no valid instruments found for the specified symbols")
+	}
+
+	log.Printf("Found %d instruments to download", len(instrumentsList))
+
+	// 5. Initialize historical downloader and download historical data
+	histDownloader := historical.NewHistoricalDownloaderWithSink(cfg, kiteClient, sink)
+
+	if err := histDownloader.DownloadHistoricalData(cmd.Context(), instrumentsList); err != nil {
+		return fmt.Errorf("failed to download historical data: %w", err)
+	}
+
+	log.Println("Historical data download completed successfully")
+	return nil
+}
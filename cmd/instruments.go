@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/instruments"
+	"github.com/spf13/cobra"
+)
+
+// instrumentsOptions holds the flags of the instruments command
+type instrumentsOptions struct {
+	selection selectionFlags
+	live      string
+}
+
+func newInstrumentsCommand() *cobra.Command {
+	var opts instrumentsOptions
+
+	cmd := &cobra.Command{
+		Use:   "instruments",
+		Short: "Refresh the instruments dump and list selected instruments",
+		Long: `Downloads the instruments dump for the configured exchanges. When a selection
+is given, the matching instruments are printed as a table. No authentication is needed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runInstruments(cmd, &opts)
+		},
+	}
+
+	opts.selection.register(cmd)
+	cmd.Flags().StringVar(&opts.live, "live", "", "List live derivative contracts on an underlying, e.g. RELIANCE")
+
+	return cmd
+}
+
+func runInstruments(cmd *cobra.Command, opts *instrumentsOptions) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	instrumentManager, closeStore, err := newInstrumentManager(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	if err := instrumentManager.DownloadInstruments(cmd.Context()); err != nil {
+		return fmt.Errorf("failed to download instruments: %w", err)
+	}
+
+	var list []instruments.Instrument
+	switch {
+	case opts.live != "":
+		list = instrumentManager.GetLiveContracts(opts.live, time.Now())
+
+	case opts.selection != (selectionFlags{}):
+		// Only explicit flags select here; the config's symbols list is for downloads
+		cfg.Symbols = nil
+		list, err = instrumentManager.Resolve(opts.selection.selection(cfg))
+		if err != nil {
+			return fmt.Errorf("failed to resolve symbols: %w", err)
+		}
+
+	default:
+		fmt.Printf("Loaded %d instruments (%d malformed rows skipped)\n",
+			len(instrumentManager.Instruments()), len(instrumentManager.MalformedRows()))
+		return nil
+	}
+
+	printInstruments(list)
+	return nil
+}
+
+// printInstruments writes instruments as an aligned table to stdout
+func printInstruments(list []instruments.Instrument) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TRADINGSYMBOL\tTOKEN\tEXCHANGE\tSEGMENT\tTYPE\tEXPIRY\tSTRIKE\tLOT\tUNDERLYING")
+	for _, i := range list {
+		expiry := ""
+		if !i.Expiry.IsZero() {
+			expiry = i.Expiry.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%g\t%d\t%s\n",
+			i.TradingSymbol, i.InstrumentToken, i.Exchange, i.Segment, i.InstrumentType,
+			expiry, i.StrikePrice, i.LotSize, i.Underlying)
+	}
+	w.Flush()
+	fmt.Printf("%d instruments\n", len(list))
+}
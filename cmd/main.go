@@ -2,20 +2,26 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
+	_ "time/tzdata" // output timezones must resolve in minimal containers
 
-	"github.com/sabarim/kitedata/internal/auth"
 	"github.com/sabarim/kitedata/internal/config"
 	"github.com/sabarim/kitedata/internal/historical"
+	_ "github.com/sabarim/kitedata/internal/influx" // registers the influx sink
 	"github.com/sabarim/kitedata/internal/instruments"
+	_ "github.com/sabarim/kitedata/internal/postgres" // registers the postgres sink
+	"github.com/sabarim/kitedata/internal/sqlite"
 	"github.com/spf13/cobra"
 )
 
+// Persistent flags shared by every subcommand
 var (
 	configFile     string
 	authServiceURL string
@@ -24,92 +30,101 @@ var (
 	apiKey         string
 	apiSecret      string
 	sessionToken   string
-	symbolsStr     string
-	symbolFile     string
-	fromDate       string
-	toDate         string
-	days           int
-	interval       string
 	outputDir      string
-	parquetEnabled bool
 	parquetDir     string
-	requestDelay   int
-	maxRetries     int
 	verbose        bool
-	version        bool
 )
 
 var version_string = "0.1.0"
 
+// exitPartial is the exit status of a download that saved some instruments
+// but not all, so schedulers can tell it from a run that saved nothing
+const exitPartial = 2
+
 func main() {
 	// Define the root command
 	rootCmd := &cobra.Command{
-		Use:   "kitedata",
-		Short: "A utility to download historical market data from Kite/Zerodha",
-		Long:  `A standalone utility for downloading historical market data from Kite/Zerodha broker and saving it in CSV or Parquet format.`,
-		Run:   runRootCommand,
-	}
-
-	// Define flags
-	rootCmd.Flags().StringVar(&configFile, "config", "config.yaml", "Path to config file")
-	rootCmd.Flags().StringVar(&authServiceURL, "auth-service-url", "", "URL of the auth service")
-	rootCmd.Flags().StringVar(&authServiceKey, "auth-service-key", "", "API key for the auth service")
-	rootCmd.Flags().StringVar(&brokerName, "broker", "", "Broker name (default is zerodha)")
-	rootCmd.Flags().StringVar(&apiKey, "api-key", "", "Broker API key (if not using auth service)")
-	rootCmd.Flags().StringVar(&apiSecret, "api-secret", "", "Broker API secret (if not using auth service)")
-	rootCmd.Flags().StringVar(&sessionToken, "session-token", "", "Broker session token (if not using auth service)")
-	rootCmd.Flags().StringVar(&symbolsStr, "symbols", "", "Comma-separated list of symbols to download")
-	rootCmd.Flags().StringVar(&symbolFile, "symbol-file", "", "File containing symbols, one per line")
-	rootCmd.Flags().StringVar(&fromDate, "from", "", "Start date (YYYY-MM-DD)")
-	rootCmd.Flags().StringVar(&toDate, "to", "", "End date (YYYY-MM-DD)")
-	rootCmd.Flags().IntVar(&days, "days", 0, "Number of days to fetch")
-	rootCmd.Flags().StringVar(&interval, "interval", "", "Time interval (minute, hour, day)")
-	rootCmd.Flags().StringVar(&outputDir, "output-dir", "", "Output directory for CSV files")
-	rootCmd.Flags().BoolVar(&parquetEnabled, "parquet", false, "Convert to Parquet format")
-	rootCmd.Flags().StringVar(&parquetDir, "parquet-dir", "", "Output directory for Parquet files")
-	rootCmd.Flags().IntVar(&requestDelay, "request-delay", 0, "Delay between requests in milliseconds")
-	rootCmd.Flags().IntVar(&maxRetries, "max-retries", 0, "Maximum number of retries for failed requests")
-	rootCmd.Flags().BoolVar(&verbose, "verbose", false, "Enable verbose logging")
-	rootCmd.Flags().BoolVar(&version, "version", false, "Print version information")
+		Use:           "kitedata",
+		Short:         "A utility to download historical market data from Kite/Zerodha",
+		Long:          `A standalone utility for downloading historical market data from Kite/Zerodha broker and saving it in CSV or Parquet format.`,
+		Version:       version_string,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	// Define persistent flags
+	flags := rootCmd.PersistentFlags()
+	flags.StringVar(&configFile, "config", "config.yaml", "Path to config file")
+	flags.StringVar(&authServiceURL, "auth-service-url", "", "URL of the auth service")
+	flags.StringVar(&authServiceKey, "auth-service-key", "", "API key for the auth service")
+	flags.StringVar(&brokerName, "broker", "", "Broker name (default is zerodha)")
+	flags.StringVar(&apiKey, "api-key", "", "Broker API key (if not using auth service)")
+	flags.StringVar(&apiSecret, "api-secret", "", "Broker API secret (if not using auth service)")
+	flags.StringVar(&sessionToken, "session-token", "", "Broker session token (if not using auth service)")
+	flags.StringVar(&outputDir, "output-dir", "", "Output directory for CSV files")
+	flags.StringVar(&parquetDir, "parquet-dir", "", "Output directory for Parquet files")
+	flags.BoolVar(&verbose, "verbose", false, "Enable verbose logging")
+
+	rootCmd.AddCommand(
+		newDownloadCommand(),
+		newInstrumentsCommand(),
+		newConvertCommand(),
+		newResampleCommand(),
+		newVerifyCommand(),
+		newQueryCommand(),
+		newServeCommand(),
+		newTicksCommand(),
+		newReplayCommand(),
+		newDaemonCommand(),
+		newAuthCommand(),
+		newConfigCommand(),
+	)
 
 	// Execute the command
-	if err := rootCmd.Execute(); err != nil {
+	if err := rootCmd.ExecuteContext(signalContext()); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		var partial *historical.PartialError
+		if errors.As(err, &partial) {
+			os.Exit(exitPartial)
+		}
 		os.Exit(1)
 	}
 }
 
-func runRootCommand(cmd *cobra.Command, args []string) {
-	// Check for version flag
-	if version {
-		fmt.Printf("kitedata version %s\n", version_string)
-		return
-	}
+// signalContext returns a context cancelled on SIGINT or SIGTERM
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigchan
+		log.Printf("Received signal %v, initiating shutdown...", sig)
+		cancel() // Cancel context to initiate shutdown
+	}()
 
-	// Print environment variables for debugging
-	fmt.Println("==== Environment Variables ====")
-	for _, env := range os.Environ() {
-		if strings.HasPrefix(env, "HISTORICAL_") {
-			fmt.Println(env)
+	return ctx
+}
+
+// loadConfig loads the configuration and applies the persistent flag overrides
+func loadConfig() (*config.Config, error) {
+	if verbose {
+		// Print environment variables for debugging
+		fmt.Println("==== Environment Variables ====")
+		for _, env := range os.Environ() {
+			if strings.HasPrefix(env, "HISTORICAL_") {
+				fmt.Println(env)
+			}
 		}
 	}
-	
-	// 1. Load configuration from file and environment
+
+	// Load configuration from file and environment
 	cfg, err := config.LoadConfig(configFile)
 	if err != nil {
-		log.Fatalf("Error loading configuration: %v", err)
-	}
-	
-	// Print loaded config for debugging
-	fmt.Println("==== Loaded Configuration ====")
-	fmt.Printf("Auth Service URL: %s\n", cfg.Auth.AuthServiceURL)
-	fmt.Printf("Auth Service API Key: %s\n", cfg.Auth.AuthServiceAPIKey)
-	fmt.Printf("Broker Name: %s\n", cfg.Auth.BrokerName)
-	fmt.Printf("API Key Set: %v\n", cfg.Auth.ApiKey != "")
-	fmt.Printf("Session Token Set: %v\n", cfg.Auth.SessionToken != "")
-	fmt.Println("==============================")
-
-	// 2. Override configuration with command-line flags
+		return nil, fmt.Errorf("error loading configuration: %w", err)
+	}
+
+	// Override configuration with command-line flags
 	if authServiceURL != "" {
 		cfg.Auth.AuthServiceURL = authServiceURL
 	}
@@ -128,104 +143,123 @@ func runRootCommand(cmd *cobra.Command, args []string) {
 	if sessionToken != "" {
 		cfg.Auth.SessionToken = sessionToken
 	}
-	if days > 0 {
-		cfg.Historical.DaysToFetch = days
-	}
-	if interval != "" {
-		cfg.Historical.Interval = interval
-	}
 	if outputDir != "" {
 		cfg.Historical.OutputDir = outputDir
 	}
-	if parquetEnabled {
-		cfg.Historical.ParquetEnabled = true
-	}
 	if parquetDir != "" {
 		cfg.Historical.ParquetDir = parquetDir
 	}
-	if requestDelay > 0 {
-		cfg.Historical.RequestDelay = requestDelay
+
+	if verbose {
+		// Print loaded config for debugging
+		fmt.Println("==== Loaded Configuration ====")
+		fmt.Printf("Auth Service URL: %s\n", cfg.Auth.AuthServiceURL)
+		fmt.Printf("Broker Name: %s\n", cfg.Auth.BrokerName)
+		fmt.Printf("API Key Set: %v\n", cfg.Auth.ApiKey != "")
+		fmt.Printf("Session Token Set: %v\n", cfg.Auth.SessionToken != "")
+		fmt.Println("==============================")
+	}
+
+	return &cfg, nil
+}
+
+// selectionFlags are the instrument selection flags shared by commands
+// that resolve symbols against the instruments dump
+type selectionFlags struct {
+	symbols    string
+	symbolFile string
+	universes  string
+	filter     string
+	exclude    string
+}
+
+// register adds the selection flags to a command
+func (sf *selectionFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&sf.symbols, "symbols", "", "Comma-separated list of symbols or symbol sources")
+	cmd.Flags().StringVar(&sf.symbolFile, "symbol-file", "", "File containing symbols, one per line")
+	cmd.Flags().StringVar(&sf.universes, "universe", "", "Comma-separated list of universes from config")
+	cmd.Flags().StringVar(&sf.filter, "filter", "", `Instrument filter expression, e.g. 'segment == "NFO-FUT" && name == "RELIANCE"'`)
+	cmd.Flags().StringVar(&sf.exclude, "exclude", "", "Comma-separated list of symbols or symbol sources to skip")
+}
+
+// selection combines the flags with the config's symbol lists.
+// Command-line sources, including --filter, replace the config's symbols
+// list and filter when given.
+func (sf *selectionFlags) selection(cfg *config.Config) instruments.Selection {
+	selection := instruments.Selection{
+		Include:   cfg.Symbols,
+		Exclude:   cfg.Exclude,
+		Universes: cfg.Universes,
 	}
-	if maxRetries > 0 {
-		cfg.Historical.MaxRetries = maxRetries
+	if cfg.Filter != "" {
+		selection.Include = append(selection.Include, "filter:"+cfg.Filter)
 	}
 
-	// 3. Create context that can be cancelled
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	var cliSources []string
+	cliSources = append(cliSources, splitFlag(sf.symbols)...)
+	if sf.symbolFile != "" {
+		cliSources = append(cliSources, "file://"+sf.symbolFile)
+	}
+	for _, universe := range splitFlag(sf.universes) {
+		cliSources = append(cliSources, "@"+universe)
+	}
+	if sf.filter != "" {
+		cliSources = append(cliSources, "filter:"+sf.filter)
+	}
+	if len(cliSources) > 0 {
+		selection.Include = cliSources
+	}
+	selection.Exclude = append(selection.Exclude, splitFlag(sf.exclude)...)
 
-	// 4. Handle OS signals
-	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		sig := <-sigchan
-		log.Printf("Received signal %v, initiating shutdown...", sig)
-		cancel() // Cancel context to initiate shutdown
-	}()
+	return selection
+}
 
-	// 5. Initialize authentication
-	authManager := auth.NewAuthManager(&cfg)
-	
-	// 6. Get authenticated client
-	fmt.Println("Authenticating with Kite...")
-	kiteClient, err := authManager.GetClient()
-	if err != nil {
-		log.Fatalf("Failed to authenticate with Kite: %v", err)
-	}
-	
-	// 7. Initialize instrument manager
-	instrumentManager := instruments.NewInstrumentManager(&cfg)
-	
-	// 8. Download instruments data
-	if err := instrumentManager.DownloadInstruments(); err != nil {
-		log.Fatalf("Failed to download instruments: %v", err)
-	}
-	
-	// 9. Determine symbols to download
-	var symbols []string
-	
-	// First try symbols from command line
-	if symbolsStr != "" {
-		symbols = strings.Split(symbolsStr, ",")
-	} else if symbolFile != "" {
-		// Try symbols from file
-		content, err := os.ReadFile(symbolFile)
-		if err != nil {
-			log.Fatalf("Failed to read symbol file: %v", err)
-		}
-		lines := strings.Split(string(content), "\n")
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if line != "" {
-				symbols = append(symbols, line)
-			}
-		}
-	} else {
-		log.Fatalf("No symbols specified. Use --symbols or --symbol-file")
+// newInstrumentManager creates the instrument manager, saving downloaded
+// instruments to the SQLite database when the sqlite sink is selected.
+// The returned function closes the database.
+func newInstrumentManager(cfg *config.Config) (*instruments.InstrumentManager, func(), error) {
+	instrumentManager := instruments.NewInstrumentManager(cfg)
+	if !slices.Contains(historical.SinksFromConfig(cfg.Historical), "sqlite") {
+		return instrumentManager, func() {}, nil
 	}
-	
-	// 10. Get instrument objects for the specified symbols
-	instrumentsList, err := instrumentManager.GetInstrumentsForSymbols(symbols)
+
+	db, err := sqlite.Open(context.Background(), cfg.Historical.SQLitePath)
 	if err != nil {
-		log.Fatalf("Failed to get instruments: %v", err)
+		return nil, nil, err
 	}
-	
-	if len(instrumentsList) == 0 {
-		log.Fatalf("No valid instruments found for the specified symbols")
+	instrumentManager.SetStore(db)
+	return instrumentManager, func() {
+		if err := db.Close(); err != nil {
+			log.Printf("Error closing %s: %v", db.Path, err)
+		}
+	}, nil
+}
+
+// seriesLookup describes stored symbols using the saved instruments dumps,
+// so offline commands can record token, exchange and tick size
+func seriesLookup(cfg *config.Config, interval string) func(symbol string) historical.Series {
+	instrumentManager := instruments.NewInstrumentManager(cfg)
+	if err := instrumentManager.LoadSaved(); err != nil {
+		log.Printf("Warning: %v", err)
 	}
-	
-	log.Printf("Found %d instruments to download", len(instrumentsList))
-	
-	// 11. Initialize historical downloader
-	histDownloader, err := historical.NewHistoricalDownloader(&cfg, kiteClient)
-	if err != nil {
-		log.Fatalf("Failed to initialize historical downloader: %v", err)
+	return func(symbol string) historical.Series {
+		series := historical.Series{Symbol: symbol, Interval: interval}
+		if instrument, err := instrumentManager.GetInstrumentBySymbol(symbol); err == nil {
+			series.Token = instrument.InstrumentToken
+			series.Exchange = instrument.Exchange
+			series.TickSize = instrument.TickSize
+		}
+		return series
 	}
-	
-	// 12. Download historical data
-	if err := histDownloader.DownloadHistoricalData(ctx, instrumentsList); err != nil {
-		log.Fatalf("Failed to download historical data: %v", err)
+}
+
+// splitFlag splits a comma-separated flag value, dropping empty items
+func splitFlag(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
-	
-	log.Println("Historical data download completed successfully")
-}
\ No newline at end of file
+	return items
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sabarim/kitedata/internal/fsutil"
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/spf13/cobra"
+)
+
+func newQueryCommand() *cobra.Command {
+	var symbol, interval, from, to, source, format, output string
+
+	cmd := &cobra.Command{
+		Use:   "query",
+		Short: "Print or export stored candles for a symbol",
+		Long: `Reads a symbol's candles from the monthly Parquet partitions, or from the
+CSV when there are none, and prints them as a table, CSV, JSON, JSON Lines or an Arrow IPC stream.
+Only the partitions overlapping --from/--to are opened. Dates are read in the
+configured timezone; a --to date includes that whole day. No authentication is needed.`,
+		Example: `  kitedata query --symbol RELIANCE --interval minute --from 2024-01-01 --to 2024-01-31
+  kitedata query --symbol RELIANCE --from 2024-01-01 --format csv --output reliance.csv`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			if interval == "" {
+				interval = cfg.Historical.Interval
+			}
+			kiteInterval, err := historical.KiteInterval(interval)
+			if err != nil {
+				return err
+			}
+			csvInterval, err := historical.KiteInterval(cfg.Historical.Interval)
+			if err != nil {
+				return err
+			}
+			parquetOptions, err := historical.ParquetOptionsFromConfig(cfg.Historical)
+			if err != nil {
+				return err
+			}
+			csvOptions, err := historical.CSVOptionsFromConfig(cfg.Historical)
+			if err != nil {
+				return err
+			}
+
+			opts := historical.QueryOptions{
+				Series:      seriesLookup(cfg, kiteInterval)(symbol),
+				Source:      source,
+				OutputDir:   cfg.Historical.OutputDir,
+				ParquetDir:  cfg.Historical.ParquetDir,
+				CSV:         csvOptions,
+				Parquet:     parquetOptions,
+				CSVInterval: csvInterval,
+			}
+			if opts.From, err = historical.ParseQueryTime(from, csvOptions.Location, false); err != nil {
+				return err
+			}
+			if opts.To, err = historical.ParseQueryTime(to, csvOptions.Location, true); err != nil {
+				return err
+			}
+
+			candles, err := historical.Query(cmd.Context(), opts)
+			if err != nil {
+				return err
+			}
+
+			write := func(w io.Writer) error {
+				return historical.WriteCandles(w, format, opts.Series, candles, csvOptions)
+			}
+			if output == "" {
+				return write(os.Stdout)
+			}
+			if err := fsutil.WriteFile(output, write); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Wrote %d candles to %s\n", len(candles), output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&symbol, "symbol", "", "Symbol to query (required)")
+	cmd.Flags().StringVar(&interval, "interval", "", "Candle interval (default from config)")
+	cmd.Flags().StringVar(&from, "from", "", "Start date (YYYY-MM-DD) or time (RFC3339)")
+	cmd.Flags().StringVar(&to, "to", "", "End date (YYYY-MM-DD, inclusive) or time (RFC3339)")
+	cmd.Flags().StringVar(&source, "source", historical.QuerySourceAuto, "Store to read: auto, parquet or csv")
+	cmd.Flags().StringVar(&format, "format", historical.FormatTable, "Output format: table, csv, json, jsonl or arrow")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write to a file instead of stdout")
+	cmd.MarkFlagRequired("symbol")
+
+	return cmd
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/auth"
+	"github.com/sabarim/kitedata/internal/fsutil"
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/sabarim/kitedata/internal/instruments"
+	"github.com/sabarim/kitedata/internal/ticks"
+	"github.com/spf13/cobra"
+)
+
+// reconcileOptions holds the flags of the ticks reconcile command
+type reconcileOptions struct {
+	date    string
+	candles string
+	symbols string
+	dir     string
+}
+
+func newTicksReconcileCommand() *cobra.Command {
+	var opts reconcileOptions
+
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Compare candles built from a day's ticks with Kite's historical candles",
+		Long: `Builds candles from a day's tick file the same way the ticks command does and
+compares them with the candles Kite's historical API returns for that day.
+Every missing or extra candle and every differing price or volume is written
+to <ticks dir>/reconcile_<YYYY-MM-DD>.csv, and a summary per series is printed.`,
+		Example: `  kitedata ticks reconcile
+  kitedata ticks reconcile --date 2024-06-14 --candles minute,5minute --symbols RELIANCE`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runReconcile(cmd, &opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.date, "date", "", "Day to reconcile, YYYY-MM-DD (default today)")
+	cmd.Flags().StringVar(&opts.candles, "candles", "", "Comma-separated intervals to compare (default from config, or minute)")
+	cmd.Flags().StringVar(&opts.symbols, "symbols", "", "Comma-separated symbols to compare (default all in the tick file)")
+	cmd.Flags().StringVar(&opts.dir, "dir", "", "Directory of the daily tick files (default from config)")
+
+	return cmd
+}
+
+func runReconcile(cmd *cobra.Command, opts *reconcileOptions) error {
+	// 1. Load configuration from file, environment and flags
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if opts.dir != "" {
+		cfg.Ticks.Dir = opts.dir
+	}
+	intervals := cfg.Ticks.Candles
+	if opts.candles != "" {
+		intervals = splitFlag(opts.candles)
+	}
+	if len(intervals) == 0 {
+		intervals = []string{"minute"}
+	}
+	location, err := historical.LoadTimezone(cfg.Historical.Timezone)
+	if err != nil {
+		return err
+	}
+	day := time.Now().In(location)
+	if opts.date != "" {
+		if day, err = time.ParseInLocation("2006-01-02", opts.date, location); err != nil {
+			return fmt.Errorf("invalid date: %w", err)
+		}
+	}
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, location)
+	to := from.AddDate(0, 0, 1).Add(-time.Second)
+
+	// 2. Build the day's candles from its tick file. The saved instruments
+	// dumps provide tick sizes and which instruments have open interest.
+	instrumentManager := instruments.NewInstrumentManager(cfg)
+	if err := instrumentManager.LoadSaved(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	byToken := make(map[int64]instruments.Instrument)
+	for _, inst := range instrumentManager.Instruments() {
+		byToken[inst.InstrumentToken] = inst
+	}
+
+	builder, err := ticks.NewCandleBuilder(intervals, instrumentManager.Instruments())
+	if err != nil {
+		return err
+	}
+	symbols := splitFlag(opts.symbols)
+	tickFile := ticks.Path(cfg.Ticks.Dir, from)
+	err = ticks.ReadFile(tickFile, func(tick ticks.Tick) error {
+		if len(symbols) == 0 || slices.Contains(symbols, tick.Symbol) {
+			builder.Add(tick)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	builder.Finish()
+	built := builder.Updated()
+	if len(built) == 0 {
+		return fmt.Errorf("no candles could be built from %s", tickFile)
+	}
+	log.Printf("Built %d series from %s", len(built), tickFile)
+
+	// 3. Fetch Kite's candles for the same day
+	authManager := auth.NewAuthManager(cfg)
+	fmt.Println("Authenticating with Kite...")
+	kiteClient, err := authManager.GetClient()
+	if err != nil {
+		return fmt.Errorf("failed to authenticate with Kite: %w", err)
+	}
+	downloader := historical.NewHistoricalDownloaderWithSink(cfg, kiteClient, nil)
+
+	var results []ticks.Reconciliation
+	failed := 0
+	for i, sc := range built {
+		if i > 0 {
+			select {
+			case <-cmd.Context().Done():
+				return cmd.Context().Err()
+			case <-time.After(time.Duration(cfg.Historical.RequestDelay) * time.Millisecond):
+			}
+		}
+
+		inst, ok := byToken[sc.Series.Token]
+		if !ok {
+			inst = instruments.Instrument{
+				InstrumentToken: sc.Series.Token,
+				TradingSymbol:   sc.Series.Symbol,
+				Exchange:        sc.Series.Exchange,
+			}
+		}
+		_, fetched, err := downloader.FetchCandles(cmd.Context(), inst, sc.Series.Interval, from, to)
+		if err != nil {
+			log.Printf("Error fetching %s candles for %s: %v", sc.Series.Interval, sc.Series.Symbol, err)
+			failed++
+			continue
+		}
+
+		// 4. Compare them with the built candles
+		results = append(results, ticks.Reconcile(sc.Series, sc.Candles, fetched))
+	}
+
+	// 5. Save the differences and print a summary
+	report := ticks.ReportPath(cfg.Ticks.Dir, from)
+	err = fsutil.WriteFile(report, func(w io.Writer) error {
+		return ticks.WriteReport(w, results, location)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save report: %w", err)
+	}
+	printReconciliations(results)
+	log.Printf("Saved reconciliation report to %s", report)
+
+	if failed > 0 {
+		return fmt.Errorf("failed to fetch %d of %d series", failed, len(built))
+	}
+	return nil
+}
+
+// printReconciliations writes one row per series with its difference counts
+func printReconciliations(results []ticks.Reconciliation) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "SYMBOL\tINTERVAL\tBUILT\tKITE")
+	for _, field := range ticks.ReconcileFields {
+		fmt.Fprintf(w, "\t%s", strings.ToUpper(field))
+	}
+	fmt.Fprintln(w)
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d", r.Series.Symbol, r.Series.Interval, r.Built, r.Fetched)
+		for _, field := range ticks.ReconcileFields {
+			fmt.Fprintf(w, "\t%d", r.Count(field))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/sabarim/kitedata/internal/ticks"
+	"github.com/spf13/cobra"
+)
+
+// replayTimeLayouts are the local date-time forms accepted besides dates and RFC3339
+var replayTimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+func newReplayCommand() *cobra.Command {
+	var from, to, speed, addr, dir string
+
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Replay stored ticks over a local Kite ticker WebSocket",
+		Long: `Serves the ticks stored by the ticks command over a WebSocket that speaks Kite's
+ticker protocol: clients subscribe and set modes as on Kite and receive the
+binary tick packets and heartbeats Kite sends. Point a Kite ticker client at
+ws://<addr> instead of wss://ws.kite.trade to run it against a recorded day.
+
+Each connection replays the range from --from to --to once it subscribes,
+at --speed times the recorded pace (max sends as fast as the client reads).
+Ticks are paced by when they were received; the time between days is skipped.
+Times are read in the configured timezone. No authentication is needed.`,
+		Example: `  kitedata replay --from 2024-06-14
+  kitedata replay --from "2024-06-14 09:15" --to "2024-06-14 10:30" --speed 10x
+  kitedata replay --from 2024-06-10 --to 2024-06-14 --speed max --addr :8765`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if addr != "" {
+				cfg.Ticks.ReplayAddr = addr
+			}
+			if dir != "" {
+				cfg.Ticks.Dir = dir
+			}
+			location, err := historical.LoadTimezone(cfg.Historical.Timezone)
+			if err != nil {
+				return err
+			}
+
+			if from == "" {
+				return fmt.Errorf("--from is required")
+			}
+			replay := &ticks.Replay{Dir: cfg.Ticks.Dir, Location: location}
+			if replay.From, err = parseReplayTime(from, location, false); err != nil {
+				return err
+			}
+			if to == "" {
+				// Without --to the replay ends with the day it starts on
+				to = replay.From.In(location).Format("2006-01-02")
+			}
+			if replay.To, err = parseReplayTime(to, location, true); err != nil {
+				return err
+			}
+			if replay.To.Before(replay.From) {
+				return fmt.Errorf("--to is before --from")
+			}
+			if replay.Speed, err = ticks.ParseSpeed(speed); err != nil {
+				return err
+			}
+
+			files := replay.Files()
+			if len(files) == 0 {
+				return fmt.Errorf("no tick files in %s between %s and %s", cfg.Ticks.Dir,
+					replay.From.In(location).Format("2006-01-02"), replay.To.In(location).Format("2006-01-02"))
+			}
+			fmt.Printf("Replaying %d day(s) of ticks from %s at %s speed\n", len(files), cfg.Ticks.Dir, speed)
+			return replay.ListenAndServe(cmd.Context(), cfg.Ticks.ReplayAddr)
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "Start date (YYYY-MM-DD), local time (\"YYYY-MM-DD HH:MM\") or RFC3339 (required)")
+	cmd.Flags().StringVar(&to, "to", "", "End date (inclusive), local time or RFC3339 (default end of the --from day)")
+	cmd.Flags().StringVar(&speed, "speed", "1x", "Replay speed, e.g. 1x, 10x, or max for as fast as possible")
+	cmd.Flags().StringVar(&addr, "addr", "", "Address to listen on (default from config, localhost:8765)")
+	cmd.Flags().StringVar(&dir, "dir", "", "Directory of the daily tick files (default from config)")
+
+	return cmd
+}
+
+// parseReplayTime reads a local date and time, or a date or RFC3339 time
+// as query does
+func parseReplayTime(value string, loc *time.Location, endOfDay bool) (time.Time, error) {
+	for _, layout := range replayTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	t, err := historical.ParseQueryTime(value, loc, endOfDay)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (use YYYY-MM-DD, \"YYYY-MM-DD HH:MM\" or RFC3339)", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/spf13/cobra"
+)
+
+func newResampleCommand() *cobra.Command {
+	var symbols, interval string
+
+	cmd := &cobra.Command{
+		Use:   "resample",
+		Short: "Aggregate downloaded CSV candles into a coarser interval",
+		Long: `Reads <SYMBOL>_historical.csv files from the output directory and writes
+<SYMBOL>_<interval>.csv next to them, using the configured CSV format. No authentication is needed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if _, err := historical.IntervalDuration(interval); err != nil {
+				return err
+			}
+			csvOptions, err := historical.CSVOptionsFromConfig(cfg.Historical)
+			if err != nil {
+				return err
+			}
+			series := seriesLookup(cfg, interval)
+
+			// Remove temporary files left behind by interrupted runs
+			historical.CleanTempFiles(cfg.Historical.OutputDir)
+
+			list := splitFlag(symbols)
+			if len(list) == 0 {
+				if list, err = historical.StoredSymbols(cfg.Historical.OutputDir); err != nil {
+					return err
+				}
+			}
+
+			failed := 0
+			for _, symbol := range list {
+				if err := cmd.Context().Err(); err != nil {
+					return err
+				}
+
+				candles, err := historical.ReadCSV(csvOptions.Path(cfg.Historical.OutputDir, symbol), csvOptions)
+				if err != nil {
+					log.Printf("Error reading data for %s: %v, skipping...", symbol, err)
+					failed++
+					continue
+				}
+				resampled, err := historical.ResampleCandles(candles, interval)
+				if err != nil {
+					log.Printf("Error resampling data for %s: %v, skipping...", symbol, err)
+					failed++
+					continue
+				}
+
+				filename := filepath.Join(cfg.Historical.OutputDir, symbol, symbol+"_"+interval+csvOptions.Extension())
+				if err := historical.WriteCSV(filename, series(symbol), resampled, csvOptions); err != nil {
+					log.Printf("Error saving data for %s: %v", symbol, err)
+					failed++
+					continue
+				}
+				log.Printf("Resampled %d candles into %d %s candles: %s", len(candles), len(resampled), interval, filename)
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("failed to resample %d of %d symbols", failed, len(list))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&symbols, "symbols", "", "Comma-separated list of symbols (default: every symbol in the output directory)")
+	cmd.Flags().StringVar(&interval, "interval", "", "Target interval (3minute, 5minute, 10minute, 15minute, 30minute, hour, day)")
+	cmd.MarkFlagRequired("interval")
+
+	return cmd
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/sabarim/kitedata/internal/instruments"
+	"github.com/sabarim/kitedata/internal/server"
+	"github.com/spf13/cobra"
+)
+
+func newServeCommand() *cobra.Command {
+	var addr string
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve stored candles over an HTTP API",
+		Long: `Starts an HTTP server that answers from the local Parquet and CSV store:
+
+  GET /api/instruments   stored symbols with their instrument details
+  GET /api/coverage      first and last candle, count and days per symbol and interval
+  GET /api/candles       a symbol's candles for a range as JSON, JSON Lines, CSV or Arrow,
+                         limited to server.max_range_days per request
+
+Set server.api_key to require an API key, sent as "Authorization: Bearer <key>"
+or an X-API-Key header. No broker authentication is needed.`,
+		Example: `  kitedata serve --addr :8080
+  curl 'http://localhost:8080/api/candles?symbol=RELIANCE&from=2024-01-01&to=2024-01-31&format=csv'`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if addr != "" {
+				cfg.Server.Addr = addr
+			}
+
+			interval, err := historical.KiteInterval(cfg.Historical.Interval)
+			if err != nil {
+				return err
+			}
+			parquetOptions, err := historical.ParquetOptionsFromConfig(cfg.Historical)
+			if err != nil {
+				return err
+			}
+			csvOptions, err := historical.CSVOptionsFromConfig(cfg.Historical)
+			if err != nil {
+				return err
+			}
+
+			// Instrument details are optional; symbols are served either way
+			instrumentManager := instruments.NewInstrumentManager(cfg)
+			if err := instrumentManager.LoadSaved(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+
+			srv := &server.Server{
+				Query: historical.QueryOptions{
+					OutputDir:   cfg.Historical.OutputDir,
+					ParquetDir:  cfg.Historical.ParquetDir,
+					CSV:         csvOptions,
+					Parquet:     parquetOptions,
+					CSVInterval: interval,
+				},
+				Instruments: instrumentManager,
+				Interval:    interval,
+				APIKey:      cfg.Server.APIKey,
+				MaxRange:    time.Duration(cfg.Server.MaxRangeDays) * 24 * time.Hour,
+			}
+			if srv.APIKey == "" {
+				log.Printf("Warning: no server.api_key set, the API is open to anyone who can reach %s", cfg.Server.Addr)
+			}
+			return srv.ListenAndServe(cmd.Context(), cfg.Server.Addr)
+		},
+	}
+
+	cmd.Flags().StringVar(&addr, "addr", "", "Address to listen on (default from config, localhost:8080)")
+
+	return cmd
+}
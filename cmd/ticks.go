@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/auth"
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/sabarim/kitedata/internal/ticks"
+	"github.com/spf13/cobra"
+)
+
+// ticksOptions holds the flags of the ticks command
+type ticksOptions struct {
+	selection selectionFlags
+	mode      string
+	dir       string
+	rootURL   string
+	candles   string
+}
+
+func newTicksCommand() *cobra.Command {
+	var opts ticksOptions
+
+	cmd := &cobra.Command{
+		Use:   "ticks",
+		Short: "Capture live ticks for the selected instruments",
+		Long: `Subscribes to the selected instruments on the Kite WebSocket ticker and appends
+every tick to <ticks dir>/ticks_<YYYY-MM-DD>.jsonl, one file per day. The
+connection is re-established and the instruments subscribed again when it
+drops. Runs until interrupted.
+
+With --candles the ticks are also aggregated into candles of those intervals,
+which are written through the configured sinks as they complete. File outputs
+go to <candles dir>/<interval>/<YYYY-MM-DD> rather than the download
+directories. A restarted capture rebuilds the day's candles from the ticks
+already recorded.`,
+		Example: `  kitedata ticks --symbols RELIANCE,INFY --mode full
+  kitedata ticks --filter 'segment == "NFO-FUT" && expiry >= today' --mode quote
+  kitedata ticks --universe nifty50 --candles minute,5minute`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTicks(cmd, &opts)
+		},
+	}
+
+	opts.selection.register(cmd)
+	cmd.Flags().StringVar(&opts.mode, "mode", "", "Tick mode: ltp, quote or full (default from config)")
+	cmd.Flags().StringVar(&opts.dir, "dir", "", "Directory for the daily tick files (default from config)")
+	cmd.Flags().StringVar(&opts.rootURL, "root-url", "", "Ticker WebSocket URL (default from config)")
+	cmd.Flags().StringVar(&opts.candles, "candles", "", "Comma-separated intervals to build candles for, e.g. minute,5minute (default from config)")
+
+	cmd.AddCommand(newTicksReconcileCommand())
+	return cmd
+}
+
+func runTicks(cmd *cobra.Command, opts *ticksOptions) error {
+	// 1. Load configuration from file, environment and flags
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if opts.mode != "" {
+		cfg.Ticks.Mode = opts.mode
+	}
+	if opts.dir != "" {
+		cfg.Ticks.Dir = opts.dir
+	}
+	if opts.rootURL != "" {
+		cfg.Ticks.RootURL = opts.rootURL
+	}
+	if opts.candles != "" {
+		cfg.Ticks.Candles = splitFlag(opts.candles)
+	}
+
+	mode, err := ticks.ParseMode(cfg.Ticks.Mode)
+	if err != nil {
+		return err
+	}
+	location, err := historical.LoadTimezone(cfg.Historical.Timezone)
+	if err != nil {
+		return err
+	}
+
+	// Check the candle outputs first so bad sink settings fail before any network work
+	var candleSinks *ticks.CandleSinks
+	if len(cfg.Ticks.Candles) > 0 {
+		candleSinks, err = newCandleSinks(cfg, cfg.Ticks.Candles)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 2. Determine symbols to capture before doing any network work
+	selection := opts.selection.selection(cfg)
+	if len(selection.Include) == 0 {
+		return fmt.Errorf("no symbols specified. Use --symbols, --symbol-file, --universe, --filter or the symbols list in config")
+	}
+
+	// 3. Get the credentials the ticker connects with
+	authManager := auth.NewAuthManager(cfg)
+	fmt.Println("Authenticating with Kite...")
+	creds, err := authManager.Login()
+	if err != nil {
+		return fmt.Errorf("failed to authenticate with Kite: %w", err)
+	}
+
+	// 4. Download instruments data and resolve the selection
+	instrumentManager, closeStore, err := newInstrumentManager(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	if err := instrumentManager.DownloadInstruments(cmd.Context()); err != nil {
+		return fmt.Errorf("failed to download instruments: %w", err)
+	}
+
+	instrumentsList, err := instrumentManager.Resolve(selection)
+	if err != nil {
+		return fmt.Errorf("failed to resolve symbols: %w", err)
+	}
+	if len(instrumentsList) == 0 {
+		return fmt.Errorf("no valid instruments found for the specified symbols")
+	}
+
+	log.Printf("Found %d instruments to capture", len(instrumentsList))
+
+	// 5. Capture ticks until interrupted
+	capture := &ticks.Capture{
+		APIKey:      creds.ApiKey,
+		AccessToken: creds.SessionToken,
+		RootURL:     cfg.Ticks.RootURL,
+		Mode:        mode,
+		Instruments: instrumentsList,
+		Writer:      ticks.NewWriter(cfg.Ticks.Dir, location),
+	}
+	if candleSinks != nil {
+		capture.Candles, err = ticks.NewCandleBuilder(cfg.Ticks.Candles, instrumentsList)
+		if err != nil {
+			return err
+		}
+		capture.Sinks = candleSinks
+		log.Printf("Building %s candles in %s", strings.Join(capture.Candles.Intervals(), ", "), cfg.Ticks.CandlesDir)
+	}
+	if err := capture.Run(cmd.Context()); err != nil {
+		return fmt.Errorf("tick capture failed: %w", err)
+	}
+
+	log.Println("Tick capture stopped")
+	return nil
+}
+
+// newCandleSinks creates the configured sinks per interval and day, with
+// file outputs under <candles dir>/<interval>/<day> so intervals, days and
+// downloads never overwrite each other's files
+func newCandleSinks(cfg *config.Config, intervals []string) (*ticks.CandleSinks, error) {
+	open := func(interval, day string) (files, merge historical.Sink, err error) {
+		candleCfg := *cfg
+		candleCfg.Historical.OutputDir = filepath.Join(cfg.Ticks.CandlesDir, interval, day)
+		candleCfg.Historical.ParquetDir = filepath.Join(cfg.Ticks.CandlesDir, interval, day, "parquet")
+		var fileList, mergeList []historical.Sink
+		for _, name := range historical.SinksFromConfig(cfg.Historical) {
+			sink, err := historical.NewSink(name, &candleCfg)
+			if err != nil {
+				return nil, nil, err
+			}
+			if historical.ReplacesSeries(name) {
+				fileList = append(fileList, sink)
+			} else {
+				mergeList = append(mergeList, sink)
+			}
+		}
+		return combineSinks(fileList), combineSinks(mergeList), nil
+	}
+
+	for _, name := range intervals {
+		interval, err := historical.KiteInterval(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := open(interval, time.Now().Format("2006-01-02")); err != nil {
+			return nil, err
+		}
+	}
+	return &ticks.CandleSinks{Open: open}, nil
+}
+
+// combineSinks returns nil for no sinks and a sink writing to all of them
+// otherwise
+func combineSinks(sinks []historical.Sink) historical.Sink {
+	switch len(sinks) {
+	case 0:
+		return nil
+	case 1:
+		return sinks[0]
+	}
+	return historical.MultiSink(sinks...)
+}
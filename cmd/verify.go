@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/spf13/cobra"
+)
+
+// maxReportedIssues limits how many issues are printed per file
+const maxReportedIssues = 20
+
+func newVerifyCommand() *cobra.Command {
+	var symbols, interval string
+	var checkParquet bool
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check downloaded files for unreadable data, duplicates, gaps and bad candles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if interval == "" {
+				interval = cfg.Historical.Interval
+			}
+
+			parquetOptions, err := historical.ParquetOptionsFromConfig(cfg.Historical)
+			if err != nil {
+				return err
+			}
+			csvOptions, err := historical.CSVOptionsFromConfig(cfg.Historical)
+			if err != nil {
+				return err
+			}
+			kiteInterval, err := historical.KiteInterval(interval)
+			if err != nil {
+				return err
+			}
+
+			list := splitFlag(symbols)
+			if len(list) == 0 {
+				if list, err = historical.StoredSymbols(cfg.Historical.OutputDir); err != nil {
+					return err
+				}
+			}
+
+			// Collect every file to check per symbol
+			var files []string
+			for _, symbol := range list {
+				files = append(files, csvOptions.Path(cfg.Historical.OutputDir, symbol))
+				if checkParquet {
+					parquetFiles, err := parquetOptions.Layout.Files(cfg.Historical.ParquetDir, symbol, kiteInterval)
+					if err != nil {
+						return err
+					}
+					files = append(files, parquetFiles...)
+				}
+			}
+
+			problems := 0
+			for _, file := range files {
+				if err := cmd.Context().Err(); err != nil {
+					return err
+				}
+
+				var candles []historical.HistoricalCandle
+				if historical.IsParquetFile(file) {
+					candles, err = historical.ReadParquet(file)
+				} else {
+					candles, err = historical.ReadCSV(file, csvOptions)
+				}
+				if err != nil {
+					log.Printf("%s: unreadable: %v", file, err)
+					problems++
+					continue
+				}
+
+				issues, err := historical.VerifyCandles(candles, interval)
+				if err != nil {
+					return err
+				}
+				for i, issue := range issues {
+					if i == maxReportedIssues {
+						log.Printf("%s: %d more issues not shown", file, len(issues)-i)
+						break
+					}
+					log.Printf("%s: %s", file, issue)
+				}
+				if len(issues) > 0 {
+					problems++
+				}
+				log.Printf("%s: %d candles, %d issues", file, len(candles), len(issues))
+			}
+
+			if problems > 0 {
+				return fmt.Errorf("%d of %d files have problems", problems, len(files))
+			}
+			fmt.Printf("Verified %d files, no problems found\n", len(files))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&symbols, "symbols", "", "Comma-separated list of symbols (default: every symbol in the output directory)")
+	cmd.Flags().StringVar(&interval, "interval", "", "Interval the files were downloaded at, used to detect gaps (default from config)")
+	cmd.Flags().BoolVar(&checkParquet, "parquet", false, "Also check the monthly Parquet files")
+
+	return cmd
+}
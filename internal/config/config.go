@@ -2,15 +2,26 @@ package config
 
 import (
 	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 // Config defines the application configuration structure
 type Config struct {
-	Auth       AuthConfig       `mapstructure:"auth"`
-	Broker     BrokerConfig     `mapstructure:"broker"`
-	Historical HistoricalConfig `mapstructure:"historical"`
+	Auth       AuthConfig          `mapstructure:"auth"`
+	Broker     BrokerConfig        `mapstructure:"broker"`
+	Historical HistoricalConfig    `mapstructure:"historical"`
+	Server     ServerConfig        `mapstructure:"server"`
+	Ticks      TicksConfig         `mapstructure:"ticks"`
+	Daemon     DaemonConfig        `mapstructure:"daemon"`
+	Filter     string              `mapstructure:"filter"`
+	Symbols    []string            `mapstructure:"symbols"`
+	Exclude    []string            `mapstructure:"exclude"`
+	Universes  map[string][]string `mapstructure:"-"` // decoded by decodeUniverses
 }
 
 // AuthConfig defines authentication configuration
@@ -25,19 +36,118 @@ type AuthConfig struct {
 
 // BrokerConfig defines the broker configuration
 type BrokerConfig struct {
-	InstrumentsNSEURL string `mapstructure:"instruments_nse_url"`
+	InstrumentsNSEURL  string   `mapstructure:"instruments_nse_url"`
+	InstrumentsBaseURL string   `mapstructure:"instruments_base_url"`
+	Exchanges          []string `mapstructure:"exchanges"`
+}
+
+// ServerConfig defines the HTTP API started by the serve command
+type ServerConfig struct {
+	Addr string `mapstructure:"addr"`
+	// APIKey, when set, must accompany every request except health checks
+	APIKey string `mapstructure:"api_key"`
+	// MaxRangeDays is the longest range one candles request may ask for
+	MaxRangeDays int `mapstructure:"max_range_days"`
+}
+
+// TicksConfig defines live tick capture by the ticks command
+type TicksConfig struct {
+	Dir string `mapstructure:"dir"`
+	// Mode is ltp, quote or full; full adds five levels of market depth
+	Mode string `mapstructure:"mode"`
+	// RootURL is the ticker WebSocket endpoint, replaceable for testing
+	RootURL string `mapstructure:"root_url"`
+	// Candles lists the intervals built from the ticks as they arrive
+	Candles []string `mapstructure:"candles"`
+	// CandlesDir replaces output_dir and parquet_dir for the built candles
+	CandlesDir string `mapstructure:"candles_dir"`
+	// ReplayAddr is where the replay command serves the stored ticks
+	ReplayAddr string `mapstructure:"replay_addr"`
+}
+
+// DaemonConfig defines the scheduled jobs run by the daemon command
+type DaemonConfig struct {
+	// Dir holds the job history, run logs and the lock against a second daemon
+	Dir string `mapstructure:"dir"`
+	// Timezone is the exchange timezone schedules and calendars are read
+	// in, independent of the timezone candles are written in
+	Timezone string `mapstructure:"timezone"`
+	// Calendars are exchange calendars by name, looked up case-insensitively
+	Calendars map[string]CalendarConfig `mapstructure:"calendars"`
+	Jobs      []JobConfig               `mapstructure:"jobs"`
+}
+
+// CalendarConfig lists the days an exchange deviates from trading Monday
+// to Friday. Dates are YYYY-MM-DD.
+type CalendarConfig struct {
+	Holidays []string `mapstructure:"holidays" yaml:"holidays"`
+	// HolidaysFile adds one date per line; # starts a comment
+	HolidaysFile string `mapstructure:"holidays_file" yaml:"holidays_file"`
+	// Sessions are weekend days the exchange trades, such as special sessions
+	Sessions []string `mapstructure:"sessions" yaml:"sessions"`
+}
+
+// JobConfig defines one scheduled kitedata command. Calendars and jobs
+// carry yaml tags since config show encodes them as structs, not maps.
+type JobConfig struct {
+	Name string `mapstructure:"name" yaml:"name"`
+	// Schedule is a cron expression or a shorthand such as "weekdays 16:00",
+	// read in the configured timezone
+	Schedule string `mapstructure:"schedule" yaml:"schedule"`
+	// Calendar names the exchange calendar whose holidays are skipped
+	Calendar string `mapstructure:"calendar" yaml:"calendar"`
+	// Args are the kitedata command and flags to run, e.g. ["download", "--universe", "nifty50"]
+	Args []string `mapstructure:"args" yaml:"args"`
+	// Incremental starts downloads at the day of the last successful run
+	Incremental bool          `mapstructure:"incremental" yaml:"incremental"`
+	Timeout     time.Duration `mapstructure:"timeout" yaml:"timeout"`
+	// Retries is how often a failed or partial run is repeated
+	Retries    int           `mapstructure:"retries" yaml:"retries"`
+	RetryDelay time.Duration `mapstructure:"retry_delay" yaml:"retry_delay"`
 }
 
 // HistoricalConfig defines the historical data download configuration
 type HistoricalConfig struct {
-	OutputDir       string `mapstructure:"output_dir"`
-	ParquetEnabled  bool   `mapstructure:"parquet_enabled"`
-	ParquetDir      string `mapstructure:"parquet_dir"`
-	Interval        string `mapstructure:"interval"`
-	DaysToFetch     int    `mapstructure:"days_to_fetch"`
-	RequestDelay    int    `mapstructure:"request_delay"`
-	MaxRetries      int    `mapstructure:"max_retries"`
-	InstrumentsPath string `mapstructure:"instruments_path"`
+	Sinks                       []string `mapstructure:"sinks"`
+	Storage                     string   `mapstructure:"storage"`
+	S3Endpoint                  string   `mapstructure:"s3_endpoint"`
+	S3Bucket                    string   `mapstructure:"s3_bucket"`
+	S3Prefix                    string   `mapstructure:"s3_prefix"`
+	S3Region                    string   `mapstructure:"s3_region"`
+	S3AccessKey                 string   `mapstructure:"s3_access_key"`
+	S3SecretKey                 string   `mapstructure:"s3_secret_key"`
+	S3UseSSL                    bool     `mapstructure:"s3_use_ssl"`
+	S3PartSize                  int64    `mapstructure:"s3_part_size"`
+	OutputDir                   string   `mapstructure:"output_dir"`
+	Timezone                    string   `mapstructure:"timezone"`
+	CSVDelimiter                string   `mapstructure:"csv_delimiter"`
+	CSVHeader                   bool     `mapstructure:"csv_header"`
+	CSVColumns                  []string `mapstructure:"csv_columns"`
+	CSVCompression              string   `mapstructure:"csv_compression"`
+	ParquetEnabled              bool     `mapstructure:"parquet_enabled"`
+	ParquetDir                  string   `mapstructure:"parquet_dir"`
+	ParquetLayout               string   `mapstructure:"parquet_layout"`
+	ParquetKeepPartitionColumns bool     `mapstructure:"parquet_keep_partition_columns"`
+	ParquetCompression          string   `mapstructure:"parquet_compression"`
+	ParquetRowGroupSize         int64    `mapstructure:"parquet_row_group_size"`
+	ParquetPageSize             int64    `mapstructure:"parquet_page_size"`
+	ParquetWriters              int      `mapstructure:"parquet_writers"`
+	ParquetTimestampUnit        string   `mapstructure:"parquet_timestamp_unit"`
+	SQLitePath                  string   `mapstructure:"sqlite_path"`
+	PostgresURL                 string   `mapstructure:"postgres_url"`
+	PostgresTable               string   `mapstructure:"postgres_table"`
+	InfluxURL                   string   `mapstructure:"influx_url"`
+	InfluxMeasurement           string   `mapstructure:"influx_measurement"`
+	InfluxTags                  []string `mapstructure:"influx_tags"`
+	InfluxBatchSize             int      `mapstructure:"influx_batch_size"`
+	InfluxToken                 string   `mapstructure:"influx_token"`
+	Interval                    string   `mapstructure:"interval"`
+	DaysToFetch                 int      `mapstructure:"days_to_fetch"`
+	FromDate                    string   `mapstructure:"from_date"`
+	ToDate                      string   `mapstructure:"to_date"`
+	RequestDelay                int      `mapstructure:"request_delay"`
+	MaxRetries                  int      `mapstructure:"max_retries"`
+	InstrumentsPath             string   `mapstructure:"instruments_path"`
 }
 
 // LoadConfig loads configuration from file and overrides with environment variables
@@ -60,28 +170,90 @@ func LoadConfig(path string) (Config, error) {
 
 	// Broker mappings
 	viper.BindEnv("broker.instruments_nse_url", "HISTORICAL_INSTRUMENTS_NSE_URL")
+	viper.BindEnv("broker.instruments_base_url", "HISTORICAL_INSTRUMENTS_BASE_URL")
+	viper.BindEnv("broker.exchanges", "HISTORICAL_EXCHANGES")
 
 	// Historical data mappings
+	viper.BindEnv("historical.sinks", "HISTORICAL_SINKS")
 	viper.BindEnv("historical.output_dir", "HISTORICAL_OUTPUT_DIR")
 	viper.BindEnv("historical.parquet_enabled", "HISTORICAL_PARQUET_ENABLED")
 	viper.BindEnv("historical.parquet_dir", "HISTORICAL_PARQUET_DIR")
+	viper.BindEnv("historical.timezone", "HISTORICAL_TIMEZONE")
+	viper.BindEnv("historical.csv_delimiter", "HISTORICAL_CSV_DELIMITER")
+	viper.BindEnv("historical.csv_header", "HISTORICAL_CSV_HEADER")
+	viper.BindEnv("historical.csv_columns", "HISTORICAL_CSV_COLUMNS")
+	viper.BindEnv("historical.csv_compression", "HISTORICAL_CSV_COMPRESSION")
+	viper.BindEnv("historical.parquet_timestamp_unit", "HISTORICAL_PARQUET_TIMESTAMP_UNIT")
+	viper.BindEnv("historical.parquet_layout", "HISTORICAL_PARQUET_LAYOUT")
+	viper.BindEnv("historical.parquet_keep_partition_columns", "HISTORICAL_PARQUET_KEEP_PARTITION_COLUMNS")
+	viper.BindEnv("historical.parquet_compression", "HISTORICAL_PARQUET_COMPRESSION")
+	viper.BindEnv("historical.parquet_row_group_size", "HISTORICAL_PARQUET_ROW_GROUP_SIZE")
+	viper.BindEnv("historical.parquet_page_size", "HISTORICAL_PARQUET_PAGE_SIZE")
+	viper.BindEnv("historical.parquet_writers", "HISTORICAL_PARQUET_WRITERS")
+	viper.BindEnv("historical.sqlite_path", "HISTORICAL_SQLITE_PATH")
+	viper.BindEnv("historical.postgres_url", "HISTORICAL_POSTGRES_URL")
+	viper.BindEnv("historical.postgres_table", "HISTORICAL_POSTGRES_TABLE")
+	viper.BindEnv("historical.influx_url", "HISTORICAL_INFLUX_URL")
+	viper.BindEnv("historical.influx_measurement", "HISTORICAL_INFLUX_MEASUREMENT")
+	viper.BindEnv("historical.influx_tags", "HISTORICAL_INFLUX_TAGS")
+	viper.BindEnv("historical.influx_batch_size", "HISTORICAL_INFLUX_BATCH_SIZE")
+	viper.BindEnv("historical.influx_token", "HISTORICAL_INFLUX_TOKEN")
+	viper.BindEnv("historical.storage", "HISTORICAL_STORAGE")
+	viper.BindEnv("historical.s3_endpoint", "HISTORICAL_S3_ENDPOINT")
+	viper.BindEnv("historical.s3_bucket", "HISTORICAL_S3_BUCKET")
+	viper.BindEnv("historical.s3_prefix", "HISTORICAL_S3_PREFIX")
+	viper.BindEnv("historical.s3_region", "HISTORICAL_S3_REGION")
+	viper.BindEnv("historical.s3_access_key", "HISTORICAL_S3_ACCESS_KEY")
+	viper.BindEnv("historical.s3_secret_key", "HISTORICAL_S3_SECRET_KEY")
+	viper.BindEnv("historical.s3_use_ssl", "HISTORICAL_S3_USE_SSL")
+	viper.BindEnv("historical.s3_part_size", "HISTORICAL_S3_PART_SIZE")
 	viper.BindEnv("historical.interval", "HISTORICAL_INTERVAL")
 	viper.BindEnv("historical.days_to_fetch", "HISTORICAL_DAYS")
+	viper.BindEnv("historical.from_date", "HISTORICAL_FROM_DATE")
+	viper.BindEnv("historical.to_date", "HISTORICAL_TO_DATE")
 	viper.BindEnv("historical.request_delay", "HISTORICAL_REQUEST_DELAY")
 	viper.BindEnv("historical.max_retries", "HISTORICAL_MAX_RETRIES")
 	viper.BindEnv("historical.instruments_path", "HISTORICAL_INSTRUMENTS_PATH")
 
+	// Server mappings
+	viper.BindEnv("server.addr", "HISTORICAL_SERVER_ADDR")
+	viper.BindEnv("server.api_key", "HISTORICAL_SERVER_API_KEY")
+	viper.BindEnv("server.max_range_days", "HISTORICAL_SERVER_MAX_RANGE_DAYS")
+
+	// Tick capture mappings
+	viper.BindEnv("ticks.dir", "HISTORICAL_TICKS_DIR")
+	viper.BindEnv("ticks.mode", "HISTORICAL_TICKS_MODE")
+	viper.BindEnv("ticks.root_url", "HISTORICAL_TICKS_ROOT_URL")
+	viper.BindEnv("ticks.candles", "HISTORICAL_TICKS_CANDLES")
+	viper.BindEnv("ticks.candles_dir", "HISTORICAL_TICKS_CANDLES_DIR")
+	viper.BindEnv("ticks.replay_addr", "HISTORICAL_TICKS_REPLAY_ADDR")
+
+	// Daemon mappings
+	viper.BindEnv("daemon.dir", "HISTORICAL_DAEMON_DIR")
+	viper.BindEnv("daemon.timezone", "HISTORICAL_DAEMON_TIMEZONE")
+
+	// Instrument selection mappings
+	viper.BindEnv("filter", "HISTORICAL_FILTER")
+	viper.BindEnv("symbols", "HISTORICAL_SYMBOLS")
+	viper.BindEnv("exclude", "HISTORICAL_EXCLUDE")
+
+	// Booleans that default to true cannot be told apart from unset ones
+	// after unmarshaling, so register them with viper instead
+	defaults := Defaults()
+	viper.SetDefault("historical.csv_header", defaults.Historical.CSVHeader)
+	viper.SetDefault("historical.s3_use_ssl", defaults.Historical.S3UseSSL)
+
 	// First attempt to read the config file
 	var configFileFound bool
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			fmt.Printf("Config file not found at %s, falling back to environment variables\n", path)
+			log.Printf("Config file not found at %s, falling back to environment variables", path)
 		} else {
-			fmt.Printf("Error reading config file %s: %v, falling back to environment variables\n", path, err)
+			log.Printf("Error reading config file %s: %v, falling back to environment variables", path, err)
 		}
 	} else {
 		configFileFound = true
-		fmt.Printf("Loaded config from %s, will override with environment variables\n", viper.ConfigFileUsed())
+		log.Printf("Loaded config from %s, will override with environment variables", viper.ConfigFileUsed())
 	}
 
 	// IMPORTANT: Enable automatic environment variable binding AFTER reading config file
@@ -96,21 +268,45 @@ func LoadConfig(path string) (Config, error) {
 		return Config{}, fmt.Errorf("error unmarshaling config: %w", err)
 	}
 
+	// Lists coming from environment variables arrive as a single comma-separated value
+	config.Broker.Exchanges = splitList(config.Broker.Exchanges)
+	config.Historical.CSVColumns = splitList(config.Historical.CSVColumns)
+	config.Historical.Sinks = splitList(config.Historical.Sinks)
+	config.Historical.InfluxTags = splitList(config.Historical.InfluxTags)
+	config.Ticks.Candles = splitList(config.Ticks.Candles)
+	config.Symbols = splitSources(config.Symbols)
+	config.Universes = decodeUniverses(viper.GetStringMap("universes"))
+	config.Exclude = splitSources(config.Exclude)
+
 	// Apply default values for any settings not specified
-	applyDefaults(&config)
+	ApplyDefaults(&config)
 
 	// Log loading status
 	if configFileFound {
-		fmt.Println("Configuration loaded from file and overridden with environment variables")
+		log.Println("Configuration loaded from file and overridden with environment variables")
 	} else {
-		fmt.Println("Configuration loaded from environment variables with defaults applied")
+		log.Println("Configuration loaded from environment variables with defaults applied")
 	}
 
 	return config, nil
 }
 
-// applyDefaults sets default values for any config values not set from file or environment
-func applyDefaults(config *Config) {
+// Defaults returns a configuration holding every default. Configurations
+// built in code should start from it, since ApplyDefaults cannot restore
+// booleans that default to true.
+func Defaults() Config {
+	config := Config{
+		Historical: HistoricalConfig{
+			CSVHeader: true,
+			S3UseSSL:  true,
+		},
+	}
+	ApplyDefaults(&config)
+	return config
+}
+
+// ApplyDefaults sets default values for any config values not set from file or environment
+func ApplyDefaults(config *Config) {
 	// Auth defaults
 	if config.Auth.BrokerName == "" {
 		config.Auth.BrokerName = "zerodha"
@@ -120,6 +316,12 @@ func applyDefaults(config *Config) {
 	if config.Broker.InstrumentsNSEURL == "" {
 		config.Broker.InstrumentsNSEURL = "https://api.kite.trade/instruments/NSE"
 	}
+	if config.Broker.InstrumentsBaseURL == "" {
+		config.Broker.InstrumentsBaseURL = "https://api.kite.trade/instruments"
+	}
+	if len(config.Broker.Exchanges) == 0 {
+		config.Broker.Exchanges = []string{"NSE", "NFO"}
+	}
 
 	// Historical data defaults
 	if config.Historical.OutputDir == "" {
@@ -128,6 +330,51 @@ func applyDefaults(config *Config) {
 	if config.Historical.ParquetDir == "" {
 		config.Historical.ParquetDir = "./parquet_data"
 	}
+	if config.Historical.Timezone == "" {
+		config.Historical.Timezone = "Asia/Kolkata"
+	}
+	if config.Historical.CSVDelimiter == "" {
+		config.Historical.CSVDelimiter = ","
+	}
+	if config.Historical.CSVCompression == "" {
+		config.Historical.CSVCompression = "none"
+	}
+	if config.Historical.ParquetTimestampUnit == "" {
+		config.Historical.ParquetTimestampUnit = "millis"
+	}
+	if config.Historical.ParquetLayout == "" {
+		config.Historical.ParquetLayout = "symbol"
+	}
+	if config.Historical.ParquetCompression == "" {
+		config.Historical.ParquetCompression = "gzip"
+	}
+	if config.Historical.ParquetRowGroupSize == 0 {
+		config.Historical.ParquetRowGroupSize = 128 * 1024 * 1024 // 128MB row groups
+	}
+	if config.Historical.ParquetPageSize == 0 {
+		config.Historical.ParquetPageSize = 8 * 1024 // 8KB pages
+	}
+	if config.Historical.ParquetWriters == 0 {
+		config.Historical.ParquetWriters = 4
+	}
+	if config.Historical.SQLitePath == "" {
+		config.Historical.SQLitePath = "./kitedata.db"
+	}
+	if config.Historical.PostgresTable == "" {
+		config.Historical.PostgresTable = "candles"
+	}
+	if config.Historical.InfluxMeasurement == "" {
+		config.Historical.InfluxMeasurement = "candles"
+	}
+	if config.Historical.InfluxBatchSize == 0 {
+		config.Historical.InfluxBatchSize = 5000
+	}
+	if config.Historical.Storage == "" {
+		config.Historical.Storage = "local"
+	}
+	if config.Historical.S3PartSize == 0 {
+		config.Historical.S3PartSize = 16 * 1024 * 1024 // 16MB parts
+	}
 	if config.Historical.Interval == "" {
 		config.Historical.Interval = "minute"
 	}
@@ -143,4 +390,87 @@ func applyDefaults(config *Config) {
 	if config.Historical.InstrumentsPath == "" {
 		config.Historical.InstrumentsPath = "./instruments.csv"
 	}
-}
\ No newline at end of file
+
+	// Server defaults
+	if config.Server.Addr == "" {
+		config.Server.Addr = "localhost:8080"
+	}
+	if config.Server.MaxRangeDays <= 0 {
+		config.Server.MaxRangeDays = 366
+	}
+
+	// Tick capture defaults
+	if config.Ticks.Dir == "" {
+		config.Ticks.Dir = "./tick_data"
+	}
+	if config.Ticks.Mode == "" {
+		config.Ticks.Mode = "quote"
+	}
+	if config.Ticks.RootURL == "" {
+		config.Ticks.RootURL = "wss://ws.kite.trade"
+	}
+	if config.Ticks.CandlesDir == "" {
+		config.Ticks.CandlesDir = filepath.Join(config.Ticks.Dir, "candles")
+	}
+	if config.Ticks.ReplayAddr == "" {
+		config.Ticks.ReplayAddr = "localhost:8765"
+	}
+
+	// Daemon defaults
+	if config.Daemon.Dir == "" {
+		config.Daemon.Dir = "./daemon_data"
+	}
+	if config.Daemon.Timezone == "" {
+		config.Daemon.Timezone = "Asia/Kolkata"
+	}
+	for i := range config.Daemon.Jobs {
+		if config.Daemon.Jobs[i].RetryDelay == 0 {
+			config.Daemon.Jobs[i].RetryDelay = 10 * time.Minute
+		}
+	}
+}
+
+// splitList expands comma-separated entries and drops empty ones
+func splitList(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// decodeUniverses reads named symbol groups. A universe is either a list of
+// sources or a single source string, which is kept whole rather than split
+// on commas so that filter expressions survive.
+func decodeUniverses(raw map[string]interface{}) map[string][]string {
+	universes := make(map[string][]string, len(raw))
+	for name, value := range raw {
+		switch value := value.(type) {
+		case string:
+			universes[name] = []string{value}
+		case []interface{}:
+			for _, item := range value {
+				universes[name] = append(universes[name], fmt.Sprint(item))
+			}
+		}
+	}
+	return universes
+}
+
+// splitSources is splitList for symbol sources, leaving filter expressions
+// intact since they may contain commas of their own
+func splitSources(values []string) []string {
+	var result []string
+	for _, value := range values {
+		if strings.HasPrefix(strings.TrimSpace(value), "filter:") {
+			result = append(result, strings.TrimSpace(value))
+			continue
+		}
+		result = append(result, splitList([]string{value})...)
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package daemon
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/config"
+)
+
+// Calendar tells an exchange's trading days apart: Monday to Friday except
+// holidays, plus special weekend sessions
+type Calendar struct {
+	Name     string
+	holidays map[string]bool
+	sessions map[string]bool
+}
+
+// NewCalendar reads a configured calendar, including its holidays file
+func NewCalendar(name string, cfg config.CalendarConfig) (*Calendar, error) {
+	c := &Calendar{Name: name, holidays: make(map[string]bool), sessions: make(map[string]bool)}
+	holidays := cfg.Holidays
+	if cfg.HolidaysFile != "" {
+		dates, err := readDates(cfg.HolidaysFile)
+		if err != nil {
+			return nil, err
+		}
+		holidays = append(holidays, dates...)
+	}
+
+	for _, lists := range []struct {
+		dates []string
+		set   map[string]bool
+	}{{holidays, c.holidays}, {cfg.Sessions, c.sessions}} {
+		for _, date := range lists.dates {
+			if _, err := time.Parse("2006-01-02", date); err != nil {
+				return nil, fmt.Errorf("calendar %s: invalid date %q (use YYYY-MM-DD)", name, date)
+			}
+			lists.set[date] = true
+		}
+	}
+	return c, nil
+}
+
+// readDates reads one date per line, ignoring blank lines and # comments
+func readDates(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open holidays file: %w", err)
+	}
+	defer file.Close()
+
+	var dates []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			dates = append(dates, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return dates, nil
+}
+
+// HasHolidays reports whether any holiday falls in the given year. kitedata
+// ships no holiday lists, so a calendar without them only skips weekends.
+func (c *Calendar) HasHolidays(year int) bool {
+	prefix := fmt.Sprintf("%04d-", year)
+	for date := range c.holidays {
+		if strings.HasPrefix(date, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// TradingDay reports whether the exchange trades on t's date
+func (c *Calendar) TradingDay(t time.Time) bool {
+	date := t.Format("2006-01-02")
+	if c.sessions[date] {
+		return true
+	}
+	if weekday := t.Weekday(); weekday == time.Saturday || weekday == time.Sunday {
+		return false
+	}
+	return !c.holidays[date]
+}
@@ -0,0 +1,427 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/historical"
+)
+
+// exitPartial is the exit status kitedata gives a download that saved some
+// instruments but not all
+const exitPartial = 2
+
+// stopDelay is how long an interrupted command may take to exit before it
+// is killed
+const stopDelay = 30 * time.Second
+
+// wakeInterval bounds each sleep until a job is due, so a clock change or a
+// suspended machine delays a run by at most this much
+const wakeInterval = time.Minute
+
+// jobName restricts job names to what is safe in file names
+var jobName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Job is a configured job with its schedule and calendar resolved
+type Job struct {
+	config.JobConfig
+	Schedule *Schedule
+	// Calendar, when set, skips the days its exchange is closed
+	Calendar *Calendar
+}
+
+// NewJobs validates the configured jobs and resolves their calendars. The
+// historical settings decide which sinks a download job writes to.
+func NewJobs(cfg config.DaemonConfig, historicalCfg config.HistoricalConfig) ([]*Job, error) {
+	// Config keys arrive lowercased, so calendars are matched that way
+	calendars := make(map[string]config.CalendarConfig, len(cfg.Calendars))
+	for name, calendar := range cfg.Calendars {
+		calendars[strings.ToLower(name)] = calendar
+	}
+	loaded := make(map[string]*Calendar)
+
+	var jobs []*Job
+	seen := make(map[string]bool)
+	for i, jc := range cfg.Jobs {
+		if !jobName.MatchString(jc.Name) {
+			return nil, fmt.Errorf("job %d: name %q must be letters, digits, '.', '-' or '_'", i+1, jc.Name)
+		}
+		if seen[jc.Name] {
+			return nil, fmt.Errorf("job %s is defined twice", jc.Name)
+		}
+		seen[jc.Name] = true
+		if len(jc.Args) == 0 {
+			return nil, fmt.Errorf("job %s: args must name the command to run, e.g. [download, --universe, nifty50]", jc.Name)
+		}
+		if jc.Args[0] == "daemon" {
+			return nil, fmt.Errorf("job %s: a job cannot start another daemon", jc.Name)
+		}
+		if jc.Retries < 0 {
+			return nil, fmt.Errorf("job %s: retries cannot be negative", jc.Name)
+		}
+		if jc.Incremental && jc.Args[0] != "download" {
+			return nil, fmt.Errorf("job %s: incremental only applies to download jobs", jc.Name)
+		}
+		if jc.Incremental {
+			// A sink that rewrites whole files would keep only the days since
+			// the last run
+			for _, name := range jobSinks(jc.Args, historicalCfg) {
+				if historical.ReplacesSeries(name) {
+					return nil, fmt.Errorf("job %s: incremental downloads need sinks that merge (parquet, sqlite, postgres, influx), but %s rewrites each file with just the downloaded range", jc.Name, name)
+				}
+			}
+		}
+
+		schedule, err := ParseSchedule(jc.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", jc.Name, err)
+		}
+		job := &Job{JobConfig: jc, Schedule: schedule}
+		if jc.Calendar != "" {
+			key := strings.ToLower(jc.Calendar)
+			if loaded[key] == nil {
+				calendar, ok := calendars[key]
+				if !ok {
+					return nil, fmt.Errorf("job %s: unknown calendar %q", jc.Name, jc.Calendar)
+				}
+				if loaded[key], err = NewCalendar(jc.Calendar, calendar); err != nil {
+					return nil, err
+				}
+			}
+			job.Calendar = loaded[key]
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// jobSinks returns the sinks a download job writes to: the config's, unless
+// its args select others with --sink or add parquet with --parquet
+func jobSinks(args []string, cfg config.HistoricalConfig) []string {
+	for i, arg := range args {
+		switch {
+		case arg == "--sink" && i+1 < len(args):
+			cfg.Sinks = strings.Split(args[i+1], ",")
+		case strings.HasPrefix(arg, "--sink="):
+			cfg.Sinks = strings.Split(strings.TrimPrefix(arg, "--sink="), ",")
+		case arg == "--parquet" || arg == "--parquet=true":
+			cfg.ParquetEnabled = true
+		}
+	}
+	names := historical.SinksFromConfig(cfg)
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+	}
+	return names
+}
+
+// NextRun returns the first time after t the job runs, skipping the days
+// its calendar is closed. It returns the zero time if there is none within
+// a year.
+func (j *Job) NextRun(t time.Time) time.Time {
+	limit := t.AddDate(1, 0, 0)
+	for next := j.Schedule.Next(t); !next.IsZero() && next.Before(limit); next = j.Schedule.Next(next) {
+		if j.Calendar == nil || j.Calendar.TradingDay(next) {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// Daemon runs jobs as kitedata commands on their schedules
+type Daemon struct {
+	Jobs []*Job
+	// Dir holds the history, the run logs and the daemon's lock
+	Dir string
+	// Location is where schedules and calendars are read
+	Location *time.Location
+	// Executable is the kitedata binary, started with --config ConfigFile
+	Executable string
+	ConfigFile string
+	History    *History
+}
+
+// Run schedules every job until ctx is cancelled, then waits for the runs
+// in progress to stop. Only one daemon may use a directory at a time. It
+// refuses to start while a job's calendar lists no holidays for the current
+// year, which would run the job on every weekday holiday.
+func (d *Daemon) Run(ctx context.Context) error {
+	if len(d.Jobs) == 0 {
+		return fmt.Errorf("no jobs configured")
+	}
+	year := time.Now().In(d.Location).Year()
+	for _, job := range d.Jobs {
+		if job.Calendar != nil && !job.Calendar.HasHolidays(year) {
+			return fmt.Errorf("job %s: calendar %s lists no holidays for %d; add them to its holidays or holidays_file from the exchange's holiday circular, or remove the calendar from the job", job.Name, job.Calendar.Name, year)
+		}
+	}
+	unlock, err := d.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var wg sync.WaitGroup
+	for _, job := range d.Jobs {
+		wg.Add(1)
+		go func(job *Job) {
+			defer wg.Done()
+			d.schedule(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+	return nil
+}
+
+// schedule starts a job each time it is due. A job whose previous run has
+// not finished is skipped, as are days its calendar is closed; both are
+// recorded in the history.
+func (d *Daemon) schedule(ctx context.Context, job *Job) {
+	var running atomic.Bool
+	var runs sync.WaitGroup
+	defer runs.Wait()
+	warned := 0
+
+	for {
+		next := job.Schedule.Next(time.Now().In(d.Location))
+		if next.IsZero() {
+			log.Printf("Job %s: schedule %q never fires", job.Name, job.Schedule)
+			return
+		}
+		if !sleepUntil(ctx, next) {
+			return
+		}
+
+		if job.Calendar != nil && !job.Calendar.TradingDay(next) {
+			log.Printf("Job %s: skipping %s, %s is closed", job.Name, next.Format("2006-01-02 15:04"), job.Calendar.Name)
+			d.record(Run{Job: job.Name, Scheduled: next, Status: StatusSkipped, Error: job.Calendar.Name + " is closed"})
+			continue
+		}
+		if year := next.Year(); job.Calendar != nil && !job.Calendar.HasHolidays(year) && warned != year {
+			// A daemon running into a new year without its holiday list
+			log.Printf("WARNING: job %s: calendar %s lists no holidays for %d, so the job runs on every weekday holiday; add them and restart the daemon", job.Name, job.Calendar.Name, year)
+			warned = year
+		}
+		if !running.CompareAndSwap(false, true) {
+			log.Printf("Job %s: skipping %s, the previous run is still going", job.Name, next.Format("2006-01-02 15:04"))
+			d.record(Run{Job: job.Name, Scheduled: next, Status: StatusSkipped, Error: "previous run still running"})
+			continue
+		}
+
+		runs.Add(1)
+		go func(scheduled time.Time) {
+			defer runs.Done()
+			defer running.Store(false)
+			d.RunJob(ctx, job, scheduled)
+		}(next)
+	}
+}
+
+// sleepUntil waits for t, reporting false if ctx ends first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	for {
+		wait := time.Until(t)
+		if wait <= 0 {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(min(wait, wakeInterval)):
+		}
+	}
+}
+
+// RunJob runs a job for a scheduled time, repeating failed and partial runs
+// as configured. Every attempt is recorded; the last one is returned.
+func (d *Daemon) RunJob(ctx context.Context, job *Job, scheduled time.Time) Run {
+	for attempt := 1; ; attempt++ {
+		run := d.runOnce(ctx, job, scheduled, attempt)
+		d.record(run)
+
+		if run.Status != StatusFailed && run.Status != StatusPartial || attempt > job.Retries {
+			return run
+		}
+		log.Printf("Job %s: retrying in %s (attempt %d of %d)", job.Name, job.RetryDelay, attempt+1, job.Retries+1)
+		select {
+		case <-ctx.Done():
+			return run
+		case <-time.After(job.RetryDelay):
+		}
+	}
+}
+
+// runOnce starts the job's command and waits for it. Output goes to a log
+// file per run; a download exiting with exitPartial is a partial run.
+func (d *Daemon) runOnce(ctx context.Context, job *Job, scheduled time.Time, attempt int) Run {
+	started := time.Now().In(d.Location)
+	run := Run{Job: job.Name, Scheduled: scheduled, Attempt: attempt, Started: &started}
+	finish := func(status, msg string) Run {
+		finished := time.Now().In(d.Location)
+		run.Finished, run.Status, run.Error = &finished, status, msg
+		return run
+	}
+
+	args, err := d.args(job)
+	if err != nil {
+		return finish(StatusFailed, err.Error())
+	}
+	run.Args = args
+
+	run.Log = filepath.Join(d.Dir, "logs", fmt.Sprintf("%s_%s.log", job.Name, started.Format("20060102-150405")))
+	if err := os.MkdirAll(filepath.Dir(run.Log), 0755); err != nil {
+		return finish(StatusFailed, fmt.Sprintf("failed to create log directory: %v", err))
+	}
+	logFile, err := os.Create(run.Log)
+	if err != nil {
+		return finish(StatusFailed, fmt.Sprintf("failed to create run log: %v", err))
+	}
+	defer logFile.Close()
+
+	runCtx := ctx
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+
+	var errLine lastError
+	output := io.MultiWriter(logFile, &errLine)
+	cmd := exec.CommandContext(runCtx, d.Executable, args...)
+	cmd.Stdout, cmd.Stderr = output, output
+	// Let the command stop cleanly, as on Ctrl-C, before killing it
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = stopDelay
+
+	log.Printf("Job %s: starting kitedata %s", job.Name, strings.Join(args, " "))
+	err = cmd.Run()
+
+	var exitErr *exec.ExitError
+	errors.As(err, &exitErr)
+	if exitErr != nil {
+		run.ExitCode = exitErr.ExitCode()
+	}
+	msg := errLine.line
+	if msg == "" && err != nil {
+		msg = err.Error()
+	}
+	switch {
+	case ctx.Err() != nil:
+		run = finish(StatusCancelled, "daemon stopped during the run")
+	case runCtx.Err() != nil:
+		run = finish(StatusFailed, fmt.Sprintf("timed out after %s", job.Timeout))
+	case err == nil:
+		run = finish(StatusSuccess, "")
+	case run.ExitCode == exitPartial:
+		run = finish(StatusPartial, msg)
+	default:
+		run = finish(StatusFailed, msg)
+	}
+	log.Printf("Job %s: %s after %s", job.Name, run.Status, run.Duration().Round(time.Second))
+	return run
+}
+
+// args builds the command line of a run. Incremental downloads start at
+// the day of the job's last successful run; the first run uses the
+// configured range.
+func (d *Daemon) args(job *Job) ([]string, error) {
+	args := append([]string{"--config", d.ConfigFile}, job.Args...)
+	explicit := slices.ContainsFunc(job.Args, func(arg string) bool {
+		return arg == "--from" || strings.HasPrefix(arg, "--from=")
+	})
+	if !job.Incremental || explicit {
+		return args, nil
+	}
+	last, ok, err := d.History.LastSuccess(job.Name)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		args = append(args, "--from", last.Scheduled.In(d.Location).Format("2006-01-02"))
+	}
+	return args, nil
+}
+
+// record appends a run to the history, logging failures; a history that
+// cannot be written must not stop the jobs
+func (d *Daemon) record(run Run) {
+	if err := d.History.Append(run); err != nil {
+		log.Printf("Error recording %s run: %v", run.Job, err)
+	}
+}
+
+// lock claims the directory for this daemon with a file holding its pid.
+// A lock left by a daemon that is no longer running is taken over.
+func (d *Daemon) lock() (func(), error) {
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	path := filepath.Join(d.Dir, "daemon.lock")
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock: %w", err)
+		}
+
+		data, _ := os.ReadFile(path)
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		if pid > 0 && pid != os.Getpid() && processRunning(pid) {
+			return nil, fmt.Errorf("another daemon (pid %d) is using %s", pid, d.Dir)
+		}
+		log.Printf("Removing stale lock %s", path)
+		os.Remove(path)
+	}
+	return nil, fmt.Errorf("failed to lock %s", d.Dir)
+}
+
+// processRunning reports whether a process with pid exists
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// lastError keeps the last "Error: " line a command printed, which is how
+// kitedata reports why it failed
+type lastError struct {
+	pending []byte
+	line    string
+}
+
+func (l *lastError) Write(p []byte) (int, error) {
+	l.pending = append(l.pending, p...)
+	for {
+		i := bytes.IndexByte(l.pending, '\n')
+		if i < 0 {
+			break
+		}
+		if line := string(l.pending[:i]); strings.HasPrefix(line, "Error: ") {
+			l.line = strings.TrimPrefix(line, "Error: ")
+		}
+		l.pending = l.pending[i+1:]
+	}
+	return len(p), nil
+}
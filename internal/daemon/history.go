@@ -0,0 +1,142 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/fsutil"
+)
+
+// Run statuses recorded in the history
+const (
+	StatusSuccess   = "success"
+	StatusPartial   = "partial" // some instruments failed, the rest were saved
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+	StatusCancelled = "cancelled" // the daemon stopped during the run
+)
+
+// Run is one history entry: a finished attempt of a job, or a scheduled
+// time that was skipped
+type Run struct {
+	Job       string    `json:"job"`
+	Scheduled time.Time `json:"scheduled"`
+	// Attempt counts from 1; retries of a scheduled run increase it
+	Attempt  int        `json:"attempt,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Status   string     `json:"status"`
+	ExitCode int        `json:"exit_code,omitempty"`
+	// Error is the failure, or why the run was skipped
+	Error string   `json:"error,omitempty"`
+	Args  []string `json:"args,omitempty"`
+	Log   string   `json:"log,omitempty"`
+}
+
+// Duration returns how long the run took, zero for skipped runs
+func (r Run) Duration() time.Duration {
+	if r.Started == nil || r.Finished == nil {
+		return 0
+	}
+	return r.Finished.Sub(*r.Started)
+}
+
+// History appends runs to a JSON Lines file that outlives the daemon
+type History struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// HistoryPath returns the history file in a daemon directory
+func HistoryPath(dir string) string {
+	return filepath.Join(dir, "history.jsonl")
+}
+
+// Append records a run. The file is synced so a crash loses no entries.
+func (h *History) Append(run Run) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(h.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	// A daemon killed mid-write leaves half an entry, which the next one
+	// must not be appended to
+	file, dropped, err := fsutil.OpenAppend(h.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open job history: %w", err)
+	}
+	defer file.Close()
+	if dropped > 0 {
+		log.Printf("Removed an incomplete last entry from %s", h.Path)
+	}
+
+	line, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write job history: %w", err)
+	}
+	return file.Sync()
+}
+
+// Read returns every recorded run in the order they finished. A missing
+// file is an empty history. Lines that are not an entry, such as half a line
+// left by a daemon that was killed, are skipped with a warning.
+func (h *History) Read() ([]Run, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	file, err := os.Open(h.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job history: %w", err)
+	}
+	defer file.Close()
+
+	var runs []Run
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read %s: %w", h.Path, err)
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			var run Run
+			if jsonErr := json.Unmarshal(data, &run); jsonErr != nil {
+				log.Printf("Skipping malformed entry on line %d of %s: %v", line, h.Path, jsonErr)
+			} else {
+				runs = append(runs, run)
+			}
+		}
+		if err == io.EOF {
+			return runs, nil
+		}
+	}
+}
+
+// LastSuccess returns the latest successful run of a job
+func (h *History) LastSuccess(job string) (Run, bool, error) {
+	runs, err := h.Read()
+	if err != nil {
+		return Run{}, false, err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Job == job && runs[i].Status == StatusSuccess {
+			return runs[i], true, nil
+		}
+	}
+	return Run{}, false, nil
+}
@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestHistoryAfterCrash appends to and reads a history whose last entry a
+// killed daemon left half written
+func TestHistoryAfterCrash(t *testing.T) {
+	h := &History{Path: HistoryPath(t.TempDir())}
+	at := time.Date(2024, 6, 14, 16, 0, 0, 0, time.UTC)
+	if err := h.Append(Run{Job: "eod", Scheduled: at, Status: StatusSuccess}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(h.Path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"job":"eod","scheduled":"2024-06-15T16:00:00Z","sta`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Reading skips the half entry
+	runs, err := h.Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("read %d runs, want 1", len(runs))
+	}
+
+	// Appending removes it first
+	if err := h.Append(Run{Job: "eod", Scheduled: at.AddDate(0, 0, 3), Status: StatusFailed}); err != nil {
+		t.Fatal(err)
+	}
+	runs, err = h.Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(runs) != 2 || runs[1].Status != StatusFailed {
+		t.Fatalf("read %+v, want the success and the failure", runs)
+	}
+	last, ok, err := h.LastSuccess("eod")
+	if err != nil || !ok || !last.Scheduled.Equal(at) {
+		t.Errorf("LastSuccess = %v %v %v, want the run of %v", last.Scheduled, ok, err, at)
+	}
+	if matches, _ := filepath.Glob(h.Path + "*"); len(matches) != 1 {
+		t.Errorf("history files %v, want only %s", matches, h.Path)
+	}
+}
@@ -0,0 +1,197 @@
+// Package daemon runs kitedata commands on schedules, skipping exchange
+// holidays and keeping a history of every run
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// weekdayNames maps day names to cron's day-of-week numbers
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// dayShorthands are the day parts of "<days> HH:MM" schedules besides day names
+var dayShorthands = map[string]string{
+	"daily":    "*",
+	"weekdays": "1-5",
+	"weekends": "0,6",
+}
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	spec                         string
+	minutes, hours, days, months []bool
+	weekdays                     []bool
+	anyDay, anyWeekday           bool
+}
+
+// ParseSchedule reads a five-field cron expression (minute hour day month
+// weekday) or a shorthand "<days> HH:MM[,HH:MM...]", where days is daily,
+// weekdays, weekends or day names such as mon-fri or mon,wed,fri
+func ParseSchedule(spec string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 2:
+		cron, err := expandShorthand(fields[0], fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		fields = cron
+	case 5:
+	default:
+		return nil, fmt.Errorf("invalid schedule %q (use a cron expression or e.g. \"weekdays 16:00\")", spec)
+	}
+
+	s := &Schedule{spec: spec}
+	var err error
+	parsers := []struct {
+		field    *[]bool
+		min, max int
+		names    map[string]int
+	}{
+		{&s.minutes, 0, 59, nil},
+		{&s.hours, 0, 23, nil},
+		{&s.days, 1, 31, nil},
+		{&s.months, 1, 12, nil},
+		{&s.weekdays, 0, 7, weekdayNames},
+	}
+	for i, p := range parsers {
+		if *p.field, err = parseField(fields[i], p.min, p.max, p.names); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+	}
+	// 7 is another name for Sunday
+	s.weekdays[0] = s.weekdays[0] || s.weekdays[7]
+	s.anyDay = fields[2] == "*"
+	s.anyWeekday = fields[4] == "*"
+	return s, nil
+}
+
+// expandShorthand turns "<days> HH:MM[,HH:MM...]" into cron fields
+func expandShorthand(days, times string) ([]string, error) {
+	weekdays, ok := dayShorthands[strings.ToLower(days)]
+	if !ok {
+		weekdays = days
+	}
+	var minutes, hours []string
+	for _, hm := range strings.Split(times, ",") {
+		t, err := time.Parse("15:04", hm)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q (use HH:MM)", hm)
+		}
+		minutes = append(minutes, strconv.Itoa(t.Minute()))
+		hours = append(hours, strconv.Itoa(t.Hour()))
+	}
+	if len(hours) > 1 {
+		// Cron would run every combination of the minutes and hours
+		for i := range hours {
+			if minutes[i] != minutes[0] {
+				return nil, fmt.Errorf("times %q must share their minute; use a cron expression", times)
+			}
+		}
+	}
+	return []string{minutes[0], strings.Join(hours, ","), "*", "*", weekdays}, nil
+}
+
+// parseField reads one cron field: *, values, ranges and steps, separated
+// by commas
+func parseField(field string, min, max int, names map[string]int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = fieldValue(bounds[0], min, max, names); err != nil {
+				return nil, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = fieldValue(bounds[1], min, max, names); err != nil {
+					return nil, err
+				}
+			} else if step > 1 {
+				// "5/15" means from 5 to the end in steps of 15
+				hi = max
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// fieldValue reads a number or name within a field's bounds
+func fieldValue(value string, min, max int, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("invalid value %q (must be %d-%d)", value, min, max)
+	}
+	return n, nil
+}
+
+// String returns the schedule as it was written
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// Next returns the first time after t the schedule fires, in t's location.
+// It returns the zero time if the schedule never fires, such as on 31 February.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every combination of day and month recurs within a few years
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.months[int(t.Month())] || !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchDay applies cron's day rule: when both the day of the month and the
+// weekday are restricted, either one matching is enough
+func (s *Schedule) matchDay(t time.Time) bool {
+	day, weekday := s.days[t.Day()], s.weekdays[int(t.Weekday())]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
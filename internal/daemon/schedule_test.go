@@ -0,0 +1,122 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/config"
+)
+
+var ist = time.FixedZone("IST", 5*3600+1800)
+
+// at returns a time in June 2024, where the 14th is a Friday
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, time.June, day, hour, minute, 0, 0, ist)
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", at(14, 10, 7), at(14, 10, 15)},
+		{"*/15 * * * *", at(14, 10, 45), at(14, 11, 0)},
+		{"*/15 * * * *", at(14, 10, 15), at(14, 10, 30)},
+		{"5/15 9 * * *", at(14, 9, 21), at(14, 9, 35)},
+		{"5/15 9 * * *", at(14, 9, 50), at(15, 9, 5)},
+		{"0 16 * * mon-fri", at(14, 17, 0), at(17, 16, 0)},
+		{"0 16 * * MON-FRI", at(14, 15, 59), at(14, 16, 0)},
+		{"0 10 * * 7", at(14, 12, 0), at(16, 10, 0)},
+		{"0 10 * * 0", at(14, 12, 0), at(16, 10, 0)},
+		{"0 10 * * 5-7", at(15, 12, 0), at(16, 10, 0)},
+		// Day of month and weekday restricted: either one matches
+		{"0 0 20 * fri", at(14, 10, 0), at(20, 0, 0)},
+		{"0 0 25 * fri", at(14, 10, 0), at(21, 0, 0)},
+		// Only the day of month restricted
+		{"0 0 20 * *", at(14, 10, 0), at(20, 0, 0)},
+		{"30 8 1 7 *", at(14, 10, 0), time.Date(2024, time.July, 1, 8, 30, 0, 0, ist)},
+		{"0 0 31 2 *", at(14, 10, 0), time.Time{}},
+		// Shorthands
+		{"daily 16:00", at(14, 17, 0), at(15, 16, 0)},
+		{"weekdays 16:00", at(14, 17, 0), at(17, 16, 0)},
+		{"weekends 06:30", at(14, 17, 0), at(15, 6, 30)},
+		{"weekdays 09:00,16:00", at(14, 10, 0), at(14, 16, 0)},
+		{"weekdays 09:00,16:00", at(14, 16, 0), at(17, 9, 0)},
+		{"mon,wed 08:15", at(17, 9, 0), at(19, 8, 15)},
+		{"sat,sun 08:15", at(14, 9, 0), at(15, 8, 15)},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q after %s: got %s, want %s", tt.spec, tt.from.Format(time.RFC3339), got.Format(time.RFC3339), tt.want.Format(time.RFC3339))
+		}
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * * fri-mon",
+		"*/0 * * * *",
+		"* * * * funday",
+		"daily 25:00",
+		"daily 9am",
+		"someday 09:00",
+		// Cron would also run at 09:30 and 16:00
+		"weekdays 09:00,16:30",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestJobNextRun(t *testing.T) {
+	calendar, err := NewCalendar("NSE", config.CalendarConfig{
+		Holidays: []string{"2024-06-17"},
+		Sessions: []string{"2024-06-15"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		spec     string
+		calendar *Calendar
+		from     time.Time
+		want     time.Time
+	}{
+		{"no calendar", "weekdays 16:00", nil, at(14, 17, 0), at(17, 16, 0)},
+		{"holiday skipped", "weekdays 16:00", calendar, at(14, 17, 0), at(18, 16, 0)},
+		{"trading day", "weekdays 16:00", calendar, at(14, 15, 0), at(14, 16, 0)},
+		{"weekend session", "daily 16:00", calendar, at(14, 17, 0), at(15, 16, 0)},
+		{"weekend and holiday skipped", "daily 16:00", calendar, at(15, 17, 0), at(18, 16, 0)},
+		{"only holidays", "0 16 17 6 *", calendar, at(14, 17, 0), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			job := &Job{Schedule: schedule, Calendar: tt.calendar}
+			if got := job.NextRun(tt.from); !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got.Format(time.RFC3339), tt.want.Format(time.RFC3339))
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package fsutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// OpenAppend opens a line-based file for appending, creating it if needed.
+// A process killed mid-write can leave a partial last line, which the next
+// line appended would be glued to; it is cut off first. It returns the
+// number of bytes removed.
+func OpenAppend(filename string) (*os.File, int64, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	end, err := lastLineEnd(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	if end == info.Size() {
+		return file, 0, nil
+	}
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to repair %s: %w", filename, err)
+	}
+	return file, info.Size() - end, nil
+}
+
+// lastLineEnd returns the offset just past the last newline in the first
+// size bytes of r, or 0 when there is none
+func lastLineEnd(r io.ReaderAt, size int64) (int64, error) {
+	buf := make([]byte, 64*1024)
+	for end := size; end > 0; {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+		if _, err := r.ReadAt(chunk, start); err != nil {
+			return 0, err
+		}
+		if end == size && chunk[len(chunk)-1] == '\n' {
+			return size, nil
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
@@ -0,0 +1,145 @@
+package fsutil
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// StaleTempAge is how old a temporary file must be before CleanTempFiles
+// treats it as left behind by an interrupted run rather than one in progress
+const StaleTempAge = time.Hour
+
+// tempFilePattern matches the names os.CreateTemp gives files created by WriteFile
+var tempFilePattern = regexp.MustCompile(`^\..+\.\d+\.tmp$`)
+
+// WriteFile writes a file so that readers only ever see the old or the
+// complete new content. Data goes to a hidden temporary file in the same
+// directory, is flushed to disk and then renamed over the target.
+func WriteFile(filename string, write func(w io.Writer) error) error {
+	f, err := Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Abort() // no-op once committed
+
+	if err := write(f); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// File is a file being written in place of another. Until Commit it is a
+// hidden temporary file next to the target, so it can be written over a
+// long time without readers ever seeing partial content.
+type File struct {
+	*os.File
+	target string
+	done   bool
+}
+
+// Create starts replacing filename, creating its directory if needed
+func Create(filename string) (*File, error) {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Create the temporary file next to the target so the rename stays on one filesystem
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	return &File{File: tmp, target: filename}, nil
+}
+
+// Commit flushes the file to disk and renames it over the target
+func (f *File) Commit() error {
+	if f.done {
+		return fmt.Errorf("%s already closed", f.target)
+	}
+	f.done = true
+	defer os.Remove(f.Name()) // no-op once renamed
+
+	// CreateTemp makes files private; give them the usual permissions
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to flush %s: %w", f.target, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", f.target, err)
+	}
+	if err := os.Rename(f.Name(), f.target); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", f.target, err)
+	}
+
+	// Persist the rename itself
+	syncDir(filepath.Dir(f.target))
+	return nil
+}
+
+// Abort discards the file, leaving the target untouched
+func (f *File) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// syncDir flushes a directory's entries to disk. Not every platform
+// supports syncing directories, so this is best effort.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Sync()
+}
+
+// CleanTempFiles removes temporary files under dir that interrupted runs
+// left behind. Files younger than StaleTempAge may belong to a run that is
+// still writing and are kept. A missing dir is not an error.
+func CleanTempFiles(dir string) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() || !tempFilePattern.MatchString(entry.Name()) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil // removed concurrently
+		}
+		if time.Since(info.ModTime()) < StaleTempAge {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Printf("Removed stale temporary file %s", path)
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to clean temporary files in %s: %w", dir, err)
+	}
+	return removed, nil
+}
@@ -0,0 +1,225 @@
+package historical
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/storage"
+)
+
+// ArrowSink writes each series to <output_dir>/<SYMBOL>/<SYMBOL>_historical.feather,
+// an Arrow IPC file (Feather v2) with the HistoricalDataPoint columns. Files
+// are replaced with the latest batch.
+type ArrowSink struct {
+	OutputDir string
+	// Location is the timezone of the calendar fields; its name is recorded
+	// on the timestamp column so pandas and polars display local times
+	Location     *time.Location
+	TimezoneName string
+	Storage      storage.Storage
+}
+
+// NewArrowSink creates the arrow sink from the configuration
+func NewArrowSink(cfg *config.Config) (Sink, error) {
+	location, err := LoadTimezone(cfg.Historical.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.FromConfig(cfg.Historical)
+	if err != nil {
+		return nil, err
+	}
+	return &ArrowSink{
+		OutputDir:    cfg.Historical.OutputDir,
+		Location:     location,
+		TimezoneName: TimezoneName(cfg.Historical.Timezone),
+		Storage:      store,
+	}, nil
+}
+
+// ArrowPath returns the Feather file holding a symbol's candles
+func ArrowPath(outputDir, symbol string) string {
+	return historicalPath(outputDir, symbol, ".feather")
+}
+
+// Open prepares the output directory, removing stale temporary files
+func (s *ArrowSink) Open(ctx context.Context) error {
+	return s.Storage.Prepare(ctx, s.OutputDir)
+}
+
+// Write saves a series' candles to its Feather file
+func (s *ArrowSink) Write(ctx context.Context, series Series, candles []HistoricalCandle) error {
+	filename := ArrowPath(s.OutputDir, series.Symbol)
+	err := s.Storage.WriteFile(ctx, filename, func(w io.Writer) error {
+		// The Arrow file footer points back into the file, so the writer needs to seek
+		ws, ok := w.(io.WriteSeeker)
+		if !ok {
+			return fmt.Errorf("arrow files need a seekable writer")
+		}
+		return encodeArrow(ws, series, candles, s.Location, s.TimezoneName)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write arrow file: %w", err)
+	}
+
+	log.Printf("Saved %d data points to %s", len(candles), s.Storage.Location(filename))
+	return nil
+}
+
+// OpenSeries streams a series into a new version of its Feather file, one
+// record batch per chunk, which replaces the old file once the series is complete
+func (s *ArrowSink) OpenSeries(ctx context.Context, series Series) (SeriesWriter, error) {
+	filename := ArrowPath(s.OutputDir, series.Symbol)
+	f, err := s.Storage.Create(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := newArrowEncoder(f, series, s.Location, s.TimezoneName)
+	if err != nil {
+		f.Abort()
+		return nil, err
+	}
+	return &fileSeries{
+		file:     f,
+		location: s.Storage.Location(filename),
+		write:    enc.write,
+		close:    enc.close,
+	}, nil
+}
+
+// Close implements Sink; every write is already complete on disk
+func (s *ArrowSink) Close() error {
+	return nil
+}
+
+// arrowSchema describes HistoricalDataPoint as Arrow columns
+func arrowSchema(timezone string) *arrow.Schema {
+	return arrow.NewSchema([]arrow.Field{
+		{Name: "symbol", Type: arrow.BinaryTypes.String},
+		{Name: "instrument_token", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "exchange", Type: arrow.BinaryTypes.String},
+		{Name: "interval", Type: arrow.BinaryTypes.String},
+		{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: timezone}},
+		{Name: "date", Type: arrow.BinaryTypes.String},
+		{Name: "year", Type: arrow.PrimitiveTypes.Int32},
+		{Name: "month", Type: arrow.PrimitiveTypes.Int32},
+		{Name: "day", Type: arrow.PrimitiveTypes.Int32},
+		{Name: "open", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "high", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "low", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "close", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "volume", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "oi", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "source", Type: arrow.BinaryTypes.String},
+	}, nil)
+}
+
+// encodeArrow writes candles as a single-batch Arrow IPC file to w
+func encodeArrow(w io.WriteSeeker, series Series, candles []HistoricalCandle, loc *time.Location, timezone string) error {
+	enc, err := newArrowEncoder(w, series, loc, timezone)
+	if err != nil {
+		return err
+	}
+	if err := enc.write(candles); err != nil {
+		enc.writer.Close()
+		return err
+	}
+	return enc.close()
+}
+
+// arrowEncoder writes a series as an Arrow IPC file, one record batch per write
+type arrowEncoder struct {
+	mem    memory.Allocator
+	schema *arrow.Schema
+	writer *ipc.FileWriter
+	series Series
+	loc    *time.Location
+}
+
+func newArrowEncoder(w io.WriteSeeker, series Series, loc *time.Location, timezone string) (*arrowEncoder, error) {
+	mem := memory.NewGoAllocator()
+	schema := arrowSchema(timezone)
+	writer, err := ipc.NewFileWriter(w, ipc.WithSchema(schema), ipc.WithAllocator(mem))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create arrow writer: %w", err)
+	}
+	return &arrowEncoder{mem: mem, schema: schema, writer: writer, series: series, loc: loc}, nil
+}
+
+func (enc *arrowEncoder) write(candles []HistoricalCandle) error {
+	if len(candles) == 0 {
+		return nil
+	}
+	record := arrowRecord(enc.mem, enc.schema, enc.series, candles, enc.loc)
+	defer record.Release()
+
+	if err := enc.writer.Write(record); err != nil {
+		return fmt.Errorf("failed to write arrow data: %w", err)
+	}
+	return nil
+}
+
+func (enc *arrowEncoder) close() error {
+	if err := enc.writer.Close(); err != nil {
+		return fmt.Errorf("failed to finalize arrow file: %w", err)
+	}
+	return nil
+}
+
+// arrowRecord builds a record batch holding candles
+func arrowRecord(mem memory.Allocator, schema *arrow.Schema, series Series, candles []HistoricalCandle, loc *time.Location) array.Record {
+	builder := array.NewRecordBuilder(mem, schema)
+	defer builder.Release()
+	builder.Reserve(len(candles))
+
+	fields := builder.Fields()
+	for _, candle := range candles {
+		point := newDataPoint(series, candle, loc)
+		fields[0].(*array.StringBuilder).Append(point.Symbol)
+		fields[1].(*array.Int64Builder).Append(point.InstrumentToken)
+		fields[2].(*array.StringBuilder).Append(point.Exchange)
+		fields[3].(*array.StringBuilder).Append(point.Interval)
+		fields[4].(*array.TimestampBuilder).Append(arrow.Timestamp(point.Timestamp.UnixMilli()))
+		fields[5].(*array.StringBuilder).Append(point.Date)
+		fields[6].(*array.Int32Builder).Append(point.Year)
+		fields[7].(*array.Int32Builder).Append(point.Month)
+		fields[8].(*array.Int32Builder).Append(point.Day)
+		fields[9].(*array.Float64Builder).Append(point.Open)
+		fields[10].(*array.Float64Builder).Append(point.High)
+		fields[11].(*array.Float64Builder).Append(point.Low)
+		fields[12].(*array.Float64Builder).Append(point.Close)
+		fields[13].(*array.Int64Builder).Append(point.Volume)
+		fields[14].(*array.Int64Builder).Append(point.OI)
+		fields[15].(*array.StringBuilder).Append(point.Source)
+	}
+	return builder.NewRecord()
+}
+
+// encodeArrowStream writes candles in the Arrow IPC streaming format, which
+// unlike the file format needs no seeking and suits pipes and HTTP responses
+func encodeArrowStream(w io.Writer, series Series, candles []HistoricalCandle, loc *time.Location, timezone string) error {
+	mem := memory.NewGoAllocator()
+	schema := arrowSchema(timezone)
+
+	writer := ipc.NewWriter(w, ipc.WithSchema(schema), ipc.WithAllocator(mem))
+	if len(candles) > 0 {
+		record := arrowRecord(mem, schema, series, candles, loc)
+		defer record.Release()
+		if err := writer.Write(record); err != nil {
+			writer.Close()
+			return fmt.Errorf("failed to write arrow data: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish arrow stream: %w", err)
+	}
+	return nil
+}
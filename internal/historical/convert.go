@@ -0,0 +1,185 @@
+package historical
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/sabarim/kitedata/internal/storage"
+)
+
+// ConvertDirection selects which way Convert translates stored files
+type ConvertDirection string
+
+const (
+	// CSVToParquet rewrites <SYMBOL>_historical.csv files as monthly parquet files
+	CSVToParquet ConvertDirection = "parquet"
+	// ParquetToCSV rebuilds <SYMBOL>_historical.csv files from monthly parquet files
+	ParquetToCSV ConvertDirection = "csv"
+)
+
+// ConvertOptions configures an offline conversion between stored formats
+type ConvertOptions struct {
+	OutputDir  string
+	ParquetDir string
+	Symbols    []string
+	Direction  ConvertDirection
+	Workers    int
+
+	// Parquet encodes files written by CSVToParquet
+	Parquet ParquetOptions
+	// CSV reads and writes the CSV side of the conversion
+	CSV CSVOptions
+	// Series describes a symbol's candles for the self-describing parquet
+	// columns; when nil only the symbol is recorded
+	Series func(symbol string) Series
+}
+
+// ConvertResult summarizes a conversion run
+type ConvertResult struct {
+	Converted int
+	Skipped   int
+	Failed    map[string]error
+}
+
+// Convert translates stored candles between CSV and the monthly parquet layout.
+// Symbols are processed in parallel; rerunning a conversion rewrites the same
+// outputs from the same inputs, so interrupted runs can simply be repeated.
+func Convert(ctx context.Context, opts ConvertOptions) (ConvertResult, error) {
+	result := ConvertResult{Failed: make(map[string]error)}
+
+	var convert func(symbol string) (bool, error)
+	switch opts.Direction {
+	case CSVToParquet:
+		convert = func(symbol string) (bool, error) { return convertCSVToParquet(ctx, opts, symbol) }
+	case ParquetToCSV:
+		convert = func(symbol string) (bool, error) { return convertParquetToCSV(opts, symbol) }
+	default:
+		return result, fmt.Errorf("invalid conversion target: %q", opts.Direction)
+	}
+
+	// Remove temporary files left behind by interrupted runs
+	CleanTempFiles(opts.OutputDir, opts.ParquetDir)
+
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	symbols := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for symbol := range symbols {
+				converted, err := convert(symbol)
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					log.Printf("Error converting %s: %v", symbol, err)
+					result.Failed[symbol] = err
+				case converted:
+					result.Converted++
+				default:
+					result.Skipped++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for _, symbol := range opts.Symbols {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		case symbols <- symbol:
+		}
+	}
+	close(symbols)
+	wg.Wait()
+
+	return result, err
+}
+
+// convertCSVToParquet writes a symbol's CSV into monthly parquet files.
+// It reports false when the symbol has no CSV file to convert.
+func convertCSVToParquet(ctx context.Context, opts ConvertOptions, symbol string) (bool, error) {
+	path := opts.CSV.Path(opts.OutputDir, symbol)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	candles, err := ReadCSV(path, opts.CSV)
+	if err != nil {
+		return false, err
+	}
+	series := Series{Symbol: symbol}
+	if opts.Series != nil {
+		series = opts.Series(symbol)
+	}
+	series.Source = SourceConvert
+
+	if err := writeMonthlyParquet(ctx, storage.Local{}, opts.ParquetDir, series, normalizeCandles(candles), opts.Parquet); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// convertParquetToCSV combines a symbol's monthly parquet files into its CSV.
+// It reports false when the symbol has no parquet files to convert.
+func convertParquetToCSV(opts ConvertOptions, symbol string) (bool, error) {
+	series := Series{Symbol: symbol}
+	if opts.Series != nil {
+		series = opts.Series(symbol)
+	}
+	files, err := opts.Parquet.Layout.Files(opts.ParquetDir, symbol, series.Interval)
+	if err != nil {
+		return false, err
+	}
+	if len(files) == 0 {
+		return false, nil
+	}
+
+	var candles []HistoricalCandle
+	for _, file := range files {
+		monthCandles, err := ReadParquet(file)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", file, err)
+		}
+		candles = append(candles, monthCandles...)
+	}
+
+	path := opts.CSV.Path(opts.OutputDir, symbol)
+	if err := WriteCSV(path, series, normalizeCandles(candles), opts.CSV); err != nil {
+		return false, err
+	}
+	log.Printf("Converted %d data points from %d parquet files to %s", len(candles), len(files), path)
+	return true, nil
+}
+
+// normalizeCandles sorts candles by time and drops duplicate timestamps,
+// keeping the candle that appears last
+func normalizeCandles(candles []HistoricalCandle) []HistoricalCandle {
+	sort.SliceStable(candles, func(i, j int) bool {
+		return candles[i].Timestamp.Before(candles[j].Timestamp)
+	})
+
+	result := candles[:0]
+	for _, candle := range candles {
+		if n := len(result); n > 0 && result[n-1].Timestamp.Equal(candle.Timestamp) {
+			result[n-1] = candle
+			continue
+		}
+		result = append(result, candle)
+	}
+	return result
+}
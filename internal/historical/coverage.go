@@ -0,0 +1,236 @@
+package historical
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Coverage summarizes the stored candles of one symbol at one interval
+type Coverage struct {
+	Symbol   string    `json:"symbol"`
+	Interval string    `json:"interval"`
+	Source   string    `json:"source"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+	Candles  int       `json:"candles"`
+	// Days counts the calendar days with at least one candle
+	Days int `json:"days"`
+}
+
+// StoredSymbolsIn lists the symbols with stored candles in the parquet
+// directory or the CSV output directory. Missing directories are skipped.
+func StoredSymbolsIn(opts QueryOptions) ([]string, error) {
+	seen := make(map[string]bool)
+	var symbols []string
+	add := func(list []string) {
+		for _, symbol := range list {
+			if !seen[symbol] {
+				seen[symbol] = true
+				symbols = append(symbols, symbol)
+			}
+		}
+	}
+
+	if opts.ParquetDir != "" {
+		list, err := opts.Parquet.Layout.Symbols(opts.ParquetDir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		add(list)
+	}
+	if opts.OutputDir != "" {
+		list, err := StoredSymbols(opts.OutputDir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		add(list)
+	}
+	sort.Strings(symbols)
+	return symbols, nil
+}
+
+// StoredIntervals lists the intervals stored for opts.Series.Symbol,
+// shortest first
+func StoredIntervals(opts QueryOptions) ([]string, error) {
+	symbol := opts.Series.Symbol
+	seen := make(map[string]bool)
+
+	// Parquet: both layouts name the interval
+	if opts.Parquet.Layout.Name == LayoutHive {
+		dirs, err := filepath.Glob(filepath.Join(opts.ParquetDir, "exchange=*", "interval=*", "symbol="+hiveValue(symbol)))
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			seen[strings.TrimPrefix(filepath.Base(filepath.Dir(dir)), "interval=")] = true
+		}
+	} else {
+		files, err := opts.Parquet.Layout.Files(opts.ParquetDir, symbol, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if interval := opts.Parquet.Layout.fileInterval(file, symbol); interval != "" {
+				seen[interval] = true
+			}
+		}
+	}
+
+	// CSV: the downloaded interval plus any written by resample
+	if _, err := os.Stat(opts.CSV.Path(opts.OutputDir, symbol)); err == nil {
+		seen[opts.CSVInterval] = true
+	}
+	ext := opts.CSV.Extension()
+	files, err := filepath.Glob(filepath.Join(opts.OutputDir, symbol, symbol+"_*"+ext))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), symbol+"_"), ext)
+		if _, ok := intervalDurations[name]; ok {
+			seen[name] = true
+		}
+	}
+
+	var intervals []string
+	for interval := range seen {
+		if _, ok := intervalDurations[interval]; ok {
+			intervals = append(intervals, interval)
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervalDurations[intervals[i]] < intervalDurations[intervals[j]]
+	})
+	return intervals, nil
+}
+
+// SymbolCoverage reports the stored range of opts.Series.Symbol at every
+// stored interval. The From/To range and Series.Interval of opts are ignored.
+func SymbolCoverage(ctx context.Context, opts QueryOptions) ([]Coverage, error) {
+	return new(CoverageCache).SymbolCoverage(ctx, opts)
+}
+
+// CoverageCache remembers the coverage of each stored file until its size
+// or modification time changes, so repeated coverage reports only read the
+// files written since. The zero value is ready to use.
+type CoverageCache struct {
+	mu    sync.Mutex
+	files map[string]fileCoverage
+}
+
+// fileCoverage is the coverage of one stored file
+type fileCoverage struct {
+	modTime     time.Time
+	size        int64
+	first, last time.Time
+	candles     int
+	days        int
+}
+
+// SymbolCoverage reports the stored range of opts.Series.Symbol at every
+// stored interval, reading only files that changed since the last report.
+// The From/To range and Series.Interval of opts are ignored.
+func (cc *CoverageCache) SymbolCoverage(ctx context.Context, opts QueryOptions) ([]Coverage, error) {
+	intervals, err := StoredIntervals(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.From, opts.To = time.Time{}, time.Time{}
+	coverage := make([]Coverage, 0, len(intervals))
+	for _, interval := range intervals {
+		opts.Series.Interval = interval
+		source, files, err := opts.resolve()
+		if err != nil {
+			return nil, err
+		}
+		if source == QuerySourceCSV {
+			files = []string{opts.csvPath()}
+		}
+
+		c := Coverage{Symbol: opts.Series.Symbol, Interval: interval, Source: source}
+		// Monthly partitions never share a day, so their counts add up
+		for _, file := range files {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			fc, err := cc.file(file, source, opts)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if fc.candles == 0 {
+				continue
+			}
+			if c.Candles == 0 || fc.first.Before(c.First) {
+				c.First = fc.first
+			}
+			if fc.last.After(c.Last) {
+				c.Last = fc.last
+			}
+			c.Candles += fc.candles
+			c.Days += fc.days
+		}
+		coverage = append(coverage, c)
+	}
+	return coverage, nil
+}
+
+// file returns a file's coverage, reading it unless the cached entry is current
+func (cc *CoverageCache) file(path, source string, opts QueryOptions) (fileCoverage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileCoverage{}, err
+	}
+
+	cc.mu.Lock()
+	cached, ok := cc.files[path]
+	cc.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached, nil
+	}
+
+	var candles []HistoricalCandle
+	if source == QuerySourceCSV {
+		candles, err = ReadCSV(path, opts.CSV)
+	} else {
+		candles, err = ReadParquet(path)
+	}
+	if err != nil {
+		return fileCoverage{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	candles = normalizeCandles(candles)
+
+	loc := opts.CSV.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	fc := fileCoverage{modTime: info.ModTime(), size: info.Size(), candles: len(candles)}
+	if len(candles) > 0 {
+		fc.first = candles[0].Timestamp.In(loc)
+		fc.last = candles[len(candles)-1].Timestamp.In(loc)
+		days := make(map[string]bool)
+		for _, candle := range candles {
+			days[candle.Timestamp.In(loc).Format("2006-01-02")] = true
+		}
+		fc.days = len(days)
+	}
+
+	cc.mu.Lock()
+	if cc.files == nil {
+		cc.files = make(map[string]fileCoverage)
+	}
+	cc.files[path] = fc
+	cc.mu.Unlock()
+	return fc, nil
+}
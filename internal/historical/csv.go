@@ -0,0 +1,342 @@
+package historical
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/fsutil"
+	"github.com/sabarim/kitedata/internal/storage"
+)
+
+// DefaultCSVColumns are the columns written when none are configured
+var DefaultCSVColumns = []string{"timestamp", "date", "open", "high", "low", "close", "volume"}
+
+// minPricePrecision is the number of decimals written for instruments whose
+// tick size is unknown or coarser than a paisa
+const minPricePrecision = 2
+
+// maxPricePrecision caps the decimals derived from a tick size
+const maxPricePrecision = 8
+
+// CSVOptions controls how CSV files are written and read
+type CSVOptions struct {
+	Delimiter rune
+	Header    bool
+	Columns   []string
+	// Compression is none, gzip or zstd; compressed files get a .gz or .zst suffix
+	Compression string
+	// Location is the timezone timestamps are written in
+	Location *time.Location
+}
+
+// csvRow is the data a CSV column is formatted from
+type csvRow struct {
+	series    Series
+	candle    HistoricalCandle
+	local     time.Time
+	precision int
+}
+
+// csvColumns are the columns a CSV file can be configured with
+var csvColumns = map[string]func(r csvRow) string{
+	"timestamp": func(r csvRow) string { return r.local.Format(time.RFC3339) },
+	"unix":      func(r csvRow) string { return strconv.FormatInt(r.candle.Timestamp.Unix(), 10) },
+	"date":      func(r csvRow) string { return r.local.Format("2006-01-02") },
+	"time":      func(r csvRow) string { return r.local.Format("15:04:05") },
+	"symbol":    func(r csvRow) string { return r.series.Symbol },
+	"exchange":  func(r csvRow) string { return r.series.Exchange },
+	"interval":  func(r csvRow) string { return r.series.Interval },
+	"open":      func(r csvRow) string { return formatPrice(r.candle.Open, r.precision) },
+	"high":      func(r csvRow) string { return formatPrice(r.candle.High, r.precision) },
+	"low":       func(r csvRow) string { return formatPrice(r.candle.Low, r.precision) },
+	"close":     func(r csvRow) string { return formatPrice(r.candle.Close, r.precision) },
+	"volume":    func(r csvRow) string { return strconv.FormatInt(r.candle.Volume, 10) },
+	"oi":        func(r csvRow) string { return strconv.FormatInt(r.candle.OI, 10) },
+}
+
+// csvExtensions maps CSV compression names to file name suffixes
+var csvExtensions = map[string]string{
+	"none": "",
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// CSVOptionsFromConfig validates the CSV settings of the historical config
+func CSVOptionsFromConfig(cfg config.HistoricalConfig) (CSVOptions, error) {
+	delimiter, err := parseDelimiter(cfg.CSVDelimiter)
+	if err != nil {
+		return CSVOptions{}, err
+	}
+
+	columns := cfg.CSVColumns
+	if len(columns) == 0 {
+		columns = DefaultCSVColumns
+	}
+	for _, column := range columns {
+		if _, ok := csvColumns[column]; !ok {
+			return CSVOptions{}, fmt.Errorf("invalid CSV column %q", column)
+		}
+	}
+
+	compression := strings.ToLower(cfg.CSVCompression)
+	if compression == "" {
+		compression = "none"
+	}
+	if _, ok := csvExtensions[compression]; !ok {
+		return CSVOptions{}, fmt.Errorf("invalid CSV compression %q (use none, gzip or zstd)", cfg.CSVCompression)
+	}
+
+	location, err := LoadTimezone(cfg.Timezone)
+	if err != nil {
+		return CSVOptions{}, err
+	}
+
+	return CSVOptions{
+		Delimiter:   delimiter,
+		Header:      cfg.CSVHeader,
+		Columns:     columns,
+		Compression: compression,
+		Location:    location,
+	}, nil
+}
+
+// parseDelimiter accepts a single character or the names tab and pipe
+func parseDelimiter(value string) (rune, error) {
+	switch value {
+	case "", ",":
+		return ',', nil
+	case "tab", `\t`, "\t":
+		return '\t', nil
+	case "pipe", "|":
+		return '|', nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' {
+		return 0, fmt.Errorf("invalid CSV delimiter %q", value)
+	}
+	return runes[0], nil
+}
+
+// Path returns the CSV file holding a symbol's candles, including the
+// suffix of the configured compression
+func (opts CSVOptions) Path(outputDir, symbol string) string {
+	return CSVPath(outputDir, symbol) + csvExtensions[opts.Compression]
+}
+
+// Extension returns the file name suffix for files written with these options
+func (opts CSVOptions) Extension() string {
+	return ".csv" + csvExtensions[opts.Compression]
+}
+
+// pricePrecision derives the number of decimals needed to represent prices
+// on the instrument's tick, e.g. 4 for currency derivatives ticking 0.0025
+func pricePrecision(tickSize float64) int {
+	if tickSize <= 0 || math.IsNaN(tickSize) || math.IsInf(tickSize, 0) {
+		return minPricePrecision
+	}
+	decimals := 0
+	if s := strconv.FormatFloat(tickSize, 'f', -1, 64); strings.Contains(s, ".") {
+		decimals = len(s) - strings.Index(s, ".") - 1
+	}
+	if decimals < minPricePrecision {
+		return minPricePrecision
+	}
+	if decimals > maxPricePrecision {
+		return maxPricePrecision
+	}
+	return decimals
+}
+
+// formatPrice writes a price with a fixed number of decimals
+func formatPrice(price float64, precision int) string {
+	return strconv.FormatFloat(price, 'f', precision, 64)
+}
+
+// CSVSink writes each series to <output_dir>/<SYMBOL>/<SYMBOL>_historical.csv,
+// replacing the file with the latest batch
+type CSVSink struct {
+	OutputDir string
+	Options   CSVOptions
+	Storage   storage.Storage
+}
+
+// NewCSVSink creates the csv sink from the configuration
+func NewCSVSink(cfg *config.Config) (Sink, error) {
+	opts, err := CSVOptionsFromConfig(cfg.Historical)
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.FromConfig(cfg.Historical)
+	if err != nil {
+		return nil, err
+	}
+	return &CSVSink{OutputDir: cfg.Historical.OutputDir, Options: opts, Storage: store}, nil
+}
+
+// Open prepares the output directory, removing stale temporary files
+func (s *CSVSink) Open(ctx context.Context) error {
+	return s.Storage.Prepare(ctx, s.OutputDir)
+}
+
+// Write saves a series' candles to its CSV file
+func (s *CSVSink) Write(ctx context.Context, series Series, candles []HistoricalCandle) error {
+	filename := s.Options.Path(s.OutputDir, series.Symbol)
+	err := s.Storage.WriteFile(ctx, filename, func(w io.Writer) error {
+		return encodeCSV(w, series, candles, s.Options)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Saved %d data points to %s", len(candles), s.Storage.Location(filename))
+	return nil
+}
+
+// OpenSeries streams a series into a new version of its CSV file, which
+// replaces the old one once the series is complete
+func (s *CSVSink) OpenSeries(ctx context.Context, series Series) (SeriesWriter, error) {
+	filename := s.Options.Path(s.OutputDir, series.Symbol)
+	f, err := s.Storage.Create(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := newCSVEncoder(f, series, s.Options)
+	if err != nil {
+		f.Abort()
+		return nil, err
+	}
+	return &fileSeries{
+		file:     f,
+		location: s.Storage.Location(filename),
+		write:    enc.write,
+		close:    enc.close,
+	}, nil
+}
+
+// Close implements Sink; every write is already complete on disk
+func (s *CSVSink) Close() error {
+	return nil
+}
+
+// WriteCSV writes a series' candles to a CSV file, creating its directory if
+// needed. Prices keep as many decimals as the series' tick size requires.
+// The file is replaced atomically, so an interrupted run leaves the previous
+// version in place rather than a truncated file.
+func WriteCSV(filename string, series Series, candles []HistoricalCandle, opts CSVOptions) error {
+	return fsutil.WriteFile(filename, func(w io.Writer) error {
+		return encodeCSV(w, series, candles, opts)
+	})
+}
+
+// encodeCSV writes candles as CSV to w, compressing them if configured
+func encodeCSV(w io.Writer, series Series, candles []HistoricalCandle, opts CSVOptions) error {
+	enc, err := newCSVEncoder(w, series, opts)
+	if err != nil {
+		return err
+	}
+	if err := enc.write(candles); err != nil {
+		return err
+	}
+	return enc.close()
+}
+
+// csvEncoder writes a series as CSV in one or more batches
+type csvEncoder struct {
+	buffered   *bufio.Writer
+	compressor io.WriteCloser
+	writer     *csv.Writer
+	opts       CSVOptions
+	row        csvRow
+	record     []string
+}
+
+// newCSVEncoder starts a CSV stream on w, writing the header if configured
+func newCSVEncoder(w io.Writer, series Series, opts CSVOptions) (*csvEncoder, error) {
+	enc := &csvEncoder{
+		buffered: bufio.NewWriter(w),
+		opts:     opts,
+		row:      csvRow{series: series, precision: pricePrecision(series.TickSize)},
+		record:   make([]string, len(opts.Columns)),
+	}
+
+	var out io.Writer = enc.buffered
+	switch opts.Compression {
+	case "gzip":
+		enc.compressor = gzip.NewWriter(enc.buffered)
+	case "zstd":
+		encoder, err := zstd.NewWriter(enc.buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		enc.compressor = encoder
+	}
+	if enc.compressor != nil {
+		out = enc.compressor
+	}
+
+	enc.writer = csv.NewWriter(out)
+	enc.writer.Comma = opts.Delimiter
+
+	// Write header
+	if opts.Header {
+		if err := enc.writer.Write(opts.Columns); err != nil {
+			return nil, fmt.Errorf("failed to write header: %w", err)
+		}
+	}
+	return enc, nil
+}
+
+// write appends candles as rows
+func (enc *csvEncoder) write(candles []HistoricalCandle) error {
+	for _, candle := range candles {
+		enc.row.candle = candle
+		enc.row.local = candle.Timestamp.In(enc.opts.Location)
+		for i, column := range enc.opts.Columns {
+			enc.record[i] = csvColumns[column](enc.row)
+		}
+		if err := enc.writer.Write(enc.record); err != nil {
+			return fmt.Errorf("failed to write data: %w", err)
+		}
+	}
+	return nil
+}
+
+// close flushes the rows and finishes the compressed stream
+func (enc *csvEncoder) close() error {
+	enc.writer.Flush()
+	if err := enc.writer.Error(); err != nil {
+		return fmt.Errorf("failed to write data: %w", err)
+	}
+	if enc.compressor != nil {
+		if err := enc.compressor.Close(); err != nil {
+			return fmt.Errorf("failed to finish compressed CSV: %w", err)
+		}
+	}
+	return enc.buffered.Flush()
+}
+
+// decompressCSV wraps r with a decompressor chosen by the file name suffix
+func decompressCSV(path string, r io.Reader) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(path, ".zst"):
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(r), nil
+}
@@ -4,8 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -18,156 +16,211 @@ import (
 type HistoricalDownloader struct {
 	config      *config.Config
 	kiteConnect *kiteconnect.Client
+	sink        Sink
 }
 
-// NewHistoricalDownloader creates a new historical data downloader
+// NewHistoricalDownloader creates a new historical data downloader writing
+// to the sinks selected in the config
 func NewHistoricalDownloader(config *config.Config, kiteConnect *kiteconnect.Client) (*HistoricalDownloader, error) {
-	// Ensure output directories exist
-	if err := os.MkdirAll(config.Historical.OutputDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create output directory: %w", err)
-	}
-
-	if config.Historical.ParquetEnabled {
-		if err := os.MkdirAll(config.Historical.ParquetDir, 0755); err != nil {
-			return nil, fmt.Errorf("failed to create parquet directory: %w", err)
-		}
+	sink, err := NewSinkFromConfig(config)
+	if err != nil {
+		return nil, err
 	}
+	return NewHistoricalDownloaderWithSink(config, kiteConnect, sink), nil
+}
 
+// NewHistoricalDownloaderWithSink creates a downloader writing to the given sink
+func NewHistoricalDownloaderWithSink(config *config.Config, kiteConnect *kiteconnect.Client, sink Sink) *HistoricalDownloader {
 	return &HistoricalDownloader{
 		config:      config,
 		kiteConnect: kiteConnect,
-	}, nil
+		sink:        sink,
+	}
 }
 
-// DownloadHistoricalData downloads historical data for specified instruments
-func (hd *HistoricalDownloader) DownloadHistoricalData(ctx context.Context, instruments []instruments.Instrument) error {
+// PartialError reports a download that saved some instruments but failed
+// for others
+type PartialError struct {
+	Failed int
+	Total  int
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("failed to download %d of %d instruments", e.Failed, e.Total)
+}
+
+// DownloadHistoricalData downloads historical data for specified
+// instruments. Instruments that fail are skipped and reported at the end
+// as a *PartialError.
+func (hd *HistoricalDownloader) DownloadHistoricalData(ctx context.Context, instruments []instruments.Instrument) (err error) {
 	log.Println("Downloading historical data...")
 
 	// Calculate from and to dates
-	to := time.Now()
-	from := to.AddDate(0, 0, -hd.config.Historical.DaysToFetch)
+	from, to, err := hd.dateRange()
+	if err != nil {
+		return err
+	}
 
 	// Parse interval
-	var interval string
-	switch hd.config.Historical.Interval {
-	case "minute":
-		interval = "minute"
-	case "hour":
-		interval = "60minute"
-	case "day":
-		interval = "day"
-	default:
-		return fmt.Errorf("invalid interval: %s", hd.config.Historical.Interval)
+	interval, err := KiteInterval(hd.config.Historical.Interval)
+	if err != nil {
+		return err
 	}
 
-	// Download data for each instrument
-	for _, instrument := range instruments {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			// Continue processing
-		}
-
-		log.Printf("Downloading historical data for %s (%s)...", instrument.Name, instrument.TradingSymbol)
-
-		// Download data with retry and chunking for 60-day limit
-		candles, err := hd.downloadWithRetry(instrument.InstrumentToken, from, to, interval)
-		if err != nil {
-			log.Printf("Error downloading data for %s: %v, skipping...", instrument.Name, err)
-			continue
-		}
-
-		// Save data to CSV
-		if err := hd.saveToCSV(instrument, candles); err != nil {
-			log.Printf("Error saving data for %s: %v", instrument.Name, err)
-			continue
-		}
-
-		// Convert to Parquet if enabled
-		if hd.config.Historical.ParquetEnabled {
-			if err := hd.convertToParquet(instrument, candles); err != nil {
-				log.Printf("Error converting data to Parquet for %s: %v", instrument.Name, err)
-				continue
+	// Open the sinks once for the whole run
+	if err := hd.sink.Open(ctx); err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	// Closing writes what the sinks still hold, so its failure fails the run
+	defer func() {
+		if cerr := hd.sink.Close(); cerr != nil {
+			if err == nil {
+				err = fmt.Errorf("failed to close output: %w", cerr)
+			} else {
+				log.Printf("Error closing output: %v", cerr)
 			}
 		}
+	}()
 
-		// Respect rate limits
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(time.Duration(hd.config.Historical.RequestDelay) * time.Millisecond):
-			// Continue after delay
-		}
+	// Stream every instrument through the download pipeline
+	failed, err := hd.runPipeline(ctx, instruments, interval, from, to)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return &PartialError{Failed: failed, Total: len(instruments)}
 	}
 
 	log.Println("Historical data download completed")
 	return nil
 }
 
-// downloadWithRetry attempts to download historical data with retries
-// This function handles the 60-day limit for minute data by chunking requests
-func (hd *HistoricalDownloader) downloadWithRetry(instrumentToken int64, from, to time.Time, interval string) ([]HistoricalCandle, error) {
-	var allCandles []HistoricalCandle
-
-	// For minute interval, Zerodha limits API calls to 60 days
-	// We need to chunk the requests if the total duration is more than 60 days
-	isMinuteInterval := interval == "minute" ||
-		(strings.HasSuffix(interval, "minute") && interval != "day")
-
-	// Calculate total duration
-	totalDuration := to.Sub(from)
+// FetchCandles downloads an instrument's candles for the given Kite interval
+// and period and returns them in memory, without writing to the sink.
+// Long minute ranges are split into requests Kite accepts, and failed
+// requests are retried as configured.
+func (hd *HistoricalDownloader) FetchCandles(ctx context.Context, instrument instruments.Instrument, interval string, from, to time.Time) (Series, []HistoricalCandle, error) {
+	series := newSeries(instrument, interval)
+	var candles []HistoricalCandle
+	err := hd.FetchChunks(ctx, instrument, interval, from, to, func(chunk []HistoricalCandle, last bool) error {
+		candles = append(candles, chunk...)
+		return nil
+	})
+	if err != nil {
+		return series, nil, err
+	}
+	// Chunks may overlap at their edges
+	return series, normalizeCandles(candles), nil
+}
 
-	// If using minute intervals and duration > 60 days, we need to chunk
-	if isMinuteInterval && totalDuration > 60*24*time.Hour {
+// FetchChunks downloads an instrument's candles one request at a time and
+// passes each chunk to fn as it arrives, with last set on the final one.
+// Long minute ranges are split into requests Kite accepts, paced by the
+// request delay. It stops at the first error from a request or from fn.
+func (hd *HistoricalDownloader) FetchChunks(ctx context.Context, instrument instruments.Instrument, interval string, from, to time.Time,
+	fn func(candles []HistoricalCandle, last bool) error) error {
+	ranges := requestRanges(from, to, interval)
+	if len(ranges) > 1 {
 		log.Printf("Duration (%v days) exceeds Zerodha's 60-day limit for minute data, chunking requests",
-			totalDuration.Hours()/24)
-
-		// Process in 60-day chunks
-		currentFrom := from
-		for currentFrom.Before(to) {
-			// Calculate end of chunk (max 60 days)
-			currentTo := currentFrom.Add(60 * 24 * time.Hour)
-			if currentTo.After(to) {
-				currentTo = to
-			}
+			to.Sub(from).Hours()/24)
+	}
 
+	for i, r := range ranges {
+		if i > 0 {
+			// Add a delay between chunks to avoid rate limiting
+			if err := wait(ctx, hd.config.Historical.RequestDelay); err != nil {
+				return err
+			}
+		}
+		if len(ranges) > 1 {
 			log.Printf("Downloading chunk from %s to %s (%v days)",
-				currentFrom.Format("2006-01-02"),
-				currentTo.Format("2006-01-02"),
-				currentTo.Sub(currentFrom).Hours()/24)
+				r.from.Format("2006-01-02"), r.to.Format("2006-01-02"), r.to.Sub(r.from).Hours()/24)
+		}
 
-			// Download this chunk
-			chunkCandles, err := hd.downloadChunk(instrumentToken, currentFrom, currentTo, interval)
-			if err != nil {
-				return nil, fmt.Errorf("error downloading chunk from %s to %s: %w",
-					currentFrom.Format("2006-01-02"),
-					currentTo.Format("2006-01-02"),
-					err)
+		// Open interest is only meaningful for futures and options
+		candles, err := hd.downloadChunk(ctx, instrument.InstrumentToken, instrument.IsDerivative(), r.from, r.to, interval)
+		if err != nil {
+			if len(ranges) > 1 {
+				err = fmt.Errorf("error downloading chunk from %s to %s: %w",
+					r.from.Format("2006-01-02"), r.to.Format("2006-01-02"), err)
 			}
+			return err
+		}
+		if err := fn(candles, i == len(ranges)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			// Add chunk candles to all candles
-			allCandles = append(allCandles, chunkCandles...)
+// newSeries describes an instrument's candles downloaded from Kite
+func newSeries(instrument instruments.Instrument, interval string) Series {
+	return Series{
+		Symbol:   instrument.TradingSymbol,
+		Token:    instrument.InstrumentToken,
+		Exchange: instrument.Exchange,
+		Interval: interval,
+		Source:   SourceKite,
+		TickSize: instrument.TickSize,
+	}
+}
 
-			// Move to next chunk
-			currentFrom = currentTo.Add(time.Second)
+// wait pauses for the given number of milliseconds unless ctx is cancelled first
+func wait(ctx context.Context, millis int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(millis) * time.Millisecond):
+		return nil
+	}
+}
 
-			// Add a delay between chunks to avoid rate limiting
-			time.Sleep(time.Duration(hd.config.Historical.RequestDelay) * time.Millisecond)
+// dateRange returns the period to download. Explicit from/to dates win;
+// otherwise the range ends now and spans the configured number of days.
+func (hd *HistoricalDownloader) dateRange() (time.Time, time.Time, error) {
+	to := time.Now()
+	if hd.config.Historical.ToDate != "" {
+		date, err := time.ParseInLocation("2006-01-02", hd.config.Historical.ToDate, instruments.IST)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to date: %w", err)
 		}
+		// Include the whole end day
+		to = date.AddDate(0, 0, 1).Add(-time.Second)
+	}
 
-		return allCandles, nil
+	from := to.AddDate(0, 0, -hd.config.Historical.DaysToFetch)
+	if hd.config.Historical.FromDate != "" {
+		date, err := time.ParseInLocation("2006-01-02", hd.config.Historical.FromDate, instruments.IST)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from date: %w", err)
+		}
+		from = date
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from date %s is not before to date %s",
+			from.Format("2006-01-02"), to.Format("2006-01-02"))
 	}
+	return from, to, nil
+}
+
+// maxMinuteRange is the longest period Zerodha serves minute data for in one request
+const maxMinuteRange = 60 * 24 * time.Hour
 
-	// For non-minute intervals or short durations, download normally
-	return hd.downloadChunk(instrumentToken, from, to, interval)
+// isMinuteInterval reports whether a Kite interval is subject to maxMinuteRange
+func isMinuteInterval(interval string) bool {
+	return strings.HasSuffix(interval, "minute")
 }
 
 // downloadChunk attempts to download a single chunk of historical data with retries
-func (hd *HistoricalDownloader) downloadChunk(instrumentToken int64, from, to time.Time, interval string) ([]HistoricalCandle, error) {
+func (hd *HistoricalDownloader) downloadChunk(ctx context.Context, instrumentToken int64, oi bool, from, to time.Time, interval string) ([]HistoricalCandle, error) {
 	var candles []HistoricalCandle
 
 	for i := 0; i < hd.config.Historical.MaxRetries; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		// Try to get historical data for this chunk
 		historicalData, err := hd.kiteConnect.GetHistoricalData(
 			int(instrumentToken),
@@ -175,7 +228,7 @@ func (hd *HistoricalDownloader) downloadChunk(instrumentToken int64, from, to ti
 			from,
 			to,
 			false,
-			false,
+			oi,
 		)
 
 		if err == nil {
@@ -188,6 +241,7 @@ func (hd *HistoricalDownloader) downloadChunk(instrumentToken int64, from, to ti
 					Low:       data.Low,
 					Close:     data.Close,
 					Volume:    int64(data.Volume),
+					OI:        int64(data.OI),
 				}
 
 				candles = append(candles, candle)
@@ -212,16 +266,18 @@ func (hd *HistoricalDownloader) downloadChunk(instrumentToken int64, from, to ti
 			log.Printf("Reducing chunk size: splitting at %s", mid.Format("2006-01-02"))
 
 			// Download the first half
-			firstHalf, err := hd.downloadChunk(instrumentToken, from, mid, interval)
+			firstHalf, err := hd.downloadChunk(ctx, instrumentToken, oi, from, mid, interval)
 			if err != nil {
 				return nil, err
 			}
 
 			// Add delay between requests
-			time.Sleep(time.Duration(hd.config.Historical.RequestDelay) * time.Millisecond)
+			if err := wait(ctx, hd.config.Historical.RequestDelay); err != nil {
+				return nil, err
+			}
 
 			// Download the second half
-			secondHalf, err := hd.downloadChunk(instrumentToken, mid.Add(time.Second), to, interval)
+			secondHalf, err := hd.downloadChunk(ctx, instrumentToken, oi, mid.Add(time.Second), to, interval)
 			if err != nil {
 				return nil, err
 			}
@@ -232,97 +288,11 @@ func (hd *HistoricalDownloader) downloadChunk(instrumentToken int64, from, to ti
 
 		// If we've hit a rate limit, wait longer before retrying
 		if i < hd.config.Historical.MaxRetries-1 {
-			time.Sleep(time.Duration(hd.config.Historical.RequestDelay*2) * time.Millisecond)
+			if err := wait(ctx, hd.config.Historical.RequestDelay*2); err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	return nil, fmt.Errorf("failed to download chunk after %d retries", hd.config.Historical.MaxRetries)
 }
-
-// saveToCSV saves historical data to a CSV file
-func (hd *HistoricalDownloader) saveToCSV(instrument instruments.Instrument, candles []HistoricalCandle) error {
-	// Create output directory if it doesn't exist
-	outputDir := filepath.Join(hd.config.Historical.OutputDir, instrument.TradingSymbol)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
-	}
-
-	// Create output file
-	filename := filepath.Join(outputDir, fmt.Sprintf("%s_historical.csv", instrument.TradingSymbol))
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer file.Close()
-
-	// Write header
-	_, err = file.WriteString("timestamp,date,open,high,low,close,volume\n")
-	if err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
-	}
-
-	// Write data
-	for _, candle := range candles {
-		line := fmt.Sprintf("%d,%s,%.2f,%.2f,%.2f,%.2f,%d\n",
-			candle.Timestamp.Unix(),
-			candle.Timestamp.Format("2006-01-02"),
-			candle.Open,
-			candle.High,
-			candle.Low,
-			candle.Close,
-			candle.Volume,
-		)
-		_, err = file.WriteString(line)
-		if err != nil {
-			return fmt.Errorf("failed to write data: %w", err)
-		}
-	}
-
-	log.Printf("Saved %d data points to %s", len(candles), filename)
-	return nil
-}
-
-// convertToParquet converts historical data to Parquet format
-func (hd *HistoricalDownloader) convertToParquet(instrument instruments.Instrument, candles []HistoricalCandle) error {
-	if len(candles) == 0 {
-		log.Printf("No candles to convert for %s", instrument.TradingSymbol)
-		return nil
-	}
-
-	// Group candles by month to create separate files
-	// This helps with both organization and query performance
-	candlesByYearMonth := make(map[string][]HistoricalCandle)
-
-	for _, candle := range candles {
-		yearMonth := candle.Timestamp.Format("2006-01")
-		candlesByYearMonth[yearMonth] = append(candlesByYearMonth[yearMonth], candle)
-	}
-
-	// Process each month group separately
-	for yearMonth, monthCandles := range candlesByYearMonth {
-		// Get the first candle to determine year and month for directory
-		firstCandle := monthCandles[0]
-		year := firstCandle.Timestamp.Year()
-		month := firstCandle.Timestamp.Month()
-
-		// Create directory for the symbol
-		dirPath := filepath.Join(hd.config.Historical.ParquetDir, instrument.TradingSymbol)
-		if err := os.MkdirAll(dirPath, 0755); err != nil {
-			return fmt.Errorf("failed to create directory structure: %w", err)
-		}
-
-		// Create parquet file with year-month in the filename
-		filename := filepath.Join(dirPath, fmt.Sprintf("%s_%d-%02d.parquet",
-			instrument.TradingSymbol, year, month))
-
-		// Convert historical candles to parquet format
-		if err := writeCandles(filename, instrument.TradingSymbol, monthCandles); err != nil {
-			return fmt.Errorf("failed to write parquet file: %w", err)
-		}
-
-		log.Printf("Converted %d data points to parquet for %s in %s: %s",
-			len(monthCandles), instrument.TradingSymbol, yearMonth, filename)
-	}
-
-	return nil
-}
\ No newline at end of file
@@ -0,0 +1,59 @@
+package historical
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/instruments"
+)
+
+// intervalDurations lists the candle intervals supported by Kite's historical API
+var intervalDurations = map[string]time.Duration{
+	"minute":   time.Minute,
+	"3minute":  3 * time.Minute,
+	"5minute":  5 * time.Minute,
+	"10minute": 10 * time.Minute,
+	"15minute": 15 * time.Minute,
+	"30minute": 30 * time.Minute,
+	"60minute": time.Hour,
+	"day":      24 * time.Hour,
+}
+
+// SessionOpen is when the NSE/NFO cash session opens; intraday candles are aligned to it
+const SessionOpen = 9*time.Hour + 15*time.Minute
+
+// KiteInterval converts a configured interval name to the one Kite's API expects.
+// "hour" is accepted as an alias for "60minute".
+func KiteInterval(name string) (string, error) {
+	if name == "hour" {
+		return "60minute", nil
+	}
+	if _, ok := intervalDurations[name]; !ok {
+		return "", fmt.Errorf("invalid interval: %s", name)
+	}
+	return name, nil
+}
+
+// IntervalDuration returns the length of a candle interval
+func IntervalDuration(name string) (time.Duration, error) {
+	kiteName, err := KiteInterval(name)
+	if err != nil {
+		return 0, err
+	}
+	return intervalDurations[kiteName], nil
+}
+
+// CandleStart returns the start of the interval bucket containing t.
+// Daily buckets start at midnight IST, intraday buckets are counted from the session open.
+func CandleStart(t time.Time, d time.Duration) time.Time {
+	t = t.In(instruments.IST)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, instruments.IST)
+	if d >= 24*time.Hour {
+		return midnight
+	}
+	open := midnight.Add(SessionOpen)
+	if t.Before(open) {
+		return t.Truncate(d)
+	}
+	return open.Add(t.Sub(open) / d * d)
+}
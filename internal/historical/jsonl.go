@@ -0,0 +1,118 @@
+package historical
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/storage"
+)
+
+// JSONLSink writes each series to <output_dir>/<SYMBOL>/<SYMBOL>_historical.jsonl,
+// one HistoricalDataPoint object per line, replacing the file with the latest batch
+type JSONLSink struct {
+	OutputDir string
+	// Location is the timezone of timestamps and calendar fields
+	Location *time.Location
+	Storage  storage.Storage
+}
+
+// NewJSONLSink creates the jsonl sink from the configuration
+func NewJSONLSink(cfg *config.Config) (Sink, error) {
+	location, err := LoadTimezone(cfg.Historical.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.FromConfig(cfg.Historical)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLSink{OutputDir: cfg.Historical.OutputDir, Location: location, Storage: store}, nil
+}
+
+// JSONLPath returns the JSON Lines file holding a symbol's candles
+func JSONLPath(outputDir, symbol string) string {
+	return historicalPath(outputDir, symbol, ".jsonl")
+}
+
+// Open prepares the output directory, removing stale temporary files
+func (s *JSONLSink) Open(ctx context.Context) error {
+	return s.Storage.Prepare(ctx, s.OutputDir)
+}
+
+// Write saves a series' candles to its JSON Lines file
+func (s *JSONLSink) Write(ctx context.Context, series Series, candles []HistoricalCandle) error {
+	filename := JSONLPath(s.OutputDir, series.Symbol)
+	err := s.Storage.WriteFile(ctx, filename, func(w io.Writer) error {
+		return encodeJSONL(w, series, candles, s.Location)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write JSON Lines file: %w", err)
+	}
+
+	log.Printf("Saved %d data points to %s", len(candles), s.Storage.Location(filename))
+	return nil
+}
+
+// OpenSeries streams a series into a new version of its JSON Lines file,
+// which replaces the old one once the series is complete
+func (s *JSONLSink) OpenSeries(ctx context.Context, series Series) (SeriesWriter, error) {
+	filename := JSONLPath(s.OutputDir, series.Symbol)
+	f, err := s.Storage.Create(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	enc := newJSONLEncoder(f, series, s.Location)
+	return &fileSeries{
+		file:     f,
+		location: s.Storage.Location(filename),
+		write:    enc.write,
+		close:    enc.close,
+	}, nil
+}
+
+// Close implements Sink; every write is already complete on disk
+func (s *JSONLSink) Close() error {
+	return nil
+}
+
+// encodeJSONL writes one JSON object per candle to w
+func encodeJSONL(w io.Writer, series Series, candles []HistoricalCandle, loc *time.Location) error {
+	enc := newJSONLEncoder(w, series, loc)
+	if err := enc.write(candles); err != nil {
+		return err
+	}
+	return enc.close()
+}
+
+// jsonlEncoder writes a series as JSON Lines in one or more batches
+type jsonlEncoder struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+	series   Series
+	loc      *time.Location
+}
+
+func newJSONLEncoder(w io.Writer, series Series, loc *time.Location) *jsonlEncoder {
+	buffered := bufio.NewWriter(w)
+	return &jsonlEncoder{buffered: buffered, encoder: json.NewEncoder(buffered), series: series, loc: loc}
+}
+
+func (enc *jsonlEncoder) write(candles []HistoricalCandle) error {
+	for _, candle := range candles {
+		// Encode terminates every object with a newline
+		if err := enc.encoder.Encode(newDataPoint(enc.series, candle, enc.loc)); err != nil {
+			return fmt.Errorf("failed to write data: %w", err)
+		}
+	}
+	return nil
+}
+
+func (enc *jsonlEncoder) close() error {
+	return enc.buffered.Flush()
+}
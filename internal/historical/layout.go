@@ -0,0 +1,185 @@
+package historical
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parquet layouts
+const (
+	// LayoutSymbol stores <dir>/<SYMBOL>/<SYMBOL>_<INTERVAL>_<YYYY-MM>.parquet
+	LayoutSymbol = "symbol"
+	// LayoutHive stores <dir>/exchange=<EX>/interval=<IV>/symbol=<SYMBOL>/year=<YYYY>/month=<M>/part-00000.parquet
+	LayoutHive = "hive"
+)
+
+// hiveDefaultPartition is Hive's name for partitions with an empty value
+const hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// ParquetLayout decides where monthly parquet partitions are stored
+type ParquetLayout struct {
+	Name string
+	// KeepPartitionColumns keeps columns encoded in hive partition
+	// directories inside the data files as well
+	KeepPartitionColumns bool
+	// LegacyInterval is the interval of symbol layout files named without
+	// one, <SYMBOL>_<YYYY-MM>.parquet. They are read as that interval and
+	// merged into the current name on the next write of their month.
+	LegacyInterval string
+}
+
+// Path returns the file holding a series' candles for the month of t
+func (l ParquetLayout) Path(dir string, series Series, t time.Time) string {
+	if l.Name == LayoutHive {
+		return filepath.Join(dir,
+			"exchange="+hiveValue(series.Exchange),
+			"interval="+hiveValue(series.Interval),
+			"symbol="+hiveValue(series.Symbol),
+			fmt.Sprintf("year=%d", t.Year()),
+			fmt.Sprintf("month=%d", int(t.Month())),
+			"part-00000.parquet")
+	}
+	return filepath.Join(dir, series.Symbol, fmt.Sprintf("%s_%s_%d-%02d.parquet", series.Symbol, series.Interval, t.Year(), t.Month()))
+}
+
+// legacyPath returns the file a series' month was stored in before symbol
+// layout names carried the interval, or "" when the series has none
+func (l ParquetLayout) legacyPath(dir string, series Series, t time.Time) string {
+	if l.Name == LayoutHive || l.LegacyInterval == "" || series.Interval != l.LegacyInterval {
+		return ""
+	}
+	return filepath.Join(dir, series.Symbol, fmt.Sprintf("%s_%d-%02d.parquet", series.Symbol, t.Year(), t.Month()))
+}
+
+// dropsPartitionColumns reports whether partition columns are left out of the data
+func (l ParquetLayout) dropsPartitionColumns() bool {
+	return l.Name == LayoutHive && !l.KeepPartitionColumns
+}
+
+// Files returns a symbol's parquet files in chronological order, narrowed to
+// the given interval unless it is empty
+func (l ParquetLayout) Files(dir, symbol, interval string) ([]string, error) {
+	pattern := filepath.Join(dir, symbol, symbol+"_*.parquet")
+	if interval != "" {
+		pattern = filepath.Join(dir, symbol, symbol+"_"+interval+"_*.parquet")
+	}
+	if l.Name == LayoutHive {
+		intervalDir := "interval=*"
+		if interval != "" {
+			intervalDir = "interval=" + hiveValue(interval)
+		}
+		pattern = filepath.Join(dir, "exchange=*", intervalDir, "symbol="+hiveValue(symbol), "year=*", "month=*", "*.parquet")
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if l.Name != LayoutHive && interval != "" && interval == l.LegacyInterval {
+		legacy, err := filepath.Glob(filepath.Join(dir, symbol, symbol+"_[0-9][0-9][0-9][0-9]-[0-9][0-9].parquet"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, legacy...)
+	}
+
+	// Hive months are not zero-padded, so order by the parsed partition values
+	sort.SliceStable(files, func(i, j int) bool {
+		return partitionKey(files[i]) < partitionKey(files[j])
+	})
+	return files, nil
+}
+
+// Symbols lists the symbols that have parquet files under dir
+func (l ParquetLayout) Symbols(dir string) ([]string, error) {
+	if l.Name != LayoutHive {
+		return StoredSymbols(dir)
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(dir, "exchange=*", "interval=*", "symbol=*"))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var symbols []string
+	for _, d := range dirs {
+		symbol := strings.TrimPrefix(filepath.Base(d), "symbol=")
+		if info, err := os.Stat(d); err == nil && info.IsDir() && !seen[symbol] {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols, nil
+}
+
+// ParseParquetLayout validates a configured layout name
+func ParseParquetLayout(name string, keepPartitionColumns bool) (ParquetLayout, error) {
+	switch name {
+	case LayoutSymbol, LayoutHive:
+		return ParquetLayout{Name: name, KeepPartitionColumns: keepPartitionColumns}, nil
+	}
+	return ParquetLayout{}, fmt.Errorf("invalid parquet layout %q (use %s or %s)", name, LayoutSymbol, LayoutHive)
+}
+
+// hiveValue escapes a partition value for use in a directory name
+func hiveValue(value string) string {
+	if value == "" {
+		return hiveDefaultPartition
+	}
+	return strings.NewReplacer("/", "%2F", "=", "%3D").Replace(value)
+}
+
+// fileInterval returns the interval in a symbol layout file name, or the
+// legacy interval for names without one
+func (l ParquetLayout) fileInterval(path, symbol string) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), symbol+"_"), ".parquet")
+	if len(name) <= len("2006-01") {
+		return l.LegacyInterval
+	}
+	return strings.TrimSuffix(name[:len(name)-len("2006-01")], "_")
+}
+
+// partitionKey builds a sortable key from a parquet file path, using the
+// year and month of hive directories or the YYYY-MM of symbol layout names
+func partitionKey(path string) string {
+	year, month, ok := partitionMonth(path)
+	if !ok {
+		return path
+	}
+	return fmt.Sprintf("%04d-%02d|%s", year, month, path)
+}
+
+// partitionMonth returns the month a parquet partition holds, read from its
+// hive year=/month= directories or the _YYYY-MM suffix of its file name
+func partitionMonth(path string) (year int, month time.Month, ok bool) {
+	m := 0
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if v, found := strings.CutPrefix(part, "year="); found {
+			year, _ = strconv.Atoi(v)
+		}
+		if v, found := strings.CutPrefix(part, "month="); found {
+			m, _ = strconv.Atoi(v)
+		}
+	}
+	if year == 0 {
+		name := strings.TrimSuffix(filepath.Base(path), ".parquet")
+		if len(name) < 8 || name[len(name)-8] != '_' {
+			return 0, 0, false
+		}
+		t, err := time.Parse("2006-01", name[len(name)-7:])
+		if err != nil {
+			return 0, 0, false
+		}
+		return t.Year(), t.Month(), true
+	}
+	if m < 1 || m > 12 {
+		return 0, 0, false
+	}
+	return year, time.Month(m), true
+}
@@ -0,0 +1,85 @@
+package historical
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/storage"
+)
+
+// TestLegacyParquetFiles reads a month stored under the symbol layout name
+// without an interval, and merges it into the current name on the next write
+func TestLegacyParquetFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	opts, err := ParquetOptionsFromConfig(config.Defaults().Historical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Layout.LegacyInterval != "minute" {
+		t.Fatalf("legacy interval %q, want minute", opts.Layout.LegacyInterval)
+	}
+
+	series := Series{Symbol: "RELIANCE", Exchange: "NSE", Interval: "minute", Source: SourceKite}
+	candle := func(day, minute int, price float64) HistoricalCandle {
+		at := time.Date(2024, 6, day, 9, 15+minute, 0, 0, opts.Location)
+		return HistoricalCandle{Timestamp: at, Open: price, High: price, Low: price, Close: price, Volume: 100}
+	}
+
+	// A month written by an earlier version
+	legacy := filepath.Join(dir, "RELIANCE", "RELIANCE_2024-06.parquet")
+	old := []HistoricalCandle{candle(13, 0, 10), candle(13, 1, 11), candle(14, 0, 12)}
+	err = storage.Local{}.WriteFile(ctx, legacy, func(w io.Writer) error {
+		return encodeCandles(w, series, old, opts)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := QueryOptions{Series: series, Source: QuerySourceParquet, ParquetDir: dir, Parquet: opts}
+	got, err := Query(ctx, query)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(got) != 3 {
+		t.Errorf("queried %d legacy candles, want 3", len(got))
+	}
+	intervals, err := StoredIntervals(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(intervals, []string{"minute"}) {
+		t.Errorf("stored intervals %v, want [minute]", intervals)
+	}
+
+	// Another interval does not read the legacy month
+	if files, _ := opts.Layout.Files(dir, "RELIANCE", "day"); len(files) != 0 {
+		t.Errorf("day files %v, want none", files)
+	}
+
+	// Writing the month merges the legacy file, with the new candle winning
+	sink := &ParquetSink{ParquetDir: dir, Options: opts, Storage: storage.Local{}}
+	if err := sink.Write(ctx, series, []HistoricalCandle{candle(14, 0, 20), candle(14, 1, 21)}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy file still exists: %v", err)
+	}
+	got, err = ReadParquet(filepath.Join(dir, "RELIANCE", "RELIANCE_minute_2024-06.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var closes []float64
+	for _, c := range got {
+		closes = append(closes, c.Close)
+	}
+	if want := []float64{10, 11, 20, 21}; !slices.Equal(closes, want) {
+		t.Errorf("merged closes %v, want %v", closes, want)
+	}
+}
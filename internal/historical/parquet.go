@@ -1,11 +1,20 @@
 package historical
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
-	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/storage"
+	"github.com/xitongsys/parquet-go-source/buffer"
 	"github.com/xitongsys/parquet-go/parquet"
 	"github.com/xitongsys/parquet-go/writer"
 )
@@ -34,51 +43,384 @@ func (fw *LocalFileWriter) Close() error {
 	return fw.file.Close()
 }
 
-// Write candles to parquet file
-func writeCandles(filename string, symbol string, candles []HistoricalCandle) error {
-	// Create parquet file with proper ParquetFile interface
-	fw, err := local.NewLocalFileWriter(filename)
+// ParquetOptions controls where and how parquet files are written
+type ParquetOptions struct {
+	Layout       ParquetLayout
+	Compression  parquet.CompressionCodec
+	RowGroupSize int64
+	PageSize     int64
+	Writers      int64
+	// TimestampUnit is the precision of the timestamp column, millis or micros
+	TimestampUnit string
+	// Location decides the date, year, month and day columns and which
+	// month partition a candle falls into
+	Location *time.Location
+}
+
+// timestampUnits maps configured timestamp units to their parquet name
+// and conversion from time.Time
+var timestampUnits = map[string]struct {
+	name   string
+	encode func(t time.Time) int64
+}{
+	"millis": {"MILLIS", time.Time.UnixMilli},
+	"micros": {"MICROS", time.Time.UnixMicro},
+}
+
+// parquetColumn describes one column of the stored candle schema
+type parquetColumn struct {
+	name string
+	// tag is the parquet-go schema definition following the column name
+	tag string
+	// partition marks columns that hive partition directories already encode
+	partition bool
+	value     func(p *HistoricalDataPoint) interface{}
+}
+
+// candleColumns is the parquet schema of stored candles, in file order
+var candleColumns = []parquetColumn{
+	{"symbol", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", true,
+		func(p *HistoricalDataPoint) interface{} { return p.Symbol }},
+	{"instrument_token", "type=INT64, encoding=PLAIN_DICTIONARY", false,
+		func(p *HistoricalDataPoint) interface{} { return p.InstrumentToken }},
+	{"exchange", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", true,
+		func(p *HistoricalDataPoint) interface{} { return p.Exchange }},
+	{"interval", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", true,
+		func(p *HistoricalDataPoint) interface{} { return p.Interval }},
+	// The timestamp type and value depend on the configured unit, see columns
+	{"timestamp", "", false, nil},
+	{"date", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", false,
+		func(p *HistoricalDataPoint) interface{} { return p.Date }},
+	{"year", "type=INT32, encoding=PLAIN_DICTIONARY", true,
+		func(p *HistoricalDataPoint) interface{} { return p.Year }},
+	{"month", "type=INT32, encoding=PLAIN_DICTIONARY", true,
+		func(p *HistoricalDataPoint) interface{} { return p.Month }},
+	{"day", "type=INT32, encoding=PLAIN_DICTIONARY", false,
+		func(p *HistoricalDataPoint) interface{} { return p.Day }},
+	{"open", "type=DOUBLE, encoding=PLAIN", false,
+		func(p *HistoricalDataPoint) interface{} { return p.Open }},
+	{"high", "type=DOUBLE, encoding=PLAIN", false,
+		func(p *HistoricalDataPoint) interface{} { return p.High }},
+	{"low", "type=DOUBLE, encoding=PLAIN", false,
+		func(p *HistoricalDataPoint) interface{} { return p.Low }},
+	{"close", "type=DOUBLE, encoding=PLAIN", false,
+		func(p *HistoricalDataPoint) interface{} { return p.Close }},
+	{"volume", "type=INT64, encoding=DELTA_BINARY_PACKED", false,
+		func(p *HistoricalDataPoint) interface{} { return p.Volume }},
+	{"oi", "type=INT64, encoding=DELTA_BINARY_PACKED", false,
+		func(p *HistoricalDataPoint) interface{} { return p.OI }},
+	{"source", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", false,
+		func(p *HistoricalDataPoint) interface{} { return p.Source }},
+}
+
+// columns returns the candle columns written under these options
+func (opts ParquetOptions) columns() []parquetColumn {
+	var columns []parquetColumn
+	for _, column := range candleColumns {
+		if column.partition && opts.Layout.dropsPartitionColumns() {
+			continue
+		}
+		if column.name == "timestamp" {
+			// UTC-adjusted instants; readers apply their own display timezone
+			unit := timestampUnits[opts.TimestampUnit]
+			column.tag = fmt.Sprintf("type=INT64, convertedtype=TIMESTAMP_%s, logicaltype=TIMESTAMP, "+
+				"logicaltype.isadjustedtoutc=true, logicaltype.unit=%s, encoding=DELTA_BINARY_PACKED", unit.name, unit.name)
+			column.value = func(p *HistoricalDataPoint) interface{} { return unit.encode(p.Timestamp) }
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// parquetCodecs maps configured compression names to parquet codecs
+var parquetCodecs = map[string]parquet.CompressionCodec{
+	"none":   parquet.CompressionCodec_UNCOMPRESSED,
+	"snappy": parquet.CompressionCodec_SNAPPY,
+	"gzip":   parquet.CompressionCodec_GZIP,
+	"zstd":   parquet.CompressionCodec_ZSTD,
+	"lz4":    parquet.CompressionCodec_LZ4,
+}
+
+// ParquetOptionsFromConfig validates the parquet settings of the historical config
+func ParquetOptionsFromConfig(cfg config.HistoricalConfig) (ParquetOptions, error) {
+	codec, ok := parquetCodecs[strings.ToLower(cfg.ParquetCompression)]
+	if !ok {
+		return ParquetOptions{}, fmt.Errorf("invalid parquet compression %q (use none, snappy, gzip, zstd or lz4)", cfg.ParquetCompression)
+	}
+	if cfg.ParquetRowGroupSize <= 0 || cfg.ParquetPageSize <= 0 || cfg.ParquetWriters <= 0 {
+		return ParquetOptions{}, fmt.Errorf("parquet row group size, page size and writers must be positive")
+	}
+	if cfg.ParquetPageSize > cfg.ParquetRowGroupSize {
+		return ParquetOptions{}, fmt.Errorf("parquet page size %d exceeds row group size %d", cfg.ParquetPageSize, cfg.ParquetRowGroupSize)
+	}
+
+	if _, ok := timestampUnits[cfg.ParquetTimestampUnit]; !ok {
+		return ParquetOptions{}, fmt.Errorf("invalid parquet timestamp unit %q (use millis or micros)", cfg.ParquetTimestampUnit)
+	}
+	location, err := LoadTimezone(cfg.Timezone)
 	if err != nil {
-		return fmt.Errorf("failed to create parquet file: %w", err)
+		return ParquetOptions{}, err
 	}
-	defer fw.Close()
 
-	// Define parquet schema - use optimized row groups for better compression
-	pw, err := writer.NewParquetWriter(fw, new(HistoricalDataPoint), 4)
+	layout, err := ParseParquetLayout(cfg.ParquetLayout, cfg.ParquetKeepPartitionColumns)
 	if err != nil {
-		return fmt.Errorf("failed to create parquet writer: %w", err)
+		return ParquetOptions{}, err
 	}
+	// Files named without an interval were downloaded with the configured one
+	layout.LegacyInterval, _ = KiteInterval(cfg.Interval)
+
+	return ParquetOptions{
+		Layout:       layout,
+		Compression:  codec,
+		RowGroupSize: cfg.ParquetRowGroupSize,
+		PageSize:     cfg.ParquetPageSize,
+		Writers:      int64(cfg.ParquetWriters),
 
-	// Configure compression with better ratio
-	pw.CompressionType = parquet.CompressionCodec_GZIP
+		TimestampUnit: cfg.ParquetTimestampUnit,
+		Location:      location,
+	}, nil
+}
+
+// ParquetSink writes each series into monthly parquet partitions under
+// parquet_dir, merging with partitions written earlier
+type ParquetSink struct {
+	ParquetDir string
+	Options    ParquetOptions
+	Storage    storage.Storage
+}
 
-	// Configure row group size and page size for better compression
+// NewParquetSink creates the parquet sink from the configuration
+func NewParquetSink(cfg *config.Config) (Sink, error) {
+	opts, err := ParquetOptionsFromConfig(cfg.Historical)
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.FromConfig(cfg.Historical)
+	if err != nil {
+		return nil, err
+	}
+	return &ParquetSink{ParquetDir: cfg.Historical.ParquetDir, Options: opts, Storage: store}, nil
+}
+
+// Open prepares the parquet directory, removing stale temporary files
+func (s *ParquetSink) Open(ctx context.Context) error {
+	return s.Storage.Prepare(ctx, s.ParquetDir)
+}
+
+// Write merges a series' candles into its monthly partitions
+func (s *ParquetSink) Write(ctx context.Context, series Series, candles []HistoricalCandle) error {
+	return writeMonthlyParquet(ctx, s.Storage, s.ParquetDir, series, candles, s.Options)
+}
+
+// OpenSeries streams a series into its monthly partitions. Candles are held
+// until their month is complete, so each partition is merged once and at
+// most a month of candles is in memory.
+func (s *ParquetSink) OpenSeries(ctx context.Context, series Series) (SeriesWriter, error) {
+	return &parquetSeries{sink: s, series: series}, nil
+}
+
+// Close implements Sink; every partition is already complete on disk
+func (s *ParquetSink) Close() error {
+	return nil
+}
+
+// parquetSeries buffers a series' candles for the month being downloaded
+type parquetSeries struct {
+	sink    *ParquetSink
+	series  Series
+	ctx     context.Context
+	pending []HistoricalCandle
+}
+
+func (p *parquetSeries) Write(ctx context.Context, candles []HistoricalCandle) error {
+	if len(candles) == 0 {
+		return nil
+	}
+	p.ctx = ctx
+	p.pending = append(p.pending, candles...)
+
+	// Everything before the month of the newest candle is complete
+	loc := p.sink.Options.Location
+	current := candles[len(candles)-1].Timestamp.In(loc).Format("2006-01")
+	split := 0
+	for split < len(p.pending) && p.pending[split].Timestamp.In(loc).Format("2006-01") < current {
+		split++
+	}
+	if split == 0 {
+		return nil
+	}
+	if err := p.sink.Write(ctx, p.series, p.pending[:split]); err != nil {
+		return err
+	}
+	p.pending = slices.Clone(p.pending[split:])
+	return nil
+}
+
+func (p *parquetSeries) Close() error {
+	if len(p.pending) == 0 {
+		return nil
+	}
+	ctx := p.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	err := p.sink.Write(ctx, p.series, p.pending)
+	p.pending = nil
+	return err
+}
+
+// Abort drops the unfinished month; completed months are already merged
+func (p *parquetSeries) Abort() {
+	p.pending = nil
+}
+
+// writeMonthlyParquet writes candles into one parquet file per month,
+// placed according to the configured layout
+func writeMonthlyParquet(ctx context.Context, store storage.Storage, parquetDir string, series Series, candles []HistoricalCandle, opts ParquetOptions) error {
+	symbol := series.Symbol
+
+	if len(candles) == 0 {
+		log.Printf("No candles to convert for %s", symbol)
+		return nil
+	}
+
+	// Group candles by month to create separate files
+	// This helps with both organization and query performance
+	candlesByYearMonth := make(map[string][]HistoricalCandle)
+
+	for _, candle := range candles {
+		yearMonth := candle.Timestamp.In(opts.Location).Format("2006-01")
+		candlesByYearMonth[yearMonth] = append(candlesByYearMonth[yearMonth], candle)
+	}
+
+	// Process each month group separately
+	for yearMonth, monthCandles := range candlesByYearMonth {
+		// The first candle determines the month partition
+		month := monthCandles[0].Timestamp.In(opts.Location)
+		filename := opts.Layout.Path(parquetDir, series, month)
+
+		// Convert historical candles to parquet format
+		if err := writeCandles(ctx, store, filename, series, monthCandles, opts); err != nil {
+			return fmt.Errorf("failed to write parquet file: %w", err)
+		}
+		if legacy := opts.Layout.legacyPath(parquetDir, series, month); legacy != "" {
+			if err := migrateLegacyParquet(ctx, store, legacy, filename, series, opts); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Converted %d data points to parquet for %s in %s: %s",
+			len(monthCandles), series.Symbol, yearMonth, store.Location(filename))
+	}
+
+	return nil
+}
+
+// writeCandles writes candles to a parquet file. When the file already exists
+// its rows are merged with the new candles, de-duplicated by timestamp with
+// the new candles winning, so partial downloads never drop earlier data.
+// The result is written to a temporary file and renamed into place.
+func writeCandles(ctx context.Context, store storage.Storage, filename string, series Series, candles []HistoricalCandle, opts ParquetOptions) error {
+	existing, err := readExistingParquet(ctx, store, filename)
+	if err != nil {
+		return err
+	}
+	merged := len(existing)
+	candles = normalizeCandles(append(existing, candles...))
+
+	// Replace the file atomically so readers never see a file without a footer
+	err = store.WriteFile(ctx, filename, func(w io.Writer) error {
+		return encodeCandles(w, series, candles, opts)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write parquet file: %w", err)
+	}
+
+	if merged > 0 {
+		log.Printf("Successfully wrote %d candles to %s (merged with %d existing)", len(candles), store.Location(filename), merged)
+	} else {
+		log.Printf("Successfully wrote %d candles to %s", len(candles), store.Location(filename))
+	}
+	return nil
+}
+
+// migrateLegacyParquet merges a month stored under its name from before
+// symbol layout names carried the interval into the current file, then
+// removes it. The current file's candles win over the legacy ones.
+func migrateLegacyParquet(ctx context.Context, store storage.Storage, legacy, filename string, series Series, opts ParquetOptions) error {
+	old, err := readExistingParquet(ctx, store, legacy)
+	if err != nil || old == nil {
+		return err
+	}
+	current, err := readExistingParquet(ctx, store, filename)
+	if err != nil {
+		return err
+	}
+	candles := normalizeCandles(append(old, current...))
+	err = store.WriteFile(ctx, filename, func(w io.Writer) error {
+		return encodeCandles(w, series, candles, opts)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write parquet file: %w", err)
+	}
+	if err := store.Remove(ctx, legacy); err != nil {
+		return fmt.Errorf("failed to remove %s after merging it: %w", store.Location(legacy), err)
+	}
+	log.Printf("Merged %d candles from %s into %s", len(old), store.Location(legacy), store.Location(filename))
+	return nil
+}
+
+// readExistingParquet returns the candles already stored in a parquet file,
+// or none when the file does not exist yet
+func readExistingParquet(ctx context.Context, store storage.Storage, filename string) ([]HistoricalCandle, error) {
+	data, err := store.ReadFile(ctx, filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read existing parquet file %s for merging: %w", store.Location(filename), err)
+	}
+
+	pf, err := buffer.NewBufferFile(data)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := readParquetCandles(pf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read existing parquet file %s for merging: %w", store.Location(filename), err)
+	}
+	return existing, nil
+}
+
+// encodeCandles writes candles in parquet format to w
+func encodeCandles(w io.Writer, series Series, candles []HistoricalCandle, opts ParquetOptions) error {
+	// Define parquet schema from the column table
+	columns := opts.columns()
+	metadata := make([]string, len(columns))
+	for i, column := range columns {
+		metadata[i] = "name=" + column.name + ", " + column.tag
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(metadata, w, opts.Writers)
+	if err != nil {
+		return fmt.Errorf("failed to create parquet writer: %w", err)
+	}
+
+	// Configure compression, row group size and page size
 	// A larger row group allows better compression
-	pw.RowGroupSize = 128 * 1024 * 1024 // 128MB row groups
-	pw.PageSize = 8 * 1024             // 8KB pages
+	pw.CompressionType = opts.Compression
+	pw.RowGroupSize = opts.RowGroupSize
+	pw.PageSize = opts.PageSize
 
-	// Write data for this month
 	for _, candle := range candles {
-		// Extract date components for partitioning
-		candleYear := candle.Timestamp.Year()
-		candleMonth := candle.Timestamp.Month()
-		candleDay := candle.Timestamp.Day()
-
-		point := HistoricalDataPoint{
-			Symbol:    symbol,
-			Timestamp: candle.Timestamp.Unix(),
-			Date:      candle.Timestamp.Format("2006-01-02"),
-			Year:      int32(candleYear),
-			Month:     int32(candleMonth),
-			Day:       int32(candleDay),
-			Open:      candle.Open,
-			High:      candle.High,
-			Low:       candle.Low,
-			Close:     candle.Close,
-			Volume:    candle.Volume,
+		point := newDataPoint(series, candle, opts.Location)
+
+		row := make([]interface{}, len(columns))
+		for i, column := range columns {
+			row[i] = column.value(&point)
 		}
 
-		if err := pw.Write(point); err != nil {
+		if err := pw.Write(row); err != nil {
 			return fmt.Errorf("failed to write parquet data: %w", err)
 		}
 	}
@@ -87,7 +429,5 @@ func writeCandles(filename string, symbol string, candles []HistoricalCandle) er
 	if err := pw.WriteStop(); err != nil {
 		return fmt.Errorf("failed to finalize parquet file: %w", err)
 	}
-
-	log.Printf("Successfully wrote %d candles to %s", len(candles), filename)
 	return nil
-}
\ No newline at end of file
+}
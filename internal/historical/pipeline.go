@@ -0,0 +1,243 @@
+package historical
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/instruments"
+)
+
+// pipelineBuffer is how many chunks may wait between two pipeline stages.
+// A full buffer blocks the stage before it, so at most a few chunks are in
+// memory however long the range is.
+const pipelineBuffer = 2
+
+// chunk is one request's worth of a series moving through the pipeline
+type chunk struct {
+	series  Series
+	candles []HistoricalCandle
+	// first and last mark the chunks that start and complete the series
+	first, last bool
+	// err ends the series early; the chunk carries no candles
+	err error
+}
+
+// runPipeline downloads instruments through four stages connected by bounded
+// channels: fetch requests each chunk from Kite, normalize sorts it and drops
+// candles already seen, validate reports suspicious candles, and the sink
+// stage writes every chunk as soon as it arrives. It returns how many
+// instruments could not be downloaded or saved.
+func (hd *HistoricalDownloader) runPipeline(ctx context.Context, list []instruments.Instrument, interval string, from, to time.Time) (int, error) {
+	stageCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	stage := func(run func(out chan<- chunk)) <-chan chunk {
+		out := make(chan chunk, pipelineBuffer)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(out)
+			run(out)
+		}()
+		return out
+	}
+
+	fetched := stage(func(out chan<- chunk) { hd.fetchStage(stageCtx, list, interval, from, to, out) })
+	normalized := stage(func(out chan<- chunk) { normalizeStage(stageCtx, fetched, out) })
+	validated := stage(func(out chan<- chunk) { validateStage(stageCtx, normalized, out) })
+	failed := hd.sinkStage(stageCtx, validated)
+
+	// The sink stage drains the pipeline unless ctx was cancelled; make sure
+	// the other stages stop either way before returning
+	cancel()
+	wg.Wait()
+	return failed, ctx.Err()
+}
+
+// send passes c to the next stage, blocking while it is busy
+func send(ctx context.Context, out chan<- chunk, c chunk) bool {
+	select {
+	case out <- c:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// fetchStage downloads every instrument in request-sized chunks
+func (hd *HistoricalDownloader) fetchStage(ctx context.Context, list []instruments.Instrument, interval string, from, to time.Time, out chan<- chunk) {
+	for i, instrument := range list {
+		if i > 0 {
+			// Respect rate limits
+			if wait(ctx, hd.config.Historical.RequestDelay) != nil {
+				return
+			}
+		}
+
+		log.Printf("Downloading historical data for %s (%s)...", instrument.Name, instrument.TradingSymbol)
+		series := newSeries(instrument, interval)
+
+		first := true
+		err := hd.FetchChunks(ctx, instrument, interval, from, to, func(candles []HistoricalCandle, last bool) error {
+			if !send(ctx, out, chunk{series: series, candles: candles, first: first, last: last}) {
+				return ctx.Err()
+			}
+			first = false
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !send(ctx, out, chunk{series: series, first: first, last: true, err: err}) {
+			return
+		}
+	}
+}
+
+// normalizeStage sorts each chunk and drops candles at or before the end of
+// the previous chunk, which overlapping requests can return twice
+func normalizeStage(ctx context.Context, in <-chan chunk, out chan<- chunk) {
+	var last time.Time
+	for c := range in {
+		if c.first {
+			last = time.Time{}
+		}
+		candles := normalizeCandles(c.candles)
+		start := 0
+		for start < len(candles) && !candles[start].Timestamp.After(last) {
+			start++
+		}
+		c.candles = candles[start:]
+		if len(c.candles) > 0 {
+			last = c.candles[len(c.candles)-1].Timestamp
+		}
+		if !send(ctx, out, c) {
+			return
+		}
+	}
+}
+
+// validateStage checks each chunk, including the step from the previous
+// chunk's last candle, and logs what it finds. Candles pass through as
+// downloaded; `kitedata verify` reports on them in full.
+func validateStage(ctx context.Context, in <-chan chunk, out chan<- chunk) {
+	var prev []HistoricalCandle
+	for c := range in {
+		if c.first {
+			prev = nil
+		}
+		if len(c.candles) > 0 {
+			issues, err := VerifyCandles(append(prev, c.candles...), c.series.Interval)
+			if err != nil {
+				log.Printf("Warning: cannot validate %s: %v", c.series.Symbol, err)
+			}
+			// The previous candle was checked with its own chunk
+			if len(prev) > 0 {
+				kept := issues[:0]
+				for _, issue := range issues {
+					if !issue.Timestamp.Equal(prev[0].Timestamp) {
+						kept = append(kept, issue)
+					}
+				}
+				issues = kept
+			}
+			if len(issues) > 0 {
+				log.Printf("Warning: %d issues in %s candles, first: %s", len(issues), c.series.Symbol, issues[0])
+			}
+			prev = []HistoricalCandle{c.candles[len(c.candles)-1]}
+		}
+		if !send(ctx, out, c) {
+			return
+		}
+	}
+}
+
+// sinkStage writes each chunk to the sink as it arrives. A series that fails
+// to download or save is abandoned; other series carry on. It returns the
+// number of abandoned series.
+func (hd *HistoricalDownloader) sinkStage(ctx context.Context, in <-chan chunk) int {
+	var writer SeriesWriter
+	skip := false
+	failed := 0
+	abandon := func() {
+		if writer != nil {
+			writer.Abort()
+			writer = nil
+		}
+		skip = true
+		failed++
+	}
+	// Abort a series left open when ctx is cancelled mid-series
+	defer func() {
+		if writer != nil {
+			writer.Abort()
+		}
+	}()
+
+	for c := range in {
+		if c.first {
+			writer, skip = nil, false
+		}
+		if skip {
+			continue
+		}
+		if c.err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error downloading data for %s: %v, skipping...", c.series.Symbol, c.err)
+			}
+			abandon()
+			continue
+		}
+
+		if writer == nil {
+			w, err := OpenSeries(ctx, hd.sink, c.series)
+			if err != nil {
+				log.Printf("Error saving data for %s: %v", c.series.Symbol, err)
+				abandon()
+				continue
+			}
+			writer = w
+		}
+		if err := writer.Write(ctx, c.candles); err != nil {
+			log.Printf("Error saving data for %s: %v", c.series.Symbol, err)
+			abandon()
+			continue
+		}
+		if c.last {
+			err := writer.Close()
+			writer = nil
+			if err != nil {
+				log.Printf("Error saving data for %s: %v", c.series.Symbol, err)
+				failed++
+			}
+		}
+	}
+	return failed
+}
+
+// timeRange is the period of one historical data request
+type timeRange struct {
+	from, to time.Time
+}
+
+// requestRanges splits a period into requests Kite accepts. Minute
+// intervals are limited to 60 days per request; longer ones are not split.
+func requestRanges(from, to time.Time, interval string) []timeRange {
+	if !isMinuteInterval(interval) || to.Sub(from) <= maxMinuteRange {
+		return []timeRange{{from, to}}
+	}
+
+	var ranges []timeRange
+	for current := from; current.Before(to); {
+		end := current.Add(maxMinuteRange)
+		if end.After(to) {
+			end = to
+		}
+		ranges = append(ranges, timeRange{current, end})
+		current = end.Add(time.Second)
+	}
+	return ranges
+}
@@ -0,0 +1,236 @@
+package historical
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Stores a query can read from
+const (
+	QuerySourceAuto    = "auto"
+	QuerySourceParquet = "parquet"
+	QuerySourceCSV     = "csv"
+)
+
+// Output formats for WriteCandles
+const (
+	FormatTable = "table"
+	FormatCSV   = "csv"
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+	FormatArrow = "arrow"
+)
+
+// QueryOptions selects stored candles of one symbol
+type QueryOptions struct {
+	// Series names the symbol and interval; token, exchange and tick size
+	// are only used when formatting the result
+	Series Series
+	// From and To bound the candle timestamps, inclusive; zero means open-ended
+	From time.Time
+	To   time.Time
+	// Source is auto, parquet or csv. Auto reads parquet partitions when the
+	// symbol has any and the CSV otherwise.
+	Source     string
+	OutputDir  string
+	ParquetDir string
+	CSV        CSVOptions
+	Parquet    ParquetOptions
+	// CSVInterval is the interval of <SYMBOL>_historical.csv; other intervals
+	// are read from the <SYMBOL>_<interval>.csv files written by resample
+	CSVInterval string
+}
+
+// Query reads the stored candles of a series within a time range. Only the
+// monthly parquet partitions overlapping the range are opened.
+func Query(ctx context.Context, opts QueryOptions) ([]HistoricalCandle, error) {
+	source, files, err := opts.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	var candles []HistoricalCandle
+	if source == QuerySourceCSV {
+		path := opts.csvPath()
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("no stored data for %s: %w", opts.Series.Symbol, err)
+		}
+		read, err := ReadCSV(path, opts.CSV)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		candles = read
+	}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		read, err := ReadParquet(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		candles = append(candles, read...)
+	}
+
+	// Keep only the requested range
+	result := candles[:0]
+	for _, candle := range normalizeCandles(candles) {
+		if !opts.From.IsZero() && candle.Timestamp.Before(opts.From) {
+			continue
+		}
+		if !opts.To.IsZero() && candle.Timestamp.After(opts.To) {
+			continue
+		}
+		result = append(result, candle)
+	}
+	return result, nil
+}
+
+// ParseQueryTime parses a date or RFC3339 time. Dates are midnight in loc,
+// or the last instant of the day when endOfDay is set. An empty value is the
+// zero time, leaving that end of a query open.
+func ParseQueryTime(value string, loc *time.Location, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	date, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q (use YYYY-MM-DD or RFC3339)", value)
+	}
+	if endOfDay {
+		return date.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return date, nil
+}
+
+// resolve picks the store a query reads and, for parquet, the partitions
+// overlapping its range
+func (opts QueryOptions) resolve() (string, []string, error) {
+	source := opts.Source
+	if source == "" {
+		source = QuerySourceAuto
+	}
+
+	switch source {
+	case QuerySourceAuto, QuerySourceParquet:
+		all, err := opts.Parquet.Layout.Files(opts.ParquetDir, opts.Series.Symbol, opts.Series.Interval)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(all) == 0 && source == QuerySourceParquet {
+			return "", nil, fmt.Errorf("no parquet files for %s in %s: %w", opts.Series.Symbol, opts.ParquetDir, fs.ErrNotExist)
+		}
+		if len(all) > 0 {
+			return QuerySourceParquet, opts.partitions(all), nil
+		}
+		return QuerySourceCSV, nil, nil
+	case QuerySourceCSV:
+		return QuerySourceCSV, nil, nil
+	}
+	return "", nil, fmt.Errorf("invalid query source %q (use auto, parquet or csv)", opts.Source)
+}
+
+// partitions keeps the parquet files whose month overlaps the query range
+func (opts QueryOptions) partitions(files []string) []string {
+	loc := opts.Parquet.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	var selected []string
+	for _, file := range files {
+		year, month, ok := partitionMonth(file)
+		if !ok {
+			// Unknown partitions are read rather than silently skipped
+			selected = append(selected, file)
+			continue
+		}
+		start := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+		end := start.AddDate(0, 1, 0)
+		if !opts.To.IsZero() && start.After(opts.To) {
+			continue
+		}
+		if !opts.From.IsZero() && !end.After(opts.From) {
+			continue
+		}
+		selected = append(selected, file)
+	}
+	return selected
+}
+
+// csvPath returns the CSV file holding the queried interval
+func (opts QueryOptions) csvPath() string {
+	interval := opts.Series.Interval
+	if interval == "" || interval == opts.CSVInterval {
+		return opts.CSV.Path(opts.OutputDir, opts.Series.Symbol)
+	}
+	return filepath.Join(opts.OutputDir, opts.Series.Symbol, opts.Series.Symbol+"_"+interval+opts.CSV.Extension())
+}
+
+// WriteCandles writes query results to w as an aligned table, CSV with a
+// header, a JSON array, JSON Lines or an Arrow IPC stream. Times are shown in opts.Location and
+// CSV uses the configured delimiter and columns.
+func WriteCandles(w io.Writer, format string, series Series, candles []HistoricalCandle, opts CSVOptions) error {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	switch format {
+	case "", FormatTable:
+		precision := pricePrecision(series.TickSize)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "TIMESTAMP\tOPEN\tHIGH\tLOW\tCLOSE\tVOLUME\tOI\t")
+		for _, c := range candles {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t\n",
+				c.Timestamp.In(loc).Format("2006-01-02 15:04:05"),
+				formatPrice(c.Open, precision), formatPrice(c.High, precision),
+				formatPrice(c.Low, precision), formatPrice(c.Close, precision),
+				c.Volume, c.OI)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(w, strconv.Itoa(len(candles))+" candles")
+		return err
+
+	case FormatCSV:
+		opts.Header = true
+		opts.Compression = "none"
+		if len(opts.Columns) == 0 {
+			opts.Columns = DefaultCSVColumns
+		}
+		if opts.Delimiter == 0 {
+			opts.Delimiter = ','
+		}
+		opts.Location = loc
+		return encodeCSV(w, series, candles, opts)
+
+	case FormatJSON:
+		points := make([]HistoricalDataPoint, len(candles))
+		for i, candle := range candles {
+			points[i] = newDataPoint(series, candle, loc)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(points)
+
+	case FormatJSONL:
+		return encodeJSONL(w, series, candles, loc)
+
+	case FormatArrow:
+		return encodeArrowStream(w, series, candles, loc, TimezoneName(loc.String()))
+	}
+	return fmt.Errorf("invalid format %q (use table, csv, json, jsonl or arrow)", format)
+}
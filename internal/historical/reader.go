@@ -0,0 +1,272 @@
+package historical
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/fsutil"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// CSVPath returns the CSV file holding a symbol's candles
+func CSVPath(outputDir, symbol string) string {
+	return historicalPath(outputDir, symbol, ".csv")
+}
+
+// historicalPath returns <dir>/<SYMBOL>/<SYMBOL>_historical<ext>, the
+// per-symbol layout shared by the single-file output formats
+func historicalPath(dir, symbol, ext string) string {
+	return filepath.Join(dir, symbol, fmt.Sprintf("%s_historical%s", symbol, ext))
+}
+
+// StoredSymbols lists the symbols that have a directory under dir
+func StoredSymbols(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	var symbols []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			symbols = append(symbols, entry.Name())
+		}
+	}
+	return symbols, nil
+}
+
+// CleanTempFiles removes stale temporary files from the given output
+// directories, logging rather than failing when a directory can't be cleaned
+func CleanTempFiles(dirs ...string) {
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if _, err := fsutil.CleanTempFiles(dir); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+}
+
+// ReadCSV reads candles from a CSV file written by WriteCSV. Compressed
+// files are recognized by their .gz or .zst suffix.
+func ReadCSV(path string, opts CSVOptions) ([]HistoricalCandle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file: %w", err)
+	}
+	defer file.Close()
+
+	r, err := decompressCSV(path, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress CSV file: %w", err)
+	}
+	defer r.Close()
+
+	return readCSVCandles(r, opts)
+}
+
+// readCSVCandles parses candles from CSV data, locating columns by header
+// name. Files written without a header are read with the configured columns.
+func readCSVCandles(r io.Reader, opts CSVOptions) ([]HistoricalCandle, error) {
+	reader := csv.NewReader(r)
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	}
+
+	line := 1
+	header := opts.Columns
+	if opts.Header || len(header) == 0 {
+		var err error
+		if header, err = reader.Read(); err != nil {
+			return nil, fmt.Errorf("failed to read CSV header: %w", err)
+		}
+		line++
+	}
+	columns := make(map[string]int)
+	for i, col := range header {
+		columns[strings.TrimSpace(col)] = i
+	}
+	// Unix seconds stand in for the timestamp when only those were written
+	if _, ok := columns["timestamp"]; !ok {
+		if i, ok := columns["unix"]; ok {
+			columns["timestamp"] = i
+		}
+	}
+	for _, col := range []string{"timestamp", "open", "high", "low", "close", "volume"} {
+		if _, ok := columns[col]; !ok {
+			return nil, fmt.Errorf("CSV is missing column %q", col)
+		}
+	}
+
+	var candles []HistoricalCandle
+	for ; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV record: %w", err)
+		}
+
+		candle, err := parseCSVCandle(record, columns)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
+
+func parseCSVCandle(record []string, columns map[string]int) (HistoricalCandle, error) {
+	var candle HistoricalCandle
+	var err error
+
+	if candle.Timestamp, err = parseTimestamp(record[columns["timestamp"]]); err != nil {
+		return candle, err
+	}
+	prices := []*float64{&candle.Open, &candle.High, &candle.Low, &candle.Close}
+	for i, col := range []string{"open", "high", "low", "close"} {
+		if *prices[i], err = strconv.ParseFloat(record[columns[col]], 64); err != nil {
+			return candle, fmt.Errorf("invalid %s: %w", col, err)
+		}
+	}
+	if candle.Volume, err = strconv.ParseInt(record[columns["volume"]], 10, 64); err != nil {
+		return candle, fmt.Errorf("invalid volume: %w", err)
+	}
+	if i, ok := columns["oi"]; ok {
+		if candle.OI, err = strconv.ParseInt(record[i], 10, 64); err != nil {
+			return candle, fmt.Errorf("invalid oi: %w", err)
+		}
+	}
+	return candle, nil
+}
+
+// parseTimestamp accepts Unix seconds or RFC 3339 timestamps
+func parseTimestamp(s string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return t, nil
+}
+
+// ReadParquet reads candles from a parquet file written by writeCandles
+func ReadParquet(path string) ([]HistoricalCandle, error) {
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open parquet file: %w", err)
+	}
+	defer fr.Close()
+
+	return readParquetCandles(fr)
+}
+
+// readParquetCandles reads the candle columns of a parquet file by name,
+// so files with extra or reordered columns can still be read
+func readParquetCandles(pf source.ParquetFile) ([]HistoricalCandle, error) {
+	pr, err := reader.NewParquetReader(pf, nil, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parquet footer: %w", err)
+	}
+	defer pr.ReadStop()
+
+	// Map external column names to the reader's internal paths
+	paths := make(map[string]string)
+	toTime := func(v int64) time.Time { return time.Unix(v, 0) }
+	for i, element := range pr.SchemaHandler.SchemaElements {
+		if element.GetNumChildren() == 0 {
+			name := strings.ToLower(pr.SchemaHandler.Infos[i].ExName)
+			paths[name] = pr.SchemaHandler.IndexMap[int32(i)]
+			if name == "timestamp" {
+				toTime = timestampDecoder(element)
+			}
+		}
+	}
+
+	rows := pr.GetNumRows()
+	readColumn := func(name string) ([]interface{}, error) {
+		path, ok := paths[name]
+		if !ok {
+			return nil, fmt.Errorf("parquet file has no %s column", name)
+		}
+		values, _, _, err := pr.ReadColumnByPath(path, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s column: %w", name, err)
+		}
+		if int64(len(values)) != rows {
+			return nil, fmt.Errorf("%s column has %d values, expected %d", name, len(values), rows)
+		}
+		return values, nil
+	}
+
+	columns := make(map[string][]interface{})
+	for _, name := range []string{"timestamp", "open", "high", "low", "close", "volume"} {
+		if columns[name], err = readColumn(name); err != nil {
+			return nil, err
+		}
+	}
+
+	// Files written before open interest was recorded have no oi column
+	oi, err := readColumn("oi")
+	if err != nil {
+		oi = nil
+	}
+
+	candles := make([]HistoricalCandle, rows)
+	for i := range candles {
+		if oi != nil {
+			candles[i].OI = oi[i].(int64)
+		}
+		candles[i].Timestamp = toTime(columns["timestamp"][i].(int64))
+		candles[i].Open = columns["open"][i].(float64)
+		candles[i].High = columns["high"][i].(float64)
+		candles[i].Low = columns["low"][i].(float64)
+		candles[i].Close = columns["close"][i].(float64)
+		candles[i].Volume = columns["volume"][i].(int64)
+	}
+	return candles, nil
+}
+
+// timestampDecoder returns the conversion for a timestamp column based on its
+// logical type. Files written before timestamps had a logical type hold
+// plain Unix seconds.
+func timestampDecoder(element *parquet.SchemaElement) func(int64) time.Time {
+	if logical := element.GetLogicalType(); logical != nil && logical.IsSetTIMESTAMP() {
+		unit := logical.GetTIMESTAMP().GetUnit()
+		switch {
+		case unit.IsSetMILLIS():
+			return time.UnixMilli
+		case unit.IsSetMICROS():
+			return time.UnixMicro
+		case unit.IsSetNANOS():
+			return func(v int64) time.Time { return time.Unix(0, v) }
+		}
+	}
+	if element.IsSetConvertedType() {
+		switch element.GetConvertedType() {
+		case parquet.ConvertedType_TIMESTAMP_MILLIS:
+			return time.UnixMilli
+		case parquet.ConvertedType_TIMESTAMP_MICROS:
+			return time.UnixMicro
+		}
+	}
+	return func(v int64) time.Time { return time.Unix(v, 0) }
+}
+
+// IsParquetFile reports whether a path names a parquet file
+func IsParquetFile(path string) bool {
+	return strings.HasSuffix(path, ".parquet")
+}
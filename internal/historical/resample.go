@@ -0,0 +1,63 @@
+package historical
+
+import (
+	"fmt"
+	"time"
+)
+
+// ResampleCandles aggregates candles into a coarser interval.
+// Input candles must be sorted by time and finer than the target interval.
+// Open interest is a level, so each candle takes the last source candle's.
+func ResampleCandles(candles []HistoricalCandle, interval string) ([]HistoricalCandle, error) {
+	d, err := IntervalDuration(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	var resampled []HistoricalCandle
+	var current *HistoricalCandle
+	var currentEnd time.Time
+
+	for _, candle := range candles {
+		start := CandleStart(candle.Timestamp, d)
+		if current != nil && start.Before(current.Timestamp) {
+			return nil, fmt.Errorf("candles are not sorted: %s comes after %s",
+				candle.Timestamp.Format(time.RFC3339), current.Timestamp.Format(time.RFC3339))
+		}
+
+		if current == nil || !candle.Timestamp.Before(currentEnd) {
+			if current != nil {
+				resampled = append(resampled, *current)
+			}
+			current = &HistoricalCandle{
+				Timestamp: start,
+				Open:      candle.Open,
+				High:      candle.High,
+				Low:       candle.Low,
+				Close:     candle.Close,
+				Volume:    candle.Volume,
+				OI:        candle.OI,
+			}
+			currentEnd = start.Add(d)
+			if d >= 24*time.Hour {
+				currentEnd = start.AddDate(0, 0, 1)
+			}
+			continue
+		}
+
+		if candle.High > current.High {
+			current.High = candle.High
+		}
+		if candle.Low < current.Low {
+			current.Low = candle.Low
+		}
+		current.Close = candle.Close
+		current.Volume += candle.Volume
+		current.OI = candle.OI
+	}
+
+	if current != nil {
+		resampled = append(resampled, *current)
+	}
+	return resampled, nil
+}
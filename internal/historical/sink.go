@@ -0,0 +1,318 @@
+package historical
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/storage"
+)
+
+// Sink stores downloaded candles. The downloader opens a sink once, writes
+// each instrument and interval in one or more batches, and closes it when done.
+type Sink interface {
+	// Open prepares the sink before the first write
+	Open(ctx context.Context) error
+	// Write stores a batch of candles for one series. Sinks that only
+	// implement Sink receive a long series in several batches in time order,
+	// so their Write must merge into what is stored, as the parquet and
+	// database sinks do. The csv, jsonl and arrow sinks instead replace the
+	// series' file with the batch (see ReplacesSeries); they implement
+	// SeriesSink so downloads hand them the whole series at once.
+	Write(ctx context.Context, series Series, candles []HistoricalCandle) error
+	// Close flushes pending data and releases resources
+	Close() error
+}
+
+// SeriesSink is implemented by sinks that need to know where a series
+// starts and ends, such as files written once per series. Sinks without it
+// receive every downloaded chunk in its own Write.
+type SeriesSink interface {
+	Sink
+	// OpenSeries starts writing a series; its chunks arrive in time order
+	OpenSeries(ctx context.Context, series Series) (SeriesWriter, error)
+}
+
+// SeriesWriter receives one series' candles chunk by chunk
+type SeriesWriter interface {
+	// Write stores the next chunk
+	Write(ctx context.Context, candles []HistoricalCandle) error
+	// Close completes the series
+	Close() error
+	// Abort gives up on the series, keeping what was stored before it if
+	// the sink can
+	Abort()
+}
+
+// OpenSeries starts writing a series to sink, passing each chunk to its
+// Write for sinks that do not implement SeriesSink
+func OpenSeries(ctx context.Context, sink Sink, series Series) (SeriesWriter, error) {
+	if ss, ok := sink.(SeriesSink); ok {
+		return ss.OpenSeries(ctx, series)
+	}
+	return ChunkedSeries(sink, series), nil
+}
+
+// chunkedSeries writes every chunk straight to a sink whose Write merges
+// with what is already stored, like the parquet and database sinks
+type chunkedSeries struct {
+	sink   Sink
+	series Series
+}
+
+func (c chunkedSeries) Write(ctx context.Context, candles []HistoricalCandle) error {
+	return c.sink.Write(ctx, c.series, candles)
+}
+
+func (c chunkedSeries) Close() error { return nil }
+
+func (c chunkedSeries) Abort() {}
+
+// ChunkedSeries returns a SeriesWriter that passes each chunk to sink.Write.
+// Sinks whose Write merges or upserts can implement OpenSeries with it.
+func ChunkedSeries(sink Sink, series Series) SeriesWriter {
+	return chunkedSeries{sink: sink, series: series}
+}
+
+// SinkFactory creates a sink from the configuration
+type SinkFactory func(cfg *config.Config) (Sink, error)
+
+var (
+	sinksMu       sync.RWMutex
+	sinkFactories = make(map[string]SinkFactory)
+)
+
+func init() {
+	RegisterSink("csv", NewCSVSink)
+	RegisterSink("parquet", NewParquetSink)
+	RegisterSink("jsonl", NewJSONLSink)
+	RegisterSink("arrow", NewArrowSink)
+}
+
+// RegisterSink makes a sink available under a name for the sinks config
+// setting. It panics if the name is already taken, like database/sql drivers.
+func RegisterSink(name string, factory SinkFactory) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+
+	if factory == nil {
+		panic("historical: RegisterSink factory is nil")
+	}
+	if _, dup := sinkFactories[name]; dup {
+		panic("historical: RegisterSink called twice for sink " + name)
+	}
+	sinkFactories[name] = factory
+}
+
+// SinkNames lists the registered sinks
+func SinkNames() []string {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+
+	names := make([]string, 0, len(sinkFactories))
+	for name := range sinkFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewSink creates the registered sink with the given name
+func NewSink(name string, cfg *config.Config) (Sink, error) {
+	sinksMu.RLock()
+	factory, ok := sinkFactories[name]
+	sinksMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q (available: %s)", name, strings.Join(SinkNames(), ", "))
+	}
+	return factory(cfg)
+}
+
+// SinksFromConfig lists the sinks selected by the config. Without an explicit
+// list candles go to CSV, and parquet_enabled adds the Parquet sink.
+func SinksFromConfig(cfg config.HistoricalConfig) []string {
+	names := append([]string(nil), cfg.Sinks...)
+	if len(names) == 0 {
+		names = []string{"csv"}
+	}
+	if cfg.ParquetEnabled && !slices.Contains(names, "parquet") {
+		names = append(names, "parquet")
+	}
+	return names
+}
+
+// replacingSinks are the sinks that rewrite a series' whole file on every
+// write instead of merging into what is stored
+var replacingSinks = map[string]bool{"csv": true, "jsonl": true, "arrow": true}
+
+// ReplacesSeries reports whether the named sink replaces a series' stored
+// candles with each write. Such sinks must be given the whole series.
+func ReplacesSeries(name string) bool {
+	return replacingSinks[name]
+}
+
+// NewSinkFromConfig creates every sink selected by the config, combined
+// into a single sink
+func NewSinkFromConfig(cfg *config.Config) (Sink, error) {
+	var sinks []Sink
+	for _, name := range SinksFromConfig(cfg.Historical) {
+		sink, err := NewSink(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return MultiSink(sinks...), nil
+}
+
+// multiSink writes every batch to several sinks
+type multiSink []Sink
+
+// MultiSink combines sinks into one. A failing sink does not stop the
+// others from receiving the batch; all errors are returned together.
+func MultiSink(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+// Open opens every sink, closing the ones already opened if one fails
+func (m multiSink) Open(ctx context.Context) error {
+	for i, sink := range m {
+		if err := sink.Open(ctx); err != nil {
+			for _, opened := range m[:i] {
+				opened.Close()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Write hands the batch to every sink
+func (m multiSink) Write(ctx context.Context, series Series, candles []HistoricalCandle) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Write(ctx, series, candles); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OpenSeries opens the series on every sink. A sink that fails to open or
+// write the series is dropped from it while the others carry on, and its
+// error is returned when the series is closed, so the series still fails.
+func (m multiSink) OpenSeries(ctx context.Context, series Series) (SeriesWriter, error) {
+	writers := make([]SeriesWriter, 0, len(m))
+	var errs []error
+	for _, sink := range m {
+		w, err := OpenSeries(ctx, sink, series)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		writers = append(writers, w)
+	}
+	if len(writers) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &multiSeries{writers: writers, errs: errs}, nil
+}
+
+// multiSeries writes a series to several sinks
+type multiSeries struct {
+	writers []SeriesWriter
+	// errs are the errors of the sinks dropped from the series
+	errs []error
+}
+
+// Write hands the chunk to every sink still taking the series. A sink that
+// fails is dropped and its error kept for Close; an error is only returned
+// here once all have failed.
+func (m *multiSeries) Write(ctx context.Context, candles []HistoricalCandle) error {
+	kept := m.writers[:0]
+	for _, w := range m.writers {
+		if err := w.Write(ctx, candles); err != nil {
+			w.Abort()
+			m.errs = append(m.errs, err)
+			continue
+		}
+		kept = append(kept, w)
+	}
+	m.writers = kept
+	if len(kept) == 0 {
+		return errors.Join(m.errs...)
+	}
+	return nil
+}
+
+// Close completes the series on the sinks still taking it and returns the
+// errors of every sink that failed it
+func (m *multiSeries) Close() error {
+	errs := m.errs
+	for _, w := range m.writers {
+		if err := w.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *multiSeries) Abort() {
+	for _, w := range m.writers {
+		w.Abort()
+	}
+}
+
+// Close closes every sink
+func (m multiSink) Close() error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fileSeries streams a series into a storage file through an encoder and
+// commits the file when the series is complete
+type fileSeries struct {
+	file     storage.File
+	location string
+	write    func(candles []HistoricalCandle) error
+	close    func() error
+	count    int
+}
+
+func (f *fileSeries) Write(ctx context.Context, candles []HistoricalCandle) error {
+	if err := f.write(candles); err != nil {
+		return err
+	}
+	f.count += len(candles)
+	return nil
+}
+
+func (f *fileSeries) Close() error {
+	if err := f.close(); err != nil {
+		f.file.Abort()
+		return err
+	}
+	if err := f.file.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Saved %d data points to %s", f.count, f.location)
+	return nil
+}
+
+func (f *fileSeries) Abort() {
+	f.file.Abort()
+}
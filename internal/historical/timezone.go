@@ -0,0 +1,36 @@
+package historical
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/instruments"
+)
+
+// LoadTimezone resolves the configured output timezone. IST and
+// Asia/Kolkata map to the fixed exchange offset so they work even
+// where the system has no timezone database.
+func LoadTimezone(name string) (*time.Location, error) {
+	switch name {
+	case "", "IST", "Asia/Kolkata":
+		return instruments.IST, nil
+	case "UTC":
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// TimezoneName returns the IANA name of the configured output timezone,
+// for formats that record it by name
+func TimezoneName(name string) string {
+	switch name {
+	case "", "IST":
+		return "Asia/Kolkata"
+	}
+	return name
+}
@@ -2,6 +2,13 @@ package historical
 
 import "time"
 
+// Sources recorded with stored candles
+const (
+	SourceKite    = "kite"    // downloaded from Kite's historical API
+	SourceConvert = "convert" // imported from previously stored files
+	SourceTicks   = "ticks"   // built from live ticks
+)
+
 // HistoricalCandle represents a single candlestick
 type HistoricalCandle struct {
 	Timestamp time.Time
@@ -10,19 +17,62 @@ type HistoricalCandle struct {
 	Low       float64
 	Close     float64
 	Volume    int64
+	OI        int64
+}
+
+// Series identifies the instrument and interval a set of candles belongs to
+type Series struct {
+	Symbol   string
+	Token    int64
+	Exchange string
+	Interval string
+	Source   string
+	// TickSize decides how many decimals prices are written with
+	TickSize float64
 }
 
-// HistoricalDataPoint represents a single historical data point for parquet
+// HistoricalDataPoint represents a single stored historical data point,
+// one candle together with the series it belongs to. Every output format
+// stores these columns under the json names.
 type HistoricalDataPoint struct {
-	Symbol    string  `parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Timestamp int64   `parquet:"name=timestamp, type=INT64, encoding=DELTA_BINARY_PACKED"`
-	Date      string  `parquet:"name=date, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Year      int32   `parquet:"name=year, type=INT32, encoding=PLAIN_DICTIONARY"` 
-	Month     int32   `parquet:"name=month, type=INT32, encoding=PLAIN_DICTIONARY"`
-	Day       int32   `parquet:"name=day, type=INT32, encoding=PLAIN_DICTIONARY"`
-	Open      float64 `parquet:"name=open, type=DOUBLE, encoding=PLAIN"`
-	High      float64 `parquet:"name=high, type=DOUBLE, encoding=PLAIN"`
-	Low       float64 `parquet:"name=low, type=DOUBLE, encoding=PLAIN"`
-	Close     float64 `parquet:"name=close, type=DOUBLE, encoding=PLAIN"`
-	Volume    int64   `parquet:"name=volume, type=INT64, encoding=DELTA_BINARY_PACKED"`
-}
\ No newline at end of file
+	Symbol          string    `json:"symbol"`
+	InstrumentToken int64     `json:"instrument_token"`
+	Exchange        string    `json:"exchange"`
+	Interval        string    `json:"interval"`
+	Timestamp       time.Time `json:"timestamp"`
+	Date            string    `json:"date"`
+	Year            int32     `json:"year"`
+	Month           int32     `json:"month"`
+	Day             int32     `json:"day"`
+	Open            float64   `json:"open"`
+	High            float64   `json:"high"`
+	Low             float64   `json:"low"`
+	Close           float64   `json:"close"`
+	Volume          int64     `json:"volume"`
+	OI              int64     `json:"oi"`
+	Source          string    `json:"source"`
+}
+
+// newDataPoint combines a candle with its series metadata. The timestamp
+// and calendar fields are expressed in the given timezone.
+func newDataPoint(series Series, candle HistoricalCandle, loc *time.Location) HistoricalDataPoint {
+	local := candle.Timestamp.In(loc)
+	return HistoricalDataPoint{
+		Symbol:          series.Symbol,
+		InstrumentToken: series.Token,
+		Exchange:        series.Exchange,
+		Interval:        series.Interval,
+		Timestamp:       local,
+		Date:            local.Format("2006-01-02"),
+		Year:            int32(local.Year()),
+		Month:           int32(local.Month()),
+		Day:             int32(local.Day()),
+		Open:            candle.Open,
+		High:            candle.High,
+		Low:             candle.Low,
+		Close:           candle.Close,
+		Volume:          candle.Volume,
+		OI:              candle.OI,
+		Source:          series.Source,
+	}
+}
@@ -0,0 +1,63 @@
+package historical
+
+import (
+	"fmt"
+	"time"
+)
+
+// VerifyIssue describes a problem found in stored candles
+type VerifyIssue struct {
+	Timestamp time.Time
+	Problem   string
+}
+
+// String formats the issue for reports
+func (vi VerifyIssue) String() string {
+	return fmt.Sprintf("%s: %s", vi.Timestamp.Format(time.RFC3339), vi.Problem)
+}
+
+// VerifyCandles checks candles for ordering, duplicates, OHLC consistency
+// and, for intraday intervals, missing candles within a trading day
+func VerifyCandles(candles []HistoricalCandle, interval string) ([]VerifyIssue, error) {
+	d, err := IntervalDuration(interval)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []VerifyIssue
+	report := func(c HistoricalCandle, format string, args ...interface{}) {
+		issues = append(issues, VerifyIssue{Timestamp: c.Timestamp, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	for i, c := range candles {
+		if c.Open <= 0 || c.High <= 0 || c.Low <= 0 || c.Close <= 0 {
+			report(c, "non-positive price (o=%g h=%g l=%g c=%g)", c.Open, c.High, c.Low, c.Close)
+		}
+		if c.High < c.Low || c.High < c.Open || c.High < c.Close || c.Low > c.Open || c.Low > c.Close {
+			report(c, "inconsistent OHLC (o=%g h=%g l=%g c=%g)", c.Open, c.High, c.Low, c.Close)
+		}
+		if c.Volume < 0 {
+			report(c, "negative volume %d", c.Volume)
+		}
+
+		if i == 0 {
+			continue
+		}
+		prev := candles[i-1]
+		switch {
+		case c.Timestamp.Equal(prev.Timestamp):
+			report(c, "duplicate timestamp")
+		case c.Timestamp.Before(prev.Timestamp):
+			report(c, "out of order, previous candle is at %s", prev.Timestamp.Format(time.RFC3339))
+		case d < 24*time.Hour && sameDay(prev.Timestamp, c.Timestamp) && c.Timestamp.Sub(prev.Timestamp) > d:
+			report(c, "%d missing candles since %s", int(c.Timestamp.Sub(prev.Timestamp)/d)-1, prev.Timestamp.Format(time.RFC3339))
+		}
+	}
+
+	return issues, nil
+}
+
+// sameDay reports whether two times fall on the same IST calendar day
+func sameDay(a, b time.Time) bool {
+	return CandleStart(a, 24*time.Hour).Equal(CandleStart(b, 24*time.Hour))
+}
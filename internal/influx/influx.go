@@ -0,0 +1,241 @@
+// Package influx writes candles in InfluxDB line protocol, the ingestion
+// format shared by InfluxDB and QuestDB, over TCP, UDP or HTTP.
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/historical"
+)
+
+// Defaults used when the config leaves the settings empty
+const (
+	DefaultMeasurement = "candles"
+	DefaultBatchSize   = 5000
+)
+
+// maxPendingBatches bounds the lines kept for another attempt after failed
+// sends, so an unreachable server cannot make the buffer grow without limit
+const maxPendingBatches = 10
+
+// DefaultTags are the series columns written as tags when none are configured
+var DefaultTags = []string{"symbol", "exchange", "interval"}
+
+// tagValues are the series columns that can be written as tags. Columns
+// that are not tags are written as fields, except empty strings.
+var tagValues = map[string]func(s historical.Series) string{
+	"symbol":           func(s historical.Series) string { return s.Symbol },
+	"exchange":         func(s historical.Series) string { return s.Exchange },
+	"interval":         func(s historical.Series) string { return s.Interval },
+	"instrument_token": func(s historical.Series) string { return strconv.FormatInt(s.Token, 10) },
+	"source":           func(s historical.Series) string { return s.Source },
+}
+
+func init() {
+	historical.RegisterSink("influx", NewSink)
+}
+
+// Sink writes candles as line protocol, sending them in batches of
+// BatchSize lines. A series' last partial batch is sent when it completes,
+// so a failed send fails that series.
+type Sink struct {
+	URL         string
+	Measurement string
+	Tags        []string
+	BatchSize   int
+	Token       string
+
+	endpoint  string // URL without the password, for logs
+	transport transport
+	buf       bytes.Buffer
+	lines     int
+}
+
+// NewSink creates the influx sink from the configuration
+func NewSink(cfg *config.Config) (historical.Sink, error) {
+	hc := cfg.Historical
+	if hc.InfluxURL == "" {
+		return nil, fmt.Errorf("influx sink needs influx_url, e.g. tcp://localhost:9009 or http://localhost:8086/api/v2/write?bucket=market")
+	}
+	u, err := url.Parse(hc.InfluxURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid influx_url %q: %w", hc.InfluxURL, err)
+	}
+
+	tags := hc.InfluxTags
+	if len(tags) == 0 {
+		tags = DefaultTags
+	}
+	for _, tag := range tags {
+		if _, ok := tagValues[tag]; !ok {
+			return nil, fmt.Errorf("invalid influx tag %q (use symbol, exchange, interval, instrument_token or source)", tag)
+		}
+	}
+
+	measurement := hc.InfluxMeasurement
+	if measurement == "" {
+		measurement = DefaultMeasurement
+	}
+	batchSize := hc.InfluxBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	return &Sink{
+		URL:         hc.InfluxURL,
+		Measurement: measurement,
+		Tags:        tags,
+		BatchSize:   batchSize,
+		Token:       hc.InfluxToken,
+		endpoint:    u.Redacted(),
+	}, nil
+}
+
+// Open connects to the line protocol endpoint
+func (s *Sink) Open(ctx context.Context) error {
+	t, err := newTransport(ctx, s.URL, s.Token)
+	if err != nil {
+		return err
+	}
+	s.transport = t
+	return nil
+}
+
+// Write appends a series' candles to the current batch, sending every
+// batch that fills up
+func (s *Sink) Write(ctx context.Context, series historical.Series, candles []historical.HistoricalCandle) error {
+	if s.transport == nil {
+		return fmt.Errorf("influx sink is not open")
+	}
+
+	prefix := s.seriesKey(series)
+	for _, candle := range candles {
+		s.appendLine(prefix, series, candle)
+		if s.lines >= s.BatchSize {
+			if err := s.flush(ctx); err != nil {
+				return fmt.Errorf("failed to send %s candles: %w", series.Symbol, err)
+			}
+		}
+	}
+	return nil
+}
+
+// OpenSeries sends a series chunk by chunk through the shared batch
+func (s *Sink) OpenSeries(ctx context.Context, series historical.Series) (historical.SeriesWriter, error) {
+	return &seriesWriter{sink: s, series: series, ctx: ctx}, nil
+}
+
+// seriesWriter adds a series to the shared batch and sends what is left of
+// it when the series is complete
+type seriesWriter struct {
+	sink   *Sink
+	series historical.Series
+	ctx    context.Context
+}
+
+func (w *seriesWriter) Write(ctx context.Context, candles []historical.HistoricalCandle) error {
+	w.ctx = ctx
+	return w.sink.Write(ctx, w.series, candles)
+}
+
+func (w *seriesWriter) Close() error {
+	if err := w.sink.flush(w.ctx); err != nil {
+		return fmt.Errorf("failed to send %s candles: %w", w.series.Symbol, err)
+	}
+	return nil
+}
+
+// Abort leaves the lines already buffered to be sent with the next batch
+func (w *seriesWriter) Abort() {}
+
+// Close sends the last partial batch and closes the connection
+func (s *Sink) Close() error {
+	if s.transport == nil {
+		return nil
+	}
+	err := s.flush(context.Background())
+	if cerr := s.transport.Close(); err == nil {
+		err = cerr
+	}
+	s.transport = nil
+	return err
+}
+
+// flush sends the buffered lines. When the send fails they are kept for
+// the next flush, up to maxPendingBatches batches.
+func (s *Sink) flush(ctx context.Context) error {
+	if s.lines == 0 {
+		return nil
+	}
+	if err := s.transport.Send(ctx, s.buf.Bytes()); err != nil {
+		if s.lines >= maxPendingBatches*s.BatchSize {
+			log.Printf("Dropping %d unsent data points for %s", s.lines, s.endpoint)
+			s.buf.Reset()
+			s.lines = 0
+		}
+		return err
+	}
+	log.Printf("Sent %d data points to %s", s.lines, s.endpoint)
+	s.buf.Reset()
+	s.lines = 0
+	return nil
+}
+
+// seriesKey builds the measurement and tag set shared by a series' lines
+func (s *Sink) seriesKey(series historical.Series) string {
+	var b strings.Builder
+	b.WriteString(escape(s.Measurement, ", "))
+	for _, tag := range s.Tags {
+		// Line protocol has no empty tag values; leave the tag out instead
+		if value := tagValues[tag](series); value != "" {
+			b.WriteString("," + tag + "=" + escape(value, ", ="))
+		}
+	}
+	return b.String()
+}
+
+// appendLine writes one candle as a line: key, fields and a nanosecond timestamp
+func (s *Sink) appendLine(prefix string, series historical.Series, c historical.HistoricalCandle) {
+	b := &s.buf
+	b.WriteString(prefix)
+	b.WriteString(" open=")
+	b.WriteString(strconv.FormatFloat(c.Open, 'f', -1, 64))
+	b.WriteString(",high=")
+	b.WriteString(strconv.FormatFloat(c.High, 'f', -1, 64))
+	b.WriteString(",low=")
+	b.WriteString(strconv.FormatFloat(c.Low, 'f', -1, 64))
+	b.WriteString(",close=")
+	b.WriteString(strconv.FormatFloat(c.Close, 'f', -1, 64))
+	b.WriteString(",volume=" + strconv.FormatInt(c.Volume, 10) + "i")
+	b.WriteString(",oi=" + strconv.FormatInt(c.OI, 10) + "i")
+	if !slices.Contains(s.Tags, "instrument_token") && series.Token != 0 {
+		b.WriteString(",instrument_token=" + strconv.FormatInt(series.Token, 10) + "i")
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(c.Timestamp.UnixNano(), 10))
+	b.WriteByte('\n')
+	s.lines++
+}
+
+// escape backslash-escapes the given special characters
+func escape(value, special string) string {
+	if !strings.ContainsAny(value, special) {
+		return value
+	}
+	var b strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,309 @@
+package influx
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/historical"
+)
+
+// lineServer is a local line protocol server that reports every line it
+// reads with the number of the connection it came on
+type lineServer struct {
+	ln    net.Listener
+	lines chan serverLine
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+type serverLine struct {
+	conn int
+	text string
+}
+
+func startServer(t *testing.T, addr string) *lineServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &lineServer{ln: ln, lines: make(chan serverLine, 100)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			n := len(s.conns)
+			s.mu.Unlock()
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					s.lines <- serverLine{n, scanner.Text()}
+				}
+			}()
+		}
+	}()
+	t.Cleanup(s.stop)
+	return s
+}
+
+// closeConns drops every accepted connection, as a restarting server would
+func (s *lineServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *lineServer) stop() {
+	s.ln.Close()
+	s.closeConns()
+}
+
+// read waits for n lines
+func (s *lineServer) read(t *testing.T, n int) []serverLine {
+	t.Helper()
+	var lines []serverLine
+	for len(lines) < n {
+		select {
+		case line := <-s.lines:
+			lines = append(lines, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d lines, want %d: %v", len(lines), n, lines)
+		}
+	}
+	return lines
+}
+
+// quiet checks that no more lines arrive
+func (s *lineServer) quiet(t *testing.T) {
+	t.Helper()
+	select {
+	case line := <-s.lines:
+		t.Fatalf("unexpected line %v", line)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func newTestSink(t *testing.T, addr string, batchSize int) *Sink {
+	t.Helper()
+	cfg := &config.Config{Historical: config.HistoricalConfig{InfluxURL: "tcp://" + addr, InfluxBatchSize: batchSize}}
+	sink, err := NewSink(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	return sink.(*Sink)
+}
+
+// waitClosed waits until the sink's connection has seen the server close it
+func waitClosed(t *testing.T, sink *Sink) {
+	t.Helper()
+	transport := sink.transport.(*tcpTransport)
+	for deadline := time.Now().Add(5 * time.Second); transport.alive(); {
+		if time.Now().After(deadline) {
+			t.Fatal("connection still open")
+		}
+	}
+}
+
+var testSeries = historical.Series{Symbol: "RELIANCE", Exchange: "NSE", Interval: "minute"}
+
+// candles returns n minute candles whose close is their index
+func candles(from, n int) []historical.HistoricalCandle {
+	start := time.Date(2024, 6, 14, 3, 45, 0, 0, time.UTC)
+	var out []historical.HistoricalCandle
+	for i := from; i < from+n; i++ {
+		out = append(out, historical.HistoricalCandle{Timestamp: start.Add(time.Duration(i) * time.Minute), Close: float64(i)})
+	}
+	return out
+}
+
+// closes returns the close field of each line
+func closes(lines []serverLine) []string {
+	var out []string
+	for _, line := range lines {
+		field := line.text[strings.Index(line.text, ",close=")+len(",close="):]
+		out = append(out, field[:strings.IndexByte(field, ',')])
+	}
+	return out
+}
+
+func TestTCPBatches(t *testing.T) {
+	server := startServer(t, "127.0.0.1:0")
+	sink := newTestSink(t, server.ln.Addr().String(), 2)
+	ctx := context.Background()
+
+	w, err := sink.OpenSeries(ctx, testSeries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(ctx, candles(0, 5)); err != nil {
+		t.Fatal(err)
+	}
+	// Two full batches are sent, the fifth line waits for the series to end
+	lines := server.read(t, 4)
+	server.quiet(t)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lines = append(lines, server.read(t, 1)...)
+
+	if got, want := strings.Join(closes(lines), " "), "0 1 2 3 4"; got != want {
+		t.Errorf("got closes %s, want %s", got, want)
+	}
+	want := "candles,symbol=RELIANCE,exchange=NSE,interval=minute open=0,high=0,low=0,close=0,volume=0i,oi=0i 1718336700000000000"
+	if lines[0].text != want {
+		t.Errorf("got line %q, want %q", lines[0].text, want)
+	}
+}
+
+func TestTCPReconnect(t *testing.T) {
+	server := startServer(t, "127.0.0.1:0")
+	sink := newTestSink(t, server.ln.Addr().String(), 2)
+	ctx := context.Background()
+
+	if err := sink.Write(ctx, testSeries, candles(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	first := server.read(t, 2)
+
+	// The server restarts
+	server.closeConns()
+	waitClosed(t, sink)
+
+	if err := sink.Write(ctx, testSeries, candles(2, 2)); err != nil {
+		t.Fatal(err)
+	}
+	second := server.read(t, 2)
+	if first[0].conn != 1 || second[0].conn != 2 {
+		t.Errorf("lines came on connections %d and %d, want 1 and 2", first[0].conn, second[0].conn)
+	}
+	if got, want := strings.Join(closes(append(first, second...)), " "), "0 1 2 3"; got != want {
+		t.Errorf("got closes %s, want %s", got, want)
+	}
+}
+
+// partialConn accepts the first n bytes of a write and then fails, like a
+// connection dropped in the middle of a batch
+type partialConn struct {
+	net.Conn
+	n        int
+	deadline time.Time
+}
+
+func (c *partialConn) Write(p []byte) (int, error) {
+	return min(c.n, len(p)), errors.New("connection reset")
+}
+
+func (c *partialConn) Read(p []byte) (int, error)         { return 0, os.ErrDeadlineExceeded }
+func (c *partialConn) SetReadDeadline(time.Time) error    { return nil }
+func (c *partialConn) SetWriteDeadline(t time.Time) error { c.deadline = t; return nil }
+func (c *partialConn) Close() error                       { return nil }
+
+func TestTCPResendsCutLine(t *testing.T) {
+	server := startServer(t, "127.0.0.1:0")
+	payload := "a v=1i 1\nb v=2i 2\nc v=3i 3\n"
+	// The connection fails in the middle of the second line
+	conn := &partialConn{n: strings.Index(payload, "b") + 3}
+	transport := &tcpTransport{addr: server.ln.Addr().String(), conn: conn}
+	defer transport.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := transport.Send(ctx, []byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := ctx.Deadline(); !conn.deadline.Equal(d) {
+		t.Errorf("write deadline %s, want the context's %s", conn.deadline, d)
+	}
+
+	lines := server.read(t, 2)
+	server.quiet(t)
+	if lines[0].text != "b v=2i 2" || lines[1].text != "c v=3i 3" {
+		t.Errorf("resent %v, want the lines from b on", lines)
+	}
+}
+
+func TestTCPFailedSend(t *testing.T) {
+	server := startServer(t, "127.0.0.1:0")
+	addr := server.ln.Addr().String()
+	sink := newTestSink(t, addr, 10)
+	ctx := context.Background()
+
+	// The server goes away: the series fails and its lines are kept
+	server.stop()
+	waitClosed(t, sink)
+	w, err := sink.OpenSeries(ctx, testSeries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(ctx, candles(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err == nil || !strings.Contains(err.Error(), "failed to send RELIANCE candles") {
+		t.Fatalf("series closed with %v, want a send error", err)
+	}
+	if sink.lines != 2 {
+		t.Fatalf("kept %d lines, want 2", sink.lines)
+	}
+
+	// Once it is back, the next series delivers them too
+	server = startServer(t, addr)
+	w, err = sink.OpenSeries(ctx, testSeries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(ctx, candles(2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(closes(server.read(t, 3)), " "), "0 1 2"; got != want {
+		t.Errorf("got closes %s, want %s", got, want)
+	}
+	if sink.lines != 0 {
+		t.Errorf("kept %d lines after sending", sink.lines)
+	}
+}
+
+func TestFailedSendsDropOldLines(t *testing.T) {
+	server := startServer(t, "127.0.0.1:0")
+	sink := newTestSink(t, server.ln.Addr().String(), 2)
+	server.stop()
+	waitClosed(t, sink)
+
+	// Once a batch is full, every line tries to send the buffer; the lines
+	// are kept until they reach maxPendingBatches batches
+	limit := maxPendingBatches * sink.BatchSize
+	for i := 0; i < limit; i++ {
+		err := sink.Write(context.Background(), testSeries, candles(i, 1))
+		if (err == nil) != (i+1 < sink.BatchSize) {
+			t.Fatalf("write %d returned %v", i, err)
+		}
+		want := i + 1
+		if want == limit {
+			want = 0
+		}
+		if sink.lines != want {
+			t.Fatalf("kept %d lines after %d writes, want %d", sink.lines, i+1, want)
+		}
+	}
+}
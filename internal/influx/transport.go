@@ -0,0 +1,205 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// maxDatagram keeps UDP packets within a typical MTU so they are not fragmented
+const maxDatagram = 1400
+
+// tcpWriteTimeout bounds a TCP write, so a server that stops reading fails
+// the batch instead of blocking the download
+const tcpWriteTimeout = 30 * time.Second
+
+// transport delivers batches of line protocol
+type transport interface {
+	Send(ctx context.Context, payload []byte) error
+	Close() error
+}
+
+// newTransport picks the transport from the URL scheme
+func newTransport(ctx context.Context, rawURL, token string) (transport, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid influx_url %q: %w", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		t := &tcpTransport{addr: u.Host}
+		if err := t.dial(ctx); err != nil {
+			return nil, err
+		}
+		return t, nil
+	case "udp":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "udp", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", u.Host, err)
+		}
+		return &udpTransport{conn: conn}, nil
+	case "http", "https":
+		return &httpTransport{url: u, token: token, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unsupported influx_url scheme %q (use tcp, udp, http or https)", u.Scheme)
+}
+
+// tcpTransport streams lines over a TCP connection, as QuestDB's ILP port expects
+type tcpTransport struct {
+	addr string
+	conn net.Conn
+}
+
+func (t *tcpTransport) dial(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", t.addr, err)
+	}
+	t.conn = conn
+	return nil
+}
+
+// Send writes the batch, reconnecting once if the connection was dropped.
+// A line cut off by the failure is sent again from its start on the new
+// connection, without the complete lines written before it.
+func (t *tcpTransport) Send(ctx context.Context, payload []byte) error {
+	if t.conn != nil && !t.alive() {
+		t.drop()
+	}
+	if t.conn != nil {
+		n, err := t.write(ctx, payload)
+		if err == nil {
+			return nil
+		}
+		payload = payload[bytes.LastIndexByte(payload[:n], '\n')+1:]
+		t.drop()
+	}
+	if err := t.dial(ctx); err != nil {
+		return err
+	}
+	if _, err := t.write(ctx, payload); err != nil {
+		t.drop()
+		return fmt.Errorf("failed to write to %s: %w", t.addr, err)
+	}
+	return nil
+}
+
+// write writes to the connection, giving up at ctx's deadline or after
+// tcpWriteTimeout when the server stops reading
+func (t *tcpTransport) write(ctx context.Context, payload []byte) (int, error) {
+	deadline := time.Now().Add(tcpWriteTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := t.conn.SetWriteDeadline(deadline); err != nil {
+		return 0, err
+	}
+	return t.conn.Write(payload)
+}
+
+// alive reports whether the server has not closed the connection. Line
+// protocol servers never reply, but close the connection on bad input or
+// when they restart, and writes to it would then be lost without an error.
+func (t *tcpTransport) alive() bool {
+	// A deadline already passed would fail the read without trying it
+	if err := t.conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return false
+	}
+	var b [1]byte
+	n, err := t.conn.Read(b[:])
+	return n > 0 || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// drop closes a connection that failed
+func (t *tcpTransport) drop() {
+	t.conn.Close()
+	t.conn = nil
+}
+
+func (t *tcpTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	return t.conn.Close()
+}
+
+// udpTransport sends lines as datagrams, splitting batches on line boundaries
+type udpTransport struct {
+	conn net.Conn
+}
+
+func (t *udpTransport) Send(ctx context.Context, payload []byte) error {
+	for len(payload) > 0 {
+		end := len(payload)
+		if end > maxDatagram {
+			// Cut after the last complete line that fits; a longer line goes alone
+			end = bytes.LastIndexByte(payload[:maxDatagram], '\n') + 1
+			if end == 0 {
+				end = bytes.IndexByte(payload, '\n') + 1
+				if end == 0 {
+					end = len(payload)
+				}
+			}
+		}
+		if _, err := t.conn.Write(payload[:end]); err != nil {
+			return fmt.Errorf("failed to send datagram: %w", err)
+		}
+		payload = payload[end:]
+	}
+	return nil
+}
+
+func (t *udpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// httpTransport posts batches to a write endpoint such as InfluxDB's
+// /api/v2/write or QuestDB's /write. User info in the URL becomes basic auth.
+type httpTransport struct {
+	url    *url.URL
+	token  string
+	client *http.Client
+}
+
+func (t *httpTransport) Send(ctx context.Context, payload []byte) error {
+	endpoint := *t.url
+	endpoint.User = nil
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if t.token != "" {
+		req.Header.Set("Authorization", "Token "+t.token)
+	} else if user := t.url.User; user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to %s: %w", endpoint.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("write to %s failed: %s: %s", endpoint.Host, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func (t *httpTransport) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,530 @@
+package instruments
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Filter is a compiled instrument selection expression, e.g.
+//
+//	segment == "NFO-OPT" && name == "BANKNIFTY" && expiry <= "2026-11-30" && strike between 44000 and 48000
+//
+// Expressions combine comparisons on instrument fields with &&, || and !,
+// grouped with parentheses. Supported comparisons are ==, !=, <, <=, >, >=,
+// "between <low> and <high>" (inclusive), "in (<value>, ...)" and =~ for
+// regular expression matches on text fields. Expiry is compared as a date
+// written "YYYY-MM-DD" or the keyword today; instruments without an expiry,
+// such as equities, match no expiry comparison.
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// fieldKind is the value type of a filterable instrument field
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	numberField
+	dateField
+)
+
+func (k fieldKind) String() string {
+	switch k {
+	case numberField:
+		return "number"
+	case dateField:
+		return "date"
+	}
+	return "text"
+}
+
+// filterField describes an instrument field usable in filter expressions
+type filterField struct {
+	kind fieldKind
+	get  func(Instrument) interface{}
+}
+
+// filterFields maps expression field names, which follow the instruments CSV columns
+var filterFields = map[string]filterField{
+	"instrument_token": {numberField, func(i Instrument) interface{} { return float64(i.InstrumentToken) }},
+	"exchange_token":   {numberField, func(i Instrument) interface{} { return float64(i.ExchangeToken) }},
+	"tradingsymbol":    {textField, func(i Instrument) interface{} { return i.TradingSymbol }},
+	"name":             {textField, func(i Instrument) interface{} { return i.Name }},
+	"last_price":       {numberField, func(i Instrument) interface{} { return i.LastPrice }},
+	"tick_size":        {numberField, func(i Instrument) interface{} { return i.TickSize }},
+	"expiry":           {dateField, func(i Instrument) interface{} { return i.Expiry }},
+	"instrument_type":  {textField, func(i Instrument) interface{} { return i.InstrumentType }},
+	"segment":          {textField, func(i Instrument) interface{} { return i.Segment }},
+	"exchange":         {textField, func(i Instrument) interface{} { return i.Exchange }},
+	"strike":           {numberField, func(i Instrument) interface{} { return i.StrikePrice }},
+	"lot_size":         {numberField, func(i Instrument) interface{} { return float64(i.LotSize) }},
+	"underlying":       {textField, func(i Instrument) interface{} { return i.Underlying }},
+	"underlying_token": {numberField, func(i Instrument) interface{} { return float64(i.UnderlyingToken) }},
+}
+
+// filterFieldAliases are shorter names accepted for common fields
+var filterFieldAliases = map[string]string{
+	"symbol": "tradingsymbol",
+	"type":   "instrument_type",
+	"token":  "instrument_token",
+}
+
+// ParseFilter compiles a filter expression
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && !p.done() {
+		err = p.errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Match reports whether an instrument satisfies the filter
+func (f *Filter) Match(instrument Instrument) bool {
+	return f.root.match(instrument)
+}
+
+// String returns the source expression
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Select returns the loaded instruments matching a filter
+func (im *InstrumentManager) Select(filter *Filter) []Instrument {
+	var matched []Instrument
+	for _, instrument := range im.list {
+		if filter.Match(instrument) {
+			matched = append(matched, instrument)
+		}
+	}
+	return matched
+}
+
+// SelectExpr compiles a filter expression and returns the matching instruments
+func (im *InstrumentManager) SelectExpr(expr string) ([]Instrument, error) {
+	filter, err := ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	return im.Select(filter), nil
+}
+
+// filterNode is a node of the compiled expression tree
+type filterNode interface {
+	match(Instrument) bool
+}
+
+type andNode struct{ left, right filterNode }
+
+func (n andNode) match(i Instrument) bool { return n.left.match(i) && n.right.match(i) }
+
+type orNode struct{ left, right filterNode }
+
+func (n orNode) match(i Instrument) bool { return n.left.match(i) || n.right.match(i) }
+
+type notNode struct{ inner filterNode }
+
+func (n notNode) match(i Instrument) bool { return !n.inner.match(i) }
+
+// compareNode compares a field against a literal
+type compareNode struct {
+	field filterField
+	op    string
+	value interface{}
+}
+
+func (n compareNode) match(i Instrument) bool {
+	v := n.field.get(i)
+	if isMissing(v) {
+		return false
+	}
+	c := compareValues(v, n.value)
+	switch n.op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// betweenNode matches fields within an inclusive range
+type betweenNode struct {
+	field     filterField
+	low, high interface{}
+}
+
+func (n betweenNode) match(i Instrument) bool {
+	v := n.field.get(i)
+	if isMissing(v) {
+		return false
+	}
+	return compareValues(v, n.low) >= 0 && compareValues(v, n.high) <= 0
+}
+
+// inNode matches fields equal to any value of a list
+type inNode struct {
+	field  filterField
+	values []interface{}
+}
+
+func (n inNode) match(i Instrument) bool {
+	v := n.field.get(i)
+	if isMissing(v) {
+		return false
+	}
+	for _, value := range n.values {
+		if compareValues(v, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// regexNode matches text fields against a regular expression
+type regexNode struct {
+	field filterField
+	re    *regexp.Regexp
+}
+
+func (n regexNode) match(i Instrument) bool {
+	return n.re.MatchString(n.field.get(i).(string))
+}
+
+// isMissing reports whether a field has no value, like the empty expiry of
+// an equity, which no comparison should match
+func isMissing(v interface{}) bool {
+	t, ok := v.(time.Time)
+	return ok && t.IsZero()
+}
+
+// compareValues orders two values of the same field kind
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	return 0
+}
+
+// comparisonOperators are the operators handled by compareNode
+var comparisonOperators = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// filterToken is a lexical token of a filter expression
+type filterToken struct {
+	kind string // "ident", "string", "number" or "op"
+	text string
+	pos  int
+}
+
+// filterOperators lists operators, longest first so prefixes don't shadow them
+var filterOperators = []string{"==", "!=", "<=", ">=", "=~", "&&", "||", "<", ">", "!", "(", ")", ","}
+
+// lexFilter splits an expression into tokens
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for pos := 0; pos < len(expr); {
+		ch := rune(expr[pos])
+		switch {
+		case unicode.IsSpace(ch):
+			pos++
+
+		case ch == '"':
+			end := pos + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at offset %d", pos)
+			}
+			text, err := strconv.Unquote(expr[pos : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d: %w", pos, err)
+			}
+			tokens = append(tokens, filterToken{kind: "string", text: text, pos: pos})
+			pos = end + 1
+
+		case unicode.IsDigit(ch) || ch == '-' || ch == '.':
+			end := pos + 1
+			for end < len(expr) && (unicode.IsDigit(rune(expr[end])) || expr[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: "number", text: expr[pos:end], pos: pos})
+			pos = end
+
+		case unicode.IsLetter(ch) || ch == '_':
+			end := pos + 1
+			for end < len(expr) && (unicode.IsLetter(rune(expr[end])) || unicode.IsDigit(rune(expr[end])) || expr[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: "ident", text: expr[pos:end], pos: pos})
+			pos = end
+
+		default:
+			matched := false
+			for _, op := range filterOperators {
+				if strings.HasPrefix(expr[pos:], op) {
+					tokens = append(tokens, filterToken{kind: "op", text: op, pos: pos})
+					pos += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", ch, pos)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// filterParser is a recursive descent parser over filter tokens
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.done() {
+		return filterToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+// accept consumes the next token if it is the given operator or keyword
+func (p *filterParser) accept(text string) bool {
+	tok := p.peek()
+	if (tok.kind == "op" || tok.kind == "ident") && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %q", text)
+	}
+	return nil
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if p.done() {
+		return fmt.Errorf("%s at end of expression", msg)
+	}
+	return fmt.Errorf("%s at offset %d", msg, p.peek().pos)
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.accept("!") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	}
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	tok := p.next()
+	if tok.kind != "ident" {
+		p.pos--
+		return nil, p.errorf("expected field name")
+	}
+	name := tok.text
+	if alias, ok := filterFieldAliases[name]; ok {
+		name = alias
+	}
+	field, ok := filterFields[name]
+	if !ok {
+		p.pos--
+		return nil, p.errorf("unknown field %q", tok.text)
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == "ident" && op.text == "between":
+		low, err := p.parseLiteral(field)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("and"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseLiteral(field)
+		if err != nil {
+			return nil, err
+		}
+		return betweenNode{field: field, low: low, high: high}, nil
+
+	case op.kind == "ident" && op.text == "in":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		for {
+			value, err := p.parseLiteral(field)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inNode{field: field, values: values}, nil
+
+	case op.kind == "op" && op.text == "=~":
+		if field.kind != textField {
+			p.pos--
+			return nil, p.errorf("=~ needs a text field, %s is a %s", tok.text, field.kind)
+		}
+		pattern := p.next()
+		if pattern.kind != "string" {
+			p.pos--
+			return nil, p.errorf("expected quoted regular expression")
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression at offset %d: %w", pattern.pos, err)
+		}
+		return regexNode{field: field, re: re}, nil
+
+	case op.kind == "op" && comparisonOperators[op.text]:
+		value, err := p.parseLiteral(field)
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{field: field, op: op.text, value: value}, nil
+	}
+
+	p.pos--
+	return nil, p.errorf("expected comparison operator after %s", tok.text)
+}
+
+// parseLiteral reads a literal and converts it to the field's kind
+func (p *filterParser) parseLiteral(field filterField) (interface{}, error) {
+	tok := p.next()
+	switch field.kind {
+	case textField:
+		if tok.kind == "string" {
+			return tok.text, nil
+		}
+
+	case numberField:
+		if tok.kind == "number" {
+			value, err := strconv.ParseFloat(tok.text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d", tok.text, tok.pos)
+			}
+			return value, nil
+		}
+
+	case dateField:
+		if tok.kind == "ident" && tok.text == "today" {
+			now := time.Now().In(IST)
+			return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, IST), nil
+		}
+		if tok.kind == "string" {
+			value, err := time.ParseInLocation("2006-01-02", tok.text, IST)
+			if err != nil {
+				return nil, fmt.Errorf("invalid date %q at offset %d, expected YYYY-MM-DD", tok.text, tok.pos)
+			}
+			return value, nil
+		}
+	}
+
+	p.pos--
+	return nil, p.errorf("expected %s value", field.kind)
+}
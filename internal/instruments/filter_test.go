@@ -0,0 +1,83 @@
+package instruments
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilter(t *testing.T) {
+	expiry := time.Date(2026, 11, 26, 0, 0, 0, 0, IST)
+	equity := Instrument{
+		InstrumentToken: 738561, TradingSymbol: "RELIANCE", Name: "RELIANCE INDUSTRIES",
+		InstrumentType: "EQ", Segment: "NSE", Exchange: "NSE", LotSize: 1,
+	}
+	option := Instrument{
+		InstrumentToken: 12345, TradingSymbol: "BANKNIFTY26NOV46000CE", Name: "BANKNIFTY",
+		Expiry: expiry, InstrumentType: "CE", Segment: "NFO-OPT", Exchange: "NFO",
+		StrikePrice: 46000, LotSize: 15, Underlying: "NIFTY BANK",
+	}
+
+	tests := []struct {
+		expr       string
+		equity     bool
+		option     bool
+		wantErrMsg string
+	}{
+		{expr: `segment == "NFO-OPT"`, option: true},
+		{expr: `exchange != "NFO"`, equity: true},
+		{expr: `symbol =~ "^BANK"`, option: true},
+		{expr: `type in ("CE", "PE")`, option: true},
+		{expr: `strike between 44000 and 48000`, option: true},
+		{expr: `strike between 46500 and 48000`},
+		{expr: `lot_size >= 1`, equity: true, option: true},
+		{expr: `token < 100000`, option: true},
+		{expr: `!(segment == "NSE")`, option: true},
+		{expr: `name == "BANKNIFTY" && strike > 45000 || symbol == "RELIANCE"`, equity: true, option: true},
+		{expr: `segment == "NSE" && (strike == 0 || lot_size > 10)`, equity: true},
+
+		// Instruments without an expiry match no expiry comparison
+		{expr: `expiry <= "2026-11-30"`, option: true},
+		{expr: `expiry > "2026-11-01"`, option: true},
+		{expr: `expiry == "2026-11-26"`, option: true},
+		{expr: `expiry != "2026-12-31"`, option: true},
+		{expr: `expiry between "2026-11-01" and "2026-11-30"`, option: true},
+		{expr: `expiry in ("2026-11-26")`, option: true},
+		{expr: `expiry < "2026-11-26"`},
+		{expr: `!(expiry <= "2026-11-30")`, equity: true},
+
+		{expr: ``, wantErrMsg: "expected field name"},
+		{expr: `segment`, wantErrMsg: "expected comparison operator"},
+		{expr: `sector == "IT"`, wantErrMsg: `unknown field "sector"`},
+		{expr: `strike == "46000"`, wantErrMsg: "expected number value"},
+		{expr: `expiry <= "30-11-2026"`, wantErrMsg: "invalid date"},
+		{expr: `strike =~ "46"`, wantErrMsg: "=~ needs a text field"},
+		{expr: `symbol =~ "("`, wantErrMsg: "invalid regular expression"},
+		{expr: `segment == "NSE" &&`, wantErrMsg: "at end of expression"},
+		{expr: `(segment == "NSE"`, wantErrMsg: `expected ")"`},
+		{expr: `segment == "NSE")`, wantErrMsg: `unexpected ")"`},
+		{expr: `segment == "NSE`, wantErrMsg: "unterminated string"},
+		{expr: `segment == 'NSE'`, wantErrMsg: "unexpected character"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			filter, err := ParseFilter(tt.expr)
+			if tt.wantErrMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrMsg) {
+					t.Fatalf("ParseFilter(%q) error = %v, want %q", tt.expr, err, tt.wantErrMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFilter(%q): %v", tt.expr, err)
+			}
+			if got := filter.Match(equity); got != tt.equity {
+				t.Errorf("Match(equity) = %v, want %v", got, tt.equity)
+			}
+			if got := filter.Match(option); got != tt.option {
+				t.Errorf("Match(option) = %v, want %v", got, tt.option)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package instruments
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -8,82 +9,226 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/fsutil"
 )
 
+// indexUnderlyings maps the names used by index derivatives to the
+// tradingsymbol of the index they settle against
+var indexUnderlyings = map[string]string{
+	"NIFTY":      "NIFTY 50",
+	"BANKNIFTY":  "NIFTY BANK",
+	"FINNIFTY":   "NIFTY FIN SERVICE",
+	"MIDCPNIFTY": "NIFTY MID SELECT",
+	"NIFTYNXT50": "NIFTY NEXT 50",
+	"SENSEX":     "SENSEX",
+	"BANKEX":     "BANKEX",
+	"SENSEX50":   "SNSX50",
+}
+
+// spotExchanges maps derivative exchanges to the exchange listing their underlyings
+var spotExchanges = map[string]string{
+	"NFO": "NSE",
+	"BFO": "BSE",
+}
+
+// requiredColumns lists the instruments CSV columns we need to build an Instrument
+var requiredColumns = []string{
+	"instrument_token", "exchange_token", "tradingsymbol", "name", "last_price",
+	"expiry", "strike", "tick_size", "lot_size", "instrument_type", "segment", "exchange",
+}
+
+// maxLoggedRowErrors limits how many malformed rows are logged individually per exchange
+const maxLoggedRowErrors = 10
+
 // InstrumentManager manages instruments data
 type InstrumentManager struct {
 	config      *config.Config
-	instruments map[string]Instrument
+	list        []Instrument
+	instruments map[string]int
+	byExchange  map[string]int
+	rowErrors   []RowError
+	store       Store
+	client      *http.Client
+}
+
+// Store persists downloaded instruments, e.g. into a database
+type Store interface {
+	SaveInstruments(list []Instrument) error
 }
 
 // NewInstrumentManager creates a new instrument manager
 func NewInstrumentManager(config *config.Config) *InstrumentManager {
 	return &InstrumentManager{
 		config:      config,
-		instruments: make(map[string]Instrument),
+		instruments: make(map[string]int),
+		byExchange:  make(map[string]int),
 	}
 }
 
-// DownloadInstruments downloads instruments data from the broker
-func (im *InstrumentManager) DownloadInstruments() error {
+// DownloadInstruments downloads instruments data from the broker. Each
+// dump is saved to the instruments path, unless it is empty.
+func (im *InstrumentManager) DownloadInstruments(ctx context.Context) error {
 	log.Println("Downloading instruments data...")
-	
-	// Download NSE equity instruments
-	if err := im.downloadAndLoadNSE(); err != nil {
-		return fmt.Errorf("failed to download NSE instruments: %w", err)
+
+	im.reset()
+	for _, exchange := range im.config.Broker.Exchanges {
+		if err := im.downloadAndLoad(ctx, exchange); err != nil {
+			return fmt.Errorf("failed to download %s instruments: %w", exchange, err)
+		}
+	}
+
+	// Derivatives can only be linked once every exchange is loaded
+	im.linkUnderlyings()
+
+	if len(im.rowErrors) > 0 {
+		log.Printf("Warning: skipped %d malformed instrument rows", len(im.rowErrors))
+	}
+
+	if im.store != nil {
+		if err := im.store.SaveInstruments(im.list); err != nil {
+			return fmt.Errorf("failed to store instruments: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// SetStore makes DownloadInstruments also save the instruments to store
+func (im *InstrumentManager) SetStore(store Store) {
+	im.store = store
+}
+
+// SetHTTPClient makes DownloadInstruments use client instead of
+// http.DefaultClient
+func (im *InstrumentManager) SetHTTPClient(client *http.Client) {
+	im.client = client
+}
+
+// LoadSaved loads the instruments dumps saved by a previous download,
+// for commands that work offline. Exchanges without a saved dump are skipped.
+func (im *InstrumentManager) LoadSaved() error {
+	im.reset()
+	for _, exchange := range im.config.Broker.Exchanges {
+		file, err := os.Open(im.instrumentsPath(exchange))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open saved %s instruments: %w", exchange, err)
+		}
+
+		count, err := im.load(exchange, file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to load saved %s instruments: %w", exchange, err)
+		}
+		log.Printf("Loaded %d saved %s instruments", count, exchange)
 	}
-	
+
+	im.linkUnderlyings()
 	return nil
 }
 
-// downloadAndLoadNSE downloads NSE instruments and loads them into memory
-func (im *InstrumentManager) downloadAndLoadNSE() error {
-	log.Println("Downloading NSE instruments...")
+// reset drops previously loaded instruments so a reload doesn't duplicate them
+func (im *InstrumentManager) reset() {
+	im.list = nil
+	im.instruments = make(map[string]int)
+	im.byExchange = make(map[string]int)
+	im.rowErrors = nil
+}
+
+// instrumentsURL returns the download URL for an exchange's instruments dump
+func (im *InstrumentManager) instrumentsURL(exchange string) string {
+	if exchange == "NSE" {
+		return im.config.Broker.InstrumentsNSEURL
+	}
+	return strings.TrimSuffix(im.config.Broker.InstrumentsBaseURL, "/") + "/" + exchange
+}
+
+// instrumentsPath returns where an exchange's instruments dump is saved.
+// NSE keeps the configured path; other exchanges get a suffixed sibling file.
+func (im *InstrumentManager) instrumentsPath(exchange string) string {
+	path := im.config.Historical.InstrumentsPath
+	if exchange == "NSE" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_" + exchange + ext
+}
+
+// downloadAndLoad downloads an exchange's instruments and loads them into memory
+func (im *InstrumentManager) downloadAndLoad(ctx context.Context, exchange string) error {
+	log.Printf("Downloading %s instruments...", exchange)
 
 	// Download the CSV file
-	resp, err := http.Get(im.config.Broker.InstrumentsNSEURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, im.instrumentsURL(exchange), nil)
+	if err != nil {
+		return fmt.Errorf("failed to download instruments: %w", err)
+	}
+	client := im.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to download NSE instruments: %w", err)
+		return fmt.Errorf("failed to download instruments: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download NSE instruments, status code: %d", resp.StatusCode)
+		return fmt.Errorf("failed to download instruments, status code: %d", resp.StatusCode)
 	}
 
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(filepath.Dir(im.config.Historical.InstrumentsPath), 0755); err != nil {
-		return fmt.Errorf("failed to create instruments directory: %w", err)
+	// Without an instruments path the dump is only kept in memory
+	if im.config.Historical.InstrumentsPath == "" {
+		count, err := im.load(exchange, resp.Body)
+		if err != nil {
+			return err
+		}
+		log.Printf("Loaded %d %s instruments", count, exchange)
+		return nil
 	}
 
-	// Create a file to save the CSV
-	file, err := os.Create(im.config.Historical.InstrumentsPath)
+	path := im.instrumentsPath(exchange)
+
+	// Save the CSV atomically so an interrupted download keeps the previous dump
+	err = fsutil.WriteFile(path, func(w io.Writer) error {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create instruments file: %w", err)
+		return fmt.Errorf("failed to save instruments: %w", err)
 	}
-	defer file.Close()
 
-	// Copy the response body to the file
-	_, err = io.Copy(file, resp.Body)
+	// Open the saved file for reading
+	file, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to save NSE instruments: %w", err)
+		return fmt.Errorf("failed to open instruments file: %w", err)
 	}
+	defer file.Close()
 
-	// Rewind the file for reading
-	_, err = file.Seek(0, 0)
+	count, err := im.load(exchange, file)
 	if err != nil {
-		return fmt.Errorf("failed to rewind file: %w", err)
+		return err
 	}
 
-	// Parse the CSV file
-	reader := csv.NewReader(file)
+	log.Printf("Loaded %d %s instruments", count, exchange)
+	return nil
+}
+
+// load parses an instruments CSV dump, keeping rows for the given exchange
+func (im *InstrumentManager) load(exchange string, r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
 
 	// Read header
 	header, err := reader.Read()
 	if err != nil {
-		return fmt.Errorf("failed to read CSV header: %w", err)
+		return 0, fmt.Errorf("failed to read CSV header: %w", err)
 	}
 
 	// Map header columns to indices
@@ -91,68 +236,118 @@ func (im *InstrumentManager) downloadAndLoadNSE() error {
 	for i, col := range header {
 		columns[col] = i
 	}
+	for _, col := range requiredColumns {
+		if _, ok := columns[col]; !ok {
+			return 0, fmt.Errorf("instruments CSV is missing column %q", col)
+		}
+	}
 
 	// Read and parse rows
 	count := 0
-	for {
+	var rowErrors []RowError
+	for line := 2; ; line++ {
 		record, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to read CSV record: %w", err)
+			return count, fmt.Errorf("failed to read CSV record: %w", err)
+		}
+
+		// Only process instruments of the requested exchange
+		if record[columns["exchange"]] != exchange {
+			continue
 		}
 
-		// Only process NSE instruments
-		if record[columns["exchange"]] != "NSE" {
+		instrument, err := parseInstrument(record, columns)
+		if err != nil {
+			rowErrors = append(rowErrors, RowError{Exchange: exchange, Line: line, Err: err})
 			continue
 		}
 
-		// Parse instrument data
-		instrumentToken := parseIntOrZero(record[columns["instrument_token"]])
-		exchangeToken := parseIntOrZero(record[columns["exchange_token"]])
-		tradingSymbol := record[columns["tradingsymbol"]]
-		name := record[columns["name"]]
-		lastPrice := parseFloatOrZero(record[columns["last_price"]])
-		tickSize := parseFloatOrZero(record[columns["tick_size"]])
-		expiry := record[columns["expiry"]]
-		instrumentType := record[columns["instrument_type"]]
-		segment := record[columns["segment"]]
-		exchange := record[columns["exchange"]]
-		strike := parseFloatOrZero(record[columns["strike"]])
-		lotSize := parseIntOrZero(record[columns["lot_size"]])
-
-		// Create and store the instrument
-		instrument := Instrument{
-			InstrumentToken: instrumentToken,
-			ExchangeToken:   exchangeToken,
-			TradingSymbol:   tradingSymbol,
-			Name:            name,
-			LastPrice:       lastPrice,
-			TickSize:        tickSize,
-			Expiry:          expiry,
-			InstrumentType:  instrumentType,
-			Segment:         segment,
-			Exchange:        exchange,
-			StrikePrice:     strike,
-			LotSize:         lotSize,
-		}
-
-		im.instruments[tradingSymbol] = instrument
+		im.add(instrument)
 		count++
 	}
 
-	log.Printf("Loaded %d NSE instruments", count)
-	return nil
+	for i, rowErr := range rowErrors {
+		if i == maxLoggedRowErrors {
+			log.Printf("Warning: %d more malformed %s instrument rows not shown", len(rowErrors)-i, exchange)
+			break
+		}
+		log.Printf("Warning: skipping malformed row: %v", rowErr)
+	}
+	im.rowErrors = append(im.rowErrors, rowErrors...)
+
+	return count, nil
 }
 
-// GetInstrumentBySymbol returns an instrument by its trading symbol
+// add stores an instrument and indexes it by tradingsymbol.
+// When a tradingsymbol exists on several exchanges the first one loaded wins
+// the bare lookup; the others stay reachable as EXCHANGE:TRADINGSYMBOL.
+func (im *InstrumentManager) add(instrument Instrument) {
+	idx := len(im.list)
+	im.list = append(im.list, instrument)
+
+	if _, exists := im.instruments[instrument.TradingSymbol]; !exists {
+		im.instruments[instrument.TradingSymbol] = idx
+	}
+	im.byExchange[instrument.Exchange+":"+instrument.TradingSymbol] = idx
+}
+
+// linkUnderlyings fills Underlying and UnderlyingToken for derivatives
+// by resolving their name to the spot equity or index instrument
+func (im *InstrumentManager) linkUnderlyings() {
+	unlinked := 0
+	for i := range im.list {
+		instrument := &im.list[i]
+		if !instrument.IsDerivative() {
+			continue
+		}
+
+		instrument.Underlying = instrument.Name
+		spot, ok := im.findSpot(instrument.Exchange, instrument.Name)
+		if !ok {
+			unlinked++
+			continue
+		}
+		instrument.Underlying = spot.TradingSymbol
+		instrument.UnderlyingToken = spot.InstrumentToken
+	}
+
+	if unlinked > 0 {
+		log.Printf("Could not link %d derivatives to a spot instrument (is the spot exchange loaded?)", unlinked)
+	}
+}
+
+// findSpot looks up the cash market instrument a derivative is written on
+func (im *InstrumentManager) findSpot(exchange, name string) (Instrument, bool) {
+	spotExchange, ok := spotExchanges[exchange]
+	if !ok {
+		return Instrument{}, false
+	}
+
+	if index, ok := indexUnderlyings[name]; ok {
+		name = index
+	}
+
+	idx, ok := im.byExchange[spotExchange+":"+name]
+	if !ok {
+		return Instrument{}, false
+	}
+	return im.list[idx], true
+}
+
+// GetInstrumentBySymbol returns an instrument by its trading symbol.
+// The symbol may be qualified with its exchange, e.g. "NSE:RELIANCE".
 func (im *InstrumentManager) GetInstrumentBySymbol(symbol string) (Instrument, error) {
-	instrument, ok := im.instruments[symbol]
+	idx, ok := im.instruments[symbol]
+	if !ok {
+		idx, ok = im.byExchange[symbol]
+	}
 	if !ok {
 		return Instrument{}, fmt.Errorf("instrument not found: %s", symbol)
 	}
-	return instrument, nil
+	return im.list[idx], nil
 }
 
 // GetInstrumentsForSymbols returns instruments for a list of trading symbols
@@ -169,27 +364,118 @@ func (im *InstrumentManager) GetInstrumentsForSymbols(symbols []string) ([]Instr
 	return instruments, nil
 }
 
-// Helper functions for parsing CSV values
-func parseIntOrZero(s string) int64 {
+// GetLiveContracts returns the derivatives on an underlying that have not
+// expired at the given time, ordered as they appear in the instruments dump
+func (im *InstrumentManager) GetLiveContracts(underlying string, at time.Time) []Instrument {
+	var contracts []Instrument
+	for _, instrument := range im.list {
+		if !instrument.IsDerivative() || !instrument.IsLive(at) {
+			continue
+		}
+		if instrument.Underlying == underlying || instrument.Name == underlying {
+			contracts = append(contracts, instrument)
+		}
+	}
+	return contracts
+}
+
+// Instruments returns every loaded instrument
+func (im *InstrumentManager) Instruments() []Instrument {
+	return im.list
+}
+
+// MalformedRows returns the instrument rows skipped because they failed to parse
+func (im *InstrumentManager) MalformedRows() []RowError {
+	return im.rowErrors
+}
+
+// parseInstrument builds an Instrument from a CSV record
+func parseInstrument(record []string, columns map[string]int) (Instrument, error) {
+	p := rowParser{record: record, columns: columns}
+
+	instrument := Instrument{
+		InstrumentToken: p.int("instrument_token", true),
+		ExchangeToken:   p.int("exchange_token", false),
+		TradingSymbol:   p.str("tradingsymbol"),
+		Name:            p.str("name"),
+		LastPrice:       p.float("last_price"),
+		TickSize:        p.float("tick_size"),
+		Expiry:          p.date("expiry"),
+		InstrumentType:  p.str("instrument_type"),
+		Segment:         p.str("segment"),
+		Exchange:        p.str("exchange"),
+		StrikePrice:     p.float("strike"),
+		LotSize:         p.int("lot_size", false),
+	}
+	if p.err != nil {
+		return Instrument{}, p.err
+	}
+	if instrument.TradingSymbol == "" {
+		return Instrument{}, fmt.Errorf("empty tradingsymbol")
+	}
+	if instrument.IsDerivative() && instrument.Expiry.IsZero() {
+		return Instrument{}, fmt.Errorf("%s: derivative without expiry", instrument.TradingSymbol)
+	}
+	return instrument, nil
+}
+
+// rowParser parses typed fields from a CSV record, keeping the first error
+type rowParser struct {
+	record  []string
+	columns map[string]int
+	err     error
+}
+
+func (p *rowParser) str(col string) string {
+	idx := p.columns[col]
+	if idx >= len(p.record) {
+		p.fail(col, "", fmt.Errorf("missing field"))
+		return ""
+	}
+	return strings.TrimSpace(p.record[idx])
+}
+
+func (p *rowParser) int(col string, required bool) int64 {
+	s := p.str(col)
 	if s == "" {
+		if required {
+			p.fail(col, s, fmt.Errorf("value is required"))
+		}
 		return 0
 	}
-	var val int64
-	_, err := fmt.Sscanf(s, "%d", &val)
+	val, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
-		return 0
+		p.fail(col, s, err)
 	}
 	return val
 }
 
-func parseFloatOrZero(s string) float64 {
+func (p *rowParser) float(col string) float64 {
+	s := p.str(col)
 	if s == "" {
 		return 0
 	}
-	var val float64
-	_, err := fmt.Sscanf(s, "%f", &val)
+	val, err := strconv.ParseFloat(s, 64)
 	if err != nil {
-		return 0
+		p.fail(col, s, err)
 	}
 	return val
-}
\ No newline at end of file
+}
+
+func (p *rowParser) date(col string) time.Time {
+	s := p.str(col)
+	if s == "" {
+		return time.Time{}
+	}
+	val, err := time.ParseInLocation("2006-01-02", s, IST)
+	if err != nil {
+		p.fail(col, s, err)
+	}
+	return val
+}
+
+func (p *rowParser) fail(col, value string, err error) {
+	if p.err == nil {
+		p.err = fmt.Errorf("invalid %s %q: %w", col, value, err)
+	}
+}
@@ -1,5 +1,14 @@
 package instruments
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// IST is the exchange timezone used for expiry dates and trading sessions
+var IST = time.FixedZone("IST", 5*60*60+30*60)
+
 // Instrument represents a trading instrument
 type Instrument struct {
 	InstrumentToken int64
@@ -8,7 +17,7 @@ type Instrument struct {
 	Name            string
 	LastPrice       float64
 	TickSize        float64
-	Expiry          string
+	Expiry          time.Time
 	InstrumentType  string
 	Segment         string
 	Exchange        string
@@ -16,4 +25,35 @@ type Instrument struct {
 	LotSize         int64
 	Underlying      string
 	UnderlyingToken int64
-}
\ No newline at end of file
+}
+
+// IsDerivative reports whether the instrument is a future or an option
+func (i Instrument) IsDerivative() bool {
+	switch i.InstrumentType {
+	case "FUT", "CE", "PE":
+		return true
+	}
+	return strings.HasSuffix(i.Segment, "-FUT") || strings.HasSuffix(i.Segment, "-OPT")
+}
+
+// IsLive reports whether the instrument can still be traded at the given time.
+// Instruments without an expiry are always live; contracts stay live until
+// the end of their expiry day in IST.
+func (i Instrument) IsLive(at time.Time) bool {
+	if i.Expiry.IsZero() {
+		return true
+	}
+	return at.Before(i.Expiry.AddDate(0, 0, 1))
+}
+
+// RowError describes an instruments CSV row that could not be parsed
+type RowError struct {
+	Exchange string
+	Line     int
+	Err      error
+}
+
+// Error implements the error interface
+func (e RowError) Error() string {
+	return fmt.Sprintf("%s instruments line %d: %v", e.Exchange, e.Line, e.Err)
+}
@@ -0,0 +1,123 @@
+package instruments
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Symbol sources accepted by Resolve. Anything without one of these prefixes
+// is a literal tradingsymbol, optionally qualified as EXCHANGE:TRADINGSYMBOL.
+const (
+	universePrefix = "@"       // @nifty50 expands a named universe
+	filePrefix     = "file://" // file://fno.txt reads one source per line
+	envPrefix      = "env://"  // env://MY_SYMBOLS reads a comma-separated env var
+	filterPrefix   = "filter:" // filter:<expr> selects instruments by expression
+)
+
+// Selection describes which instruments to work on
+type Selection struct {
+	Include   []string
+	Exclude   []string
+	Universes map[string][]string
+}
+
+// Resolve expands a selection into instruments, dropping excluded ones.
+// The result keeps the order in which instruments were first included.
+func (im *InstrumentManager) Resolve(sel Selection) ([]Instrument, error) {
+	included, err := im.expand(sel.Include, sel.Universes, nil)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := im.expand(sel.Exclude, sel.Universes, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	skip := make(map[int64]bool, len(excluded))
+	for _, instrument := range excluded {
+		skip[instrument.InstrumentToken] = true
+	}
+
+	var result []Instrument
+	for _, instrument := range included {
+		if skip[instrument.InstrumentToken] {
+			continue
+		}
+		skip[instrument.InstrumentToken] = true
+		result = append(result, instrument)
+	}
+	return result, nil
+}
+
+// expand resolves sources recursively; visiting tracks universes being
+// expanded so that self-referencing universes are reported instead of looping
+func (im *InstrumentManager) expand(sources []string, universes map[string][]string, visiting []string) ([]Instrument, error) {
+	var result []Instrument
+	for _, source := range sources {
+		source = strings.TrimSpace(source)
+		switch {
+		case source == "" || strings.HasPrefix(source, "#"):
+			continue
+
+		case strings.HasPrefix(source, universePrefix):
+			name := strings.TrimPrefix(source, universePrefix)
+			members, ok := universes[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown universe %q", name)
+			}
+			for _, v := range visiting {
+				if v == name {
+					return nil, fmt.Errorf("universe %q references itself via %s", name, strings.Join(visiting, " -> "))
+				}
+			}
+			expanded, err := im.expand(members, universes, append(visiting, name))
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, expanded...)
+
+		case strings.HasPrefix(source, filePrefix):
+			path := strings.TrimPrefix(source, filePrefix)
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read symbol file: %w", err)
+			}
+			expanded, err := im.expand(strings.Split(string(content), "\n"), universes, visiting)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			result = append(result, expanded...)
+
+		case strings.HasPrefix(source, envPrefix):
+			name := strings.TrimPrefix(source, envPrefix)
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				return nil, fmt.Errorf("environment variable %s is not set", name)
+			}
+			expanded, err := im.expand(strings.Split(value, ","), universes, visiting)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			result = append(result, expanded...)
+
+		case strings.HasPrefix(source, filterPrefix):
+			matched, err := im.SelectExpr(strings.TrimPrefix(source, filterPrefix))
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Filter %q matched %d instruments", strings.TrimPrefix(source, filterPrefix), len(matched))
+			result = append(result, matched...)
+
+		default:
+			instrument, err := im.GetInstrumentBySymbol(source)
+			if err != nil {
+				log.Printf("Warning: %v", err)
+				continue
+			}
+			result = append(result, instrument)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,200 @@
+// Package postgres bulk-loads candles into PostgreSQL with COPY. When the
+// TimescaleDB extension is installed the candle table becomes a hypertable.
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/historical"
+)
+
+// DefaultTable is the candle table used when none is configured
+const DefaultTable = "candles"
+
+// stagingTable receives each COPY before it is merged into the candle table
+const stagingTable = "kitedata_candles_staging"
+
+// columns are the candle table columns, in COPY order
+var columns = []string{
+	"instrument_token", "symbol", "exchange", "interval", "timestamp",
+	"open", "high", "low", "close", "volume", "oi", "source",
+}
+
+func init() {
+	historical.RegisterSink("postgres", NewSink)
+}
+
+// Sink writes candles to a PostgreSQL or TimescaleDB table. Rows are keyed
+// by instrument token, interval and timestamp, so incremental runs update
+// the overlapping candles instead of failing on duplicates.
+type Sink struct {
+	URL   string
+	Table string
+	conn  *pgx.Conn
+	table string // quoted table name
+}
+
+// NewSink creates the postgres sink from the configuration
+func NewSink(cfg *config.Config) (historical.Sink, error) {
+	table, err := quoteTable(cfg.Historical.PostgresTable)
+	if err != nil {
+		return nil, err
+	}
+	return &Sink{URL: cfg.Historical.PostgresURL, Table: cfg.Historical.PostgresTable, table: table}, nil
+}
+
+// quoteTable quotes a table name, optionally qualified with a schema
+func quoteTable(name string) (string, error) {
+	if name == "" {
+		name = DefaultTable
+	}
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("invalid postgres table %q (use table or schema.table)", name)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return "", fmt.Errorf("invalid postgres table %q (use table or schema.table)", name)
+		}
+	}
+	return pgx.Identifier(parts).Sanitize(), nil
+}
+
+// Open connects to the database and creates the candle table. An empty URL
+// falls back to the standard PGHOST, PGUSER, PGDATABASE, ... variables.
+func (s *Sink) Open(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, s.URL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	if err := s.createTable(ctx, conn); err != nil {
+		conn.Close(ctx)
+		return err
+	}
+
+	s.conn = conn
+	return nil
+}
+
+// createTable creates the candle table if needed and turns it into a
+// hypertable when TimescaleDB is available
+func (s *Sink) createTable(ctx context.Context, conn *pgx.Conn) error {
+	ddl := `CREATE TABLE IF NOT EXISTS ` + s.table + ` (
+	instrument_token BIGINT           NOT NULL,
+	symbol           TEXT             NOT NULL,
+	exchange         TEXT             NOT NULL,
+	"interval"       TEXT             NOT NULL,
+	"timestamp"      TIMESTAMPTZ      NOT NULL,
+	open             DOUBLE PRECISION NOT NULL,
+	high             DOUBLE PRECISION NOT NULL,
+	low              DOUBLE PRECISION NOT NULL,
+	close            DOUBLE PRECISION NOT NULL,
+	volume           BIGINT           NOT NULL,
+	oi               BIGINT           NOT NULL,
+	source           TEXT             NOT NULL,
+	PRIMARY KEY (instrument_token, "interval", "timestamp")
+)`
+	if _, err := conn.Exec(ctx, ddl); err != nil {
+		return fmt.Errorf("failed to create table %s: %w", s.table, err)
+	}
+
+	var timescale bool
+	err := conn.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')`).Scan(&timescale)
+	if err != nil {
+		return fmt.Errorf("failed to check for timescaledb: %w", err)
+	}
+	if !timescale {
+		return nil
+	}
+
+	// The primary key includes the time column, as hypertables require
+	_, err = conn.Exec(ctx, `SELECT create_hypertable($1::regclass, 'timestamp', if_not_exists => TRUE, migrate_data => TRUE)`, s.table)
+	if err != nil {
+		return fmt.Errorf("failed to create hypertable %s: %w", s.table, err)
+	}
+	log.Printf("Using TimescaleDB hypertable %s", s.table)
+	return nil
+}
+
+// Write copies a series' candles into a staging table and merges them into
+// the candle table, all in one transaction
+func (s *Sink) Write(ctx context.Context, series historical.Series, candles []historical.HistoricalCandle) error {
+	if s.conn == nil {
+		return fmt.Errorf("postgres sink is not open")
+	}
+	if series.Token == 0 {
+		return fmt.Errorf("cannot store %s in postgres without an instrument token", series.Symbol)
+	}
+	if len(candles) == 0 {
+		return nil
+	}
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	staging := `CREATE TEMP TABLE IF NOT EXISTS ` + stagingTable + ` (LIKE ` + s.table + `) ON COMMIT DELETE ROWS`
+	if _, err := tx.Exec(ctx, staging); err != nil {
+		return fmt.Errorf("failed to create staging table: %w", err)
+	}
+
+	rows := pgx.CopyFromSlice(len(candles), func(i int) ([]any, error) {
+		c := candles[i]
+		return []any{
+			series.Token, series.Symbol, series.Exchange, series.Interval, c.Timestamp,
+			c.Open, c.High, c.Low, c.Close, c.Volume, c.OI, series.Source,
+		}, nil
+	})
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{stagingTable}, columns, rows); err != nil {
+		return fmt.Errorf("failed to copy candles for %s: %w", series.Symbol, err)
+	}
+
+	// DISTINCT ON keeps a batch with repeated timestamps from hitting the
+	// same row twice, which ON CONFLICT DO UPDATE rejects
+	merge := `INSERT INTO ` + s.table + ` SELECT DISTINCT ON (instrument_token, "interval", "timestamp") * FROM ` + stagingTable + `
+ORDER BY instrument_token, "interval", "timestamp"
+ON CONFLICT (instrument_token, "interval", "timestamp") DO UPDATE SET
+	symbol = excluded.symbol,
+	exchange = excluded.exchange,
+	open = excluded.open,
+	high = excluded.high,
+	low = excluded.low,
+	close = excluded.close,
+	volume = excluded.volume,
+	oi = excluded.oi,
+	source = excluded.source`
+	tag, err := tx.Exec(ctx, merge)
+	if err != nil {
+		return fmt.Errorf("failed to merge candles for %s: %w", series.Symbol, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit candles for %s: %w", series.Symbol, err)
+	}
+
+	log.Printf("Saved %d data points to %s", tag.RowsAffected(), s.table)
+	return nil
+}
+
+// OpenSeries stores a series chunk by chunk; every chunk is merged on its own
+func (s *Sink) OpenSeries(ctx context.Context, series historical.Series) (historical.SeriesWriter, error) {
+	return historical.ChunkedSeries(s, series), nil
+}
+
+// Close closes the database connection
+func (s *Sink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close(context.Background())
+	s.conn = nil
+	return err
+}
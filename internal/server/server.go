@@ -0,0 +1,404 @@
+// Package server serves the local candle store over HTTP, so candles can be
+// shared without access to the download machine or its directory layout
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/sabarim/kitedata/internal/instruments"
+)
+
+// contentTypes maps candle formats to their response content types
+var contentTypes = map[string]string{
+	historical.FormatJSON:  "application/json",
+	historical.FormatJSONL: "application/x-ndjson",
+	historical.FormatCSV:   "text/csv; charset=utf-8",
+	historical.FormatArrow: "application/vnd.apache.arrow.stream",
+}
+
+// Server answers API requests from the stored Parquet and CSV files
+type Server struct {
+	// Query locates and decodes the store; requests fill in the series,
+	// range and source
+	Query historical.QueryOptions
+	// Instruments supplies token, exchange and contract details; it may be
+	// empty, in which case only symbols are reported
+	Instruments *instruments.InstrumentManager
+	// Interval is used when a request names none
+	Interval string
+	// APIKey, when set, must be sent as a bearer token or X-API-Key header
+	APIKey string
+	// MaxRange is the longest from/to range of a candles request
+	MaxRange time.Duration
+
+	coverage historical.CoverageCache
+}
+
+// Handler returns the API routes:
+//
+//	GET /healthz                  liveness check, never authenticated
+//	GET /api/instruments          stored symbols with their instrument details
+//	GET /api/coverage             stored range per symbol and interval
+//	GET /api/candles              candles of one symbol as JSON, JSON Lines, CSV or Arrow
+func (s *Server) Handler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("/api/instruments", s.handleInstruments)
+	api.HandleFunc("/api/coverage", s.handleCoverage)
+	api.HandleFunc("/api/candles", s.handleCandles)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.Handle("/api/", s.authenticate(getOnly(api)))
+	return logRequests(mux)
+}
+
+// ListenAndServe serves the API on addr until ctx is cancelled, then gives
+// requests in flight a few seconds to finish
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("Serving stored candles on http://%s", addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down server: %w", err)
+	}
+	log.Println("Server stopped")
+	return nil
+}
+
+// instrumentInfo is a stored symbol in /api/instruments responses
+type instrumentInfo struct {
+	Symbol          string   `json:"symbol"`
+	InstrumentToken int64    `json:"instrument_token,omitempty"`
+	Exchange        string   `json:"exchange,omitempty"`
+	Name            string   `json:"name,omitempty"`
+	Segment         string   `json:"segment,omitempty"`
+	InstrumentType  string   `json:"instrument_type,omitempty"`
+	Expiry          string   `json:"expiry,omitempty"`
+	StrikePrice     float64  `json:"strike_price,omitempty"`
+	TickSize        float64  `json:"tick_size,omitempty"`
+	LotSize         int64    `json:"lot_size,omitempty"`
+	Intervals       []string `json:"intervals"`
+}
+
+// handleInstruments lists the stored symbols. ?exchange= and ?segment= keep
+// matching instruments; ?q= matches a substring of the symbol or name.
+func (s *Server) handleInstruments(w http.ResponseWriter, r *http.Request) {
+	symbols, err := historical.StoredSymbolsIn(s.Query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	params := r.URL.Query()
+	exchange, segment := params.Get("exchange"), params.Get("segment")
+	q := strings.ToUpper(params.Get("q"))
+
+	list := make([]instrumentInfo, 0, len(symbols))
+	for _, symbol := range symbols {
+		info := instrumentInfo{Symbol: symbol}
+		if s.Instruments != nil {
+			if inst, err := s.Instruments.GetInstrumentBySymbol(symbol); err == nil {
+				info.InstrumentToken = inst.InstrumentToken
+				info.Exchange = inst.Exchange
+				info.Name = inst.Name
+				info.Segment = inst.Segment
+				info.InstrumentType = inst.InstrumentType
+				info.StrikePrice = inst.StrikePrice
+				info.TickSize = inst.TickSize
+				info.LotSize = inst.LotSize
+				if !inst.Expiry.IsZero() {
+					info.Expiry = inst.Expiry.Format("2006-01-02")
+				}
+			}
+		}
+
+		if exchange != "" && !strings.EqualFold(info.Exchange, exchange) {
+			continue
+		}
+		if segment != "" && !strings.EqualFold(info.Segment, segment) {
+			continue
+		}
+		if q != "" && !strings.Contains(info.Symbol, q) && !strings.Contains(strings.ToUpper(info.Name), q) {
+			continue
+		}
+
+		opts := s.Query
+		opts.Series = historical.Series{Symbol: symbol}
+		if info.Intervals, err = historical.StoredIntervals(opts); err != nil {
+			writeError(w, err)
+			return
+		}
+		list = append(list, info)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleCoverage reports the stored range of every interval of the symbols
+// in ?symbol= (comma-separated), or of every stored symbol
+func (s *Server) handleCoverage(w http.ResponseWriter, r *http.Request) {
+	symbols := splitParam(r.URL.Query().Get("symbol"))
+	if len(symbols) == 0 {
+		stored, err := historical.StoredSymbolsIn(s.Query)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		symbols = stored
+	}
+
+	coverage := []historical.Coverage{}
+	for _, symbol := range symbols {
+		if err := checkSymbol(symbol); err != nil {
+			writeError(w, err)
+			return
+		}
+		opts := s.Query
+		opts.Series = historical.Series{Symbol: symbol}
+		c, err := s.coverage.SymbolCoverage(r.Context(), opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		coverage = append(coverage, c...)
+	}
+	writeJSON(w, http.StatusOK, coverage)
+}
+
+// handleCandles returns one symbol's candles. Parameters: symbol and from
+// (required), interval, to (dates or RFC3339 times, to inclusive, at most
+// MaxRange after from), source (auto, parquet or csv) and format (json,
+// jsonl, csv or arrow).
+func (s *Server) handleCandles(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	symbol := params.Get("symbol")
+	if symbol == "" {
+		writeError(w, badRequest("symbol is required"))
+		return
+	}
+	if err := checkSymbol(symbol); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	interval := params.Get("interval")
+	if interval == "" {
+		interval = s.Interval
+	}
+	interval, err := historical.KiteInterval(interval)
+	if err != nil {
+		writeError(w, badRequest(err.Error()))
+		return
+	}
+
+	format := params.Get("format")
+	if format == "" {
+		format = historical.FormatJSON
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		writeError(w, badRequest(fmt.Sprintf("invalid format %q (use json, jsonl, csv or arrow)", format)))
+		return
+	}
+
+	opts := s.Query
+	opts.Series = s.series(symbol, interval)
+	switch opts.Source = params.Get("source"); opts.Source {
+	case "", historical.QuerySourceAuto, historical.QuerySourceParquet, historical.QuerySourceCSV:
+	default:
+		writeError(w, badRequest(fmt.Sprintf("invalid source %q (use auto, parquet or csv)", opts.Source)))
+		return
+	}
+	loc := opts.CSV.Location
+	if params.Get("from") == "" {
+		writeError(w, badRequest("from is required"))
+		return
+	}
+	if opts.From, err = historical.ParseQueryTime(params.Get("from"), loc, false); err != nil {
+		writeError(w, badRequest(err.Error()))
+		return
+	}
+	if opts.To, err = historical.ParseQueryTime(params.Get("to"), loc, true); err != nil {
+		writeError(w, badRequest(err.Error()))
+		return
+	}
+	// Bound the work one request can cause
+	if s.MaxRange > 0 {
+		if opts.To.IsZero() {
+			opts.To = opts.From.Add(s.MaxRange)
+		} else if opts.To.Sub(opts.From) > s.MaxRange {
+			writeError(w, badRequest(fmt.Sprintf("range from %s to %s exceeds %d days; split the request",
+				params.Get("from"), params.Get("to"), int(s.MaxRange.Hours()/24))))
+			return
+		}
+	}
+
+	candles, err := historical.Query(r.Context(), opts)
+	if errors.Is(err, fs.ErrNotExist) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no stored %s candles for %s", interval, symbol)})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if format == historical.FormatCSV || format == historical.FormatArrow {
+		ext := map[string]string{historical.FormatCSV: ".csv", historical.FormatArrow: ".arrows"}[format]
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", symbol+"_"+interval+ext))
+	}
+	if err := historical.WriteCandles(w, format, opts.Series, candles, opts.CSV); err != nil {
+		// The status is already sent; all that is left is to log
+		log.Printf("Error writing candles for %s: %v", symbol, err)
+	}
+}
+
+// series describes a symbol's candles, with instrument details when known
+func (s *Server) series(symbol, interval string) historical.Series {
+	series := historical.Series{Symbol: symbol, Interval: interval}
+	if s.Instruments != nil {
+		if inst, err := s.Instruments.GetInstrumentBySymbol(symbol); err == nil {
+			series.Token = inst.InstrumentToken
+			series.Exchange = inst.Exchange
+			series.TickSize = inst.TickSize
+		}
+	}
+	return series
+}
+
+// authenticate rejects requests without the API key, when one is configured
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.APIKey == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			key = bearer
+		}
+		if subtle.ConstantTimeCompare([]byte(key), []byte(s.APIKey)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid API key"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getOnly rejects methods other than GET and HEAD
+func getOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder remembers the status written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs every request with its status and duration
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.Printf("%s %s %d %s", r.Method, r.URL.RequestURI(), rec.status, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// requestError is a problem with the request rather than the store
+type requestError string
+
+func (e requestError) Error() string { return string(e) }
+
+func badRequest(msg string) error { return requestError(msg) }
+
+// writeError reports err as JSON with a status matching its cause
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var reqErr requestError
+	switch {
+	case errors.As(err, &reqErr):
+		status = http.StatusBadRequest
+	case errors.Is(err, fs.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, context.Canceled):
+		// The client went away; nobody will read the response
+		return
+	}
+	if status == http.StatusInternalServerError {
+		log.Printf("Error serving request: %v", err)
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// checkSymbol rejects symbols that could not name a stored symbol; they
+// become directory names and glob patterns, so separators, ".." and glob
+// characters must never reach the store
+func checkSymbol(symbol string) error {
+	if symbol == "." || symbol == ".." || strings.ContainsAny(symbol, "/\\*?[]\x00") {
+		return badRequest(fmt.Sprintf("invalid symbol %q", symbol))
+	}
+	return nil
+}
+
+// splitParam splits a comma-separated query parameter, dropping empty items
+func splitParam(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
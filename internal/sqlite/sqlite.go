@@ -0,0 +1,266 @@
+// Package sqlite stores candles, instruments and download metadata in a
+// SQLite database through the pure-Go modernc.org/sqlite driver, so no cgo
+// toolchain is needed.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/sabarim/kitedata/internal/instruments"
+	_ "modernc.org/sqlite"
+)
+
+// driverName is the database/sql name of the modernc.org/sqlite driver
+const driverName = "sqlite"
+
+// schema creates the tables on first use. Candles are keyed by instrument,
+// interval and timestamp so re-downloading a range replaces the old rows.
+const schema = `
+CREATE TABLE IF NOT EXISTS instruments (
+	instrument_token INTEGER PRIMARY KEY,
+	exchange_token   INTEGER NOT NULL,
+	tradingsymbol    TEXT    NOT NULL,
+	name             TEXT    NOT NULL,
+	last_price       REAL    NOT NULL,
+	expiry           TEXT,
+	strike           REAL    NOT NULL,
+	tick_size        REAL    NOT NULL,
+	lot_size         INTEGER NOT NULL,
+	instrument_type  TEXT    NOT NULL,
+	segment          TEXT    NOT NULL,
+	exchange         TEXT    NOT NULL,
+	underlying       TEXT,
+	underlying_token INTEGER,
+	updated_at       INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS instruments_symbol ON instruments (exchange, tradingsymbol);
+
+CREATE TABLE IF NOT EXISTS candles (
+	instrument_token INTEGER NOT NULL,
+	interval         TEXT    NOT NULL,
+	timestamp        INTEGER NOT NULL,
+	open             REAL    NOT NULL,
+	high             REAL    NOT NULL,
+	low              REAL    NOT NULL,
+	close            REAL    NOT NULL,
+	volume           INTEGER NOT NULL,
+	oi               INTEGER NOT NULL,
+	PRIMARY KEY (instrument_token, interval, timestamp)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS downloads (
+	id               INTEGER PRIMARY KEY AUTOINCREMENT,
+	instrument_token INTEGER NOT NULL,
+	symbol           TEXT    NOT NULL,
+	exchange         TEXT    NOT NULL,
+	interval         TEXT    NOT NULL,
+	source           TEXT    NOT NULL,
+	first_timestamp  INTEGER,
+	last_timestamp   INTEGER,
+	candles          INTEGER NOT NULL,
+	downloaded_at    INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS downloads_series ON downloads (instrument_token, interval);
+`
+
+const upsertInstrument = `
+INSERT INTO instruments (instrument_token, exchange_token, tradingsymbol, name, last_price,
+	expiry, strike, tick_size, lot_size, instrument_type, segment, exchange,
+	underlying, underlying_token, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (instrument_token) DO UPDATE SET
+	exchange_token = excluded.exchange_token,
+	tradingsymbol = excluded.tradingsymbol,
+	name = excluded.name,
+	last_price = excluded.last_price,
+	expiry = excluded.expiry,
+	strike = excluded.strike,
+	tick_size = excluded.tick_size,
+	lot_size = excluded.lot_size,
+	instrument_type = excluded.instrument_type,
+	segment = excluded.segment,
+	exchange = excluded.exchange,
+	underlying = excluded.underlying,
+	underlying_token = excluded.underlying_token,
+	updated_at = excluded.updated_at`
+
+const upsertCandle = `
+INSERT INTO candles (instrument_token, interval, timestamp, open, high, low, close, volume, oi)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (instrument_token, interval, timestamp) DO UPDATE SET
+	open = excluded.open,
+	high = excluded.high,
+	low = excluded.low,
+	close = excluded.close,
+	volume = excluded.volume,
+	oi = excluded.oi`
+
+const insertDownload = `
+INSERT INTO downloads (instrument_token, symbol, exchange, interval, source,
+	first_timestamp, last_timestamp, candles, downloaded_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+func init() {
+	historical.RegisterSink("sqlite", NewSink)
+}
+
+// DB is a kitedata SQLite database. It implements historical.Sink for
+// candles and instruments.Store for the instrument dump.
+type DB struct {
+	Path string
+	db   *sql.DB
+}
+
+// NewSink creates the sqlite sink from the configuration
+func NewSink(cfg *config.Config) (historical.Sink, error) {
+	return &DB{Path: cfg.Historical.SQLitePath}, nil
+}
+
+// Open opens the database at path and creates its tables
+func Open(ctx context.Context, path string) (*DB, error) {
+	d := &DB{Path: path}
+	if err := d.Open(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Open connects to the database and creates missing tables
+func (d *DB) Open(ctx context.Context) error {
+	if d.db != nil {
+		return nil
+	}
+	if dir := filepath.Dir(d.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	// WAL lets readers query the database while a download is writing
+	dsn := "file:" + d.Path + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", d.Path, err)
+	}
+	// SQLite allows a single writer; one connection avoids busy errors
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to create tables in %s: %w", d.Path, err)
+	}
+
+	d.db = db
+	return nil
+}
+
+// Write upserts a series' candles and records the download, in one transaction
+func (d *DB) Write(ctx context.Context, series historical.Series, candles []historical.HistoricalCandle) error {
+	if series.Token == 0 {
+		return fmt.Errorf("cannot store %s in sqlite without an instrument token", series.Symbol)
+	}
+
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, upsertCandle)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, c := range candles {
+			if _, err := stmt.ExecContext(ctx, series.Token, series.Interval, c.Timestamp.Unix(),
+				c.Open, c.High, c.Low, c.Close, c.Volume, c.OI); err != nil {
+				return fmt.Errorf("failed to store candle for %s: %w", series.Symbol, err)
+			}
+		}
+
+		var first, last sql.NullInt64
+		if len(candles) > 0 {
+			first = sql.NullInt64{Int64: candles[0].Timestamp.Unix(), Valid: true}
+			last = sql.NullInt64{Int64: candles[len(candles)-1].Timestamp.Unix(), Valid: true}
+		}
+		if _, err := tx.ExecContext(ctx, insertDownload, series.Token, series.Symbol, series.Exchange,
+			series.Interval, series.Source, first, last, len(candles), time.Now().Unix()); err != nil {
+			return fmt.Errorf("failed to record download of %s: %w", series.Symbol, err)
+		}
+		return nil
+	})
+}
+
+// OpenSeries stores a series chunk by chunk; every chunk is upserted and
+// recorded as a download of its own
+func (d *DB) OpenSeries(ctx context.Context, series historical.Series) (historical.SeriesWriter, error) {
+	return historical.ChunkedSeries(d, series), nil
+}
+
+// SaveInstruments upserts the instrument dump
+func (d *DB) SaveInstruments(list []instruments.Instrument) error {
+	ctx := context.Background()
+	if err := d.Open(ctx); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, upsertInstrument)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, inst := range list {
+			var expiry, underlying sql.NullString
+			var underlyingToken sql.NullInt64
+			if !inst.Expiry.IsZero() {
+				expiry = sql.NullString{String: inst.Expiry.Format("2006-01-02"), Valid: true}
+			}
+			if inst.Underlying != "" {
+				underlying = sql.NullString{String: inst.Underlying, Valid: true}
+				underlyingToken = sql.NullInt64{Int64: inst.UnderlyingToken, Valid: inst.UnderlyingToken != 0}
+			}
+			if _, err := stmt.ExecContext(ctx, inst.InstrumentToken, inst.ExchangeToken, inst.TradingSymbol,
+				inst.Name, inst.LastPrice, expiry, inst.StrikePrice, inst.TickSize, inst.LotSize,
+				inst.InstrumentType, inst.Segment, inst.Exchange, underlying, underlyingToken, now); err != nil {
+				return fmt.Errorf("failed to store instrument %s: %w", inst.TradingSymbol, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the database
+func (d *DB) Close() error {
+	if d.db == nil {
+		return nil
+	}
+	err := d.db.Close()
+	d.db = nil
+	return err
+}
+
+// inTx runs fn in a transaction, committing if it succeeds
+func (d *DB) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if d.db == nil {
+		return fmt.Errorf("sqlite database %s is not open", d.Path)
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
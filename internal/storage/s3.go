@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/sabarim/kitedata/internal/config"
+)
+
+// Multipart part sizes; S3 rejects parts smaller than 5 MiB except the last
+const (
+	DefaultS3PartSize = 16 << 20
+	minS3PartSize     = 5 << 20
+)
+
+// S3 stores files in an S3-compatible bucket such as AWS S3 or MinIO.
+// Files larger than PartSize are sent as multipart uploads.
+type S3 struct {
+	Bucket   string
+	Prefix   string
+	PartSize uint64
+	client   *minio.Client
+}
+
+// NewS3 creates the S3 storage from the historical config. Credentials come
+// from s3_access_key/s3_secret_key, or else from the AWS_* or MINIO_*
+// environment variables, ~/.aws/credentials or an instance role.
+func NewS3(cfg config.HistoricalConfig) (*S3, error) {
+	if cfg.S3Bucket == "" {
+		return nil, fmt.Errorf("s3 storage needs s3_bucket")
+	}
+	endpoint := cfg.S3Endpoint
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	partSize := cfg.S3PartSize
+	if partSize == 0 {
+		partSize = DefaultS3PartSize
+	}
+	if partSize < minS3PartSize {
+		return nil, fmt.Errorf("invalid s3_part_size %d (minimum is %d bytes)", partSize, minS3PartSize)
+	}
+
+	var creds *credentials.Credentials
+	if cfg.S3AccessKey != "" {
+		creds = credentials.NewStaticV4(cfg.S3AccessKey, cfg.S3SecretKey, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: cfg.S3UseSSL,
+		Region: cfg.S3Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 settings: %w", err)
+	}
+
+	return &S3{
+		Bucket:   cfg.S3Bucket,
+		Prefix:   strings.Trim(cfg.S3Prefix, "/"),
+		PartSize: uint64(partSize),
+		client:   client,
+	}, nil
+}
+
+// Key maps a local path to its object key below the prefix
+func (s *S3) Key(name string) string {
+	key := strings.TrimLeft(filepath.ToSlash(filepath.Clean(name)), "/")
+	return path.Join(s.Prefix, key)
+}
+
+// Prepare checks that the bucket is reachable; object stores have no directories
+func (s *S3) Prepare(ctx context.Context, dir string) error {
+	exists, err := s.client.BucketExists(ctx, s.Bucket)
+	if err != nil {
+		return fmt.Errorf("failed to reach bucket %s: %w", s.Bucket, err)
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s does not exist", s.Bucket)
+	}
+	return nil
+}
+
+// WriteFile spools the file to a local temporary file, which formats such
+// as Arrow need to seek in, and uploads it. An object only appears once the
+// upload completes; a failed multipart upload is aborted.
+func (s *S3) WriteFile(ctx context.Context, name string, write func(w io.Writer) error) error {
+	return writeFile(ctx, s, name, write)
+}
+
+// Create spools a file locally until Commit uploads it
+func (s *S3) Create(ctx context.Context, name string) (File, error) {
+	tmp, err := os.CreateTemp("", "kitedata-*"+path.Ext(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	return &s3File{File: tmp, ctx: ctx, store: s, name: name}, nil
+}
+
+// s3File is a local spool file uploaded on Commit
+type s3File struct {
+	*os.File
+	ctx   context.Context
+	store *S3
+	name  string
+	done  bool
+}
+
+// Commit uploads the spooled content
+func (f *s3File) Commit() error {
+	if f.done {
+		return fmt.Errorf("%s already closed", f.name)
+	}
+	defer f.Abort()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s := f.store
+	opts := minio.PutObjectOptions{
+		PartSize:    s.PartSize,
+		ContentType: contentType(f.name),
+	}
+	if _, err := s.client.PutObject(f.ctx, s.Bucket, s.Key(f.name), f.File, size, opts); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", s.Location(f.name), err)
+	}
+	return nil
+}
+
+// Abort removes the spool file
+func (f *s3File) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// ReadFile downloads an object
+func (s *S3) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	obj, err := s.client.GetObject(ctx, s.Bucket, s.Key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", s.Location(name), err)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%s: %w", s.Location(name), fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", s.Location(name), err)
+	}
+	return data, nil
+}
+
+// Remove deletes an object
+func (s *S3) Remove(ctx context.Context, name string) error {
+	if err := s.client.RemoveObject(ctx, s.Bucket, s.Key(name), minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", s.Location(name), err)
+	}
+	return nil
+}
+
+// Location returns the object's s3:// URL
+func (s *S3) Location(name string) string {
+	return "s3://" + s.Bucket + "/" + s.Key(name)
+}
+
+// contentType guesses an object's content type from its extension
+func contentType(name string) string {
+	switch path.Ext(name) {
+	case ".csv":
+		return "text/csv"
+	case ".jsonl":
+		return "application/x-ndjson"
+	case ".gz":
+		return "application/gzip"
+	case ".zst":
+		return "application/zstd"
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,118 @@
+// Package storage abstracts where output files are written, so the file
+// based sinks can target the local filesystem or S3-compatible object storage.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/fsutil"
+)
+
+// Storage kinds accepted by the storage setting
+const (
+	KindLocal = "local"
+	KindS3    = "s3"
+)
+
+// Storage stores output files by path. Paths are the local paths the sinks
+// would write to; object stores map them to keys below their prefix.
+type Storage interface {
+	// Prepare readies a directory before files are written below it
+	Prepare(ctx context.Context, dir string) error
+	// WriteFile replaces a file with what write produces. Readers see either
+	// the old or the complete new content. The writer is seekable.
+	WriteFile(ctx context.Context, name string, write func(w io.Writer) error) error
+	// Create starts replacing a file that is written over time, such as a
+	// series downloaded in chunks. The content appears on Commit.
+	Create(ctx context.Context, name string) (File, error)
+	// ReadFile returns a file's content, or an error wrapping fs.ErrNotExist
+	ReadFile(ctx context.Context, name string) ([]byte, error)
+	// Remove deletes a file; a missing file is not an error
+	Remove(ctx context.Context, name string) error
+	// Location describes where a file is stored, for logs
+	Location(name string) string
+}
+
+// File is a file being written through Storage
+type File interface {
+	io.WriteSeeker
+	// Commit makes the written content visible in place of the old file
+	Commit() error
+	// Abort discards the written content
+	Abort()
+}
+
+// writeFile implements WriteFile on top of Create
+func writeFile(ctx context.Context, s Storage, name string, write func(w io.Writer) error) error {
+	f, err := s.Create(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer f.Abort() // no-op once committed
+
+	if err := write(f); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// FromConfig creates the storage selected by the historical config
+func FromConfig(cfg config.HistoricalConfig) (Storage, error) {
+	switch strings.ToLower(cfg.Storage) {
+	case "", KindLocal:
+		return Local{}, nil
+	case KindS3:
+		return NewS3(cfg)
+	}
+	return nil, fmt.Errorf("invalid storage %q (use local or s3)", cfg.Storage)
+}
+
+// Local stores files on the local filesystem, replacing them atomically
+type Local struct{}
+
+// Prepare creates the directory and removes stale temporary files in it
+func (Local) Prepare(ctx context.Context, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	if _, err := fsutil.CleanTempFiles(dir); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return nil
+}
+
+// WriteFile writes the file through a temporary file renamed into place
+func (Local) WriteFile(ctx context.Context, name string, write func(w io.Writer) error) error {
+	return fsutil.WriteFile(name, write)
+}
+
+// Create starts a temporary file that is renamed into place on Commit
+func (Local) Create(ctx context.Context, name string) (File, error) {
+	return fsutil.Create(name)
+}
+
+// ReadFile reads the file from disk
+func (Local) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// Remove deletes the file from disk
+func (Local) Remove(ctx context.Context, name string) error {
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Location returns the path itself
+func (Local) Location(name string) string {
+	return name
+}
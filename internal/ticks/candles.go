@@ -0,0 +1,392 @@
+package ticks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/sabarim/kitedata/internal/instruments"
+	kiteticker "github.com/zerodha/gokiteconnect/v4/ticker"
+)
+
+// barGrace is how long a candle waits for late ticks after its interval
+// ends before Expire completes it
+const barGrace = 5 * time.Second
+
+// CandleBuilder aggregates ticks into candles of one or more intervals as
+// they arrive. Prices move on trades only: quote and full ticks count when
+// the cumulative day volume changes, and the change becomes the candle's
+// volume. Indices and ltp ticks carry no volume, so every tick counts.
+type CandleBuilder struct {
+	intervals   []string
+	durations   []time.Duration
+	series      map[uint32]historical.Series
+	instruments map[uint32]*instrumentCandles
+}
+
+// instrumentCandles is the builder's state for one instrument's day
+type instrumentCandles struct {
+	series  historical.Series
+	day     string
+	volume  uint32
+	seen    bool
+	candles []*intervalCandles
+}
+
+// intervalCandles holds the candles of one interval
+type intervalCandles struct {
+	series  historical.Series
+	d       time.Duration
+	current *historical.HistoricalCandle
+	end     time.Time
+	done    []historical.HistoricalCandle
+	// changed holds the indexes in done completed or amended since the
+	// last Updated
+	changed map[int]bool
+}
+
+// SeriesCandles is a series with its completed candles of the day
+type SeriesCandles struct {
+	Series historical.Series
+	// Day is the trading day of the candles, YYYY-MM-DD
+	Day     string
+	Candles []historical.HistoricalCandle
+	// Changed are the candles completed or amended since the last Updated
+	Changed []historical.HistoricalCandle
+}
+
+// NewCandleBuilder creates a builder for the given intervals. The
+// instruments provide the series details of their ticks.
+func NewCandleBuilder(intervals []string, list []instruments.Instrument) (*CandleBuilder, error) {
+	b := &CandleBuilder{
+		series:      make(map[uint32]historical.Series, len(list)),
+		instruments: make(map[uint32]*instrumentCandles),
+	}
+	for _, name := range intervals {
+		interval, err := historical.KiteInterval(name)
+		if err != nil {
+			return nil, err
+		}
+		d, _ := historical.IntervalDuration(interval)
+		b.intervals = append(b.intervals, interval)
+		b.durations = append(b.durations, d)
+	}
+	for _, inst := range list {
+		b.series[uint32(inst.InstrumentToken)] = historical.Series{
+			Symbol:   inst.TradingSymbol,
+			Token:    inst.InstrumentToken,
+			Exchange: inst.Exchange,
+			Source:   historical.SourceTicks,
+			TickSize: inst.TickSize,
+		}
+	}
+	return b, nil
+}
+
+// Intervals returns the Kite names of the intervals being built
+func (b *CandleBuilder) Intervals() []string {
+	return b.intervals
+}
+
+// Add applies a tick to the candles of its instrument
+func (b *CandleBuilder) Add(tick Tick) {
+	state := b.instrument(tick)
+	at := tradeTime(tick)
+	day := at.In(instruments.IST).Format("2006-01-02")
+	if day < state.day {
+		return
+	}
+	if day != state.day {
+		state.reset(day, b.intervals, b.durations)
+	}
+
+	// Cumulative volume turns into per-candle volume. Volume traded before
+	// the first tick only belongs to a candle if that is the session's first.
+	var delta uint32
+	switch volume := tick.VolumeTraded; {
+	case !state.seen:
+		open := historical.CandleStart(at, 24*time.Hour).Add(historical.SessionOpen)
+		if at.Before(open.Add(time.Minute)) {
+			delta = volume
+		}
+	case volume == 0:
+	case volume == state.volume:
+		// A quote change without a trade
+		return
+	case volume > state.volume:
+		delta = volume - state.volume
+	}
+	state.seen = true
+	state.volume = tick.VolumeTraded
+
+	for _, c := range state.candles {
+		c.add(at, tick.LastPrice, int64(delta), int64(tick.OI))
+	}
+}
+
+// instrument returns the state of a tick's instrument, creating it on its
+// first tick
+func (b *CandleBuilder) instrument(tick Tick) *instrumentCandles {
+	if state, ok := b.instruments[tick.InstrumentToken]; ok {
+		return state
+	}
+	series, ok := b.series[tick.InstrumentToken]
+	if !ok {
+		series = historical.Series{
+			Symbol:   tick.Symbol,
+			Token:    int64(tick.InstrumentToken),
+			Exchange: tick.Exchange,
+			Source:   historical.SourceTicks,
+		}
+	}
+	state := &instrumentCandles{series: series}
+	b.instruments[tick.InstrumentToken] = state
+	return state
+}
+
+// reset starts a new day for the instrument
+func (s *instrumentCandles) reset(day string, intervals []string, durations []time.Duration) {
+	s.day, s.volume, s.seen = day, 0, false
+	s.candles = make([]*intervalCandles, len(intervals))
+	for i, interval := range intervals {
+		series := s.series
+		series.Interval = interval
+		s.candles[i] = &intervalCandles{series: series, d: durations[i]}
+	}
+}
+
+// tradeTime returns when a tick's last trade happened, falling back to the
+// exchange timestamp and the receive time for ticks without one
+func tradeTime(tick Tick) time.Time {
+	switch {
+	case tick.Mode != string(kiteticker.ModeLTP) && tick.VolumeTraded > 0 && tick.LastTradeTime != nil:
+		return *tick.LastTradeTime
+	case tick.Timestamp != nil:
+		return *tick.Timestamp
+	}
+	return tick.ReceivedAt
+}
+
+// add applies a trade to the candle of its interval. Trades for a candle
+// that was already completed amend it, so late ticks are not lost.
+func (c *intervalCandles) add(at time.Time, price float64, volume, oi int64) {
+	start := historical.CandleStart(at, c.d)
+	if candle, i := c.find(start); candle != nil {
+		candle.High = max(candle.High, price)
+		candle.Low = min(candle.Low, price)
+		candle.Close = price
+		candle.Volume += volume
+		if oi > 0 {
+			candle.OI = oi
+		}
+		if candle != c.current {
+			c.change(i)
+		}
+		return
+	}
+	if (c.current != nil && start.Before(c.current.Timestamp)) ||
+		(len(c.done) > 0 && start.Before(c.done[len(c.done)-1].Timestamp)) {
+		// Older than the latest candle and not one of those held
+		return
+	}
+
+	c.complete()
+	c.current = &historical.HistoricalCandle{
+		Timestamp: start,
+		Open:      price,
+		High:      price,
+		Low:       price,
+		Close:     price,
+		Volume:    volume,
+		OI:        oi,
+	}
+	c.end = start.Add(c.d)
+	if c.d >= 24*time.Hour {
+		c.end = start.AddDate(0, 0, 1)
+	}
+}
+
+// find returns the candle starting at start, if there is one, and its
+// index in done when it is completed
+func (c *intervalCandles) find(start time.Time) (*historical.HistoricalCandle, int) {
+	if c.current != nil && c.current.Timestamp.Equal(start) {
+		return c.current, -1
+	}
+	i := sort.Search(len(c.done), func(i int) bool {
+		return !c.done[i].Timestamp.Before(start)
+	})
+	if i < len(c.done) && c.done[i].Timestamp.Equal(start) {
+		return &c.done[i], i
+	}
+	return nil, -1
+}
+
+// complete moves the current candle to the completed ones
+func (c *intervalCandles) complete() {
+	if c.current == nil {
+		return
+	}
+	c.done = append(c.done, *c.current)
+	c.current = nil
+	c.change(len(c.done) - 1)
+}
+
+// change marks a completed candle to be written
+func (c *intervalCandles) change(i int) {
+	if c.changed == nil {
+		c.changed = make(map[int]bool)
+	}
+	c.changed[i] = true
+}
+
+// Expire completes candles whose interval ended at least barGrace before
+// now, for instruments that have stopped trading
+func (b *CandleBuilder) Expire(now time.Time) {
+	for _, state := range b.instruments {
+		for _, c := range state.candles {
+			if c.current != nil && !now.Before(c.end.Add(barGrace)) {
+				c.complete()
+			}
+		}
+	}
+}
+
+// Finish completes every candle still open, when no more ticks will come
+func (b *CandleBuilder) Finish() {
+	for _, state := range b.instruments {
+		for _, c := range state.candles {
+			c.complete()
+		}
+	}
+}
+
+// Updated returns the series that gained or amended candles since the last
+// call, each with all its completed candles of the day and the changed ones
+func (b *CandleBuilder) Updated() []SeriesCandles {
+	var updated []SeriesCandles
+	for _, state := range b.instruments {
+		for _, c := range state.candles {
+			if len(c.changed) == 0 {
+				continue
+			}
+			changed := make([]historical.HistoricalCandle, 0, len(c.changed))
+			for i := range c.done {
+				if c.changed[i] {
+					changed = append(changed, c.done[i])
+				}
+			}
+			c.changed = nil
+			updated = append(updated, SeriesCandles{
+				Series:  c.series,
+				Day:     state.day,
+				Candles: append([]historical.HistoricalCandle(nil), c.done...),
+				Changed: changed,
+			})
+		}
+	}
+	sort.Slice(updated, func(i, j int) bool {
+		if updated[i].Series.Symbol != updated[j].Series.Symbol {
+			return updated[i].Series.Symbol < updated[j].Series.Symbol
+		}
+		return updated[i].Series.Interval < updated[j].Series.Interval
+	})
+	return updated
+}
+
+// CandleSinks writes built candles to outputs opened per interval and day,
+// so a new day never replaces the files of the one before
+type CandleSinks struct {
+	// Open creates the outputs of an interval's day. Files replace a
+	// series' file on every write and receive its whole day; Merge adds to
+	// what is stored and receives only new or amended candles. Either may
+	// be nil.
+	Open func(interval, day string) (files, merge historical.Sink, err error)
+
+	days map[string]*daySinks
+}
+
+// daySinks are the open outputs of one interval's day
+type daySinks struct {
+	day          string
+	files, merge historical.Sink
+}
+
+// Write stores a series' update in the outputs of its day, closing the
+// previous day's outputs when a new day begins
+func (s *CandleSinks) Write(ctx context.Context, sc SeriesCandles) error {
+	interval := sc.Series.Interval
+	d := s.days[interval]
+	if d == nil || d.day != sc.Day {
+		if d != nil {
+			if err := d.close(); err != nil {
+				log.Printf("Error closing %s candle outputs of %s: %v", interval, d.day, err)
+			}
+			delete(s.days, interval)
+		}
+		var err error
+		if d, err = s.open(ctx, interval, sc.Day); err != nil {
+			return err
+		}
+		if s.days == nil {
+			s.days = make(map[string]*daySinks)
+		}
+		s.days[interval] = d
+	}
+
+	var errs []error
+	if d.files != nil {
+		errs = append(errs, d.files.Write(ctx, sc.Series, sc.Candles))
+	}
+	if d.merge != nil {
+		errs = append(errs, d.merge.Write(ctx, sc.Series, sc.Changed))
+	}
+	return errors.Join(errs...)
+}
+
+// open creates and opens the outputs of an interval's day
+func (s *CandleSinks) open(ctx context.Context, interval, day string) (*daySinks, error) {
+	files, merge, err := s.Open(interval, day)
+	if err != nil {
+		return nil, err
+	}
+	d := &daySinks{day: day}
+	if files != nil {
+		if err := files.Open(ctx); err != nil {
+			return nil, fmt.Errorf("failed to open %s candle output: %w", interval, err)
+		}
+		d.files = files
+	}
+	if merge != nil {
+		if err := merge.Open(ctx); err != nil {
+			d.close()
+			return nil, fmt.Errorf("failed to open %s candle output: %w", interval, err)
+		}
+		d.merge = merge
+	}
+	return d, nil
+}
+
+// close closes the outputs that were opened
+func (d *daySinks) close() error {
+	var errs []error
+	for _, sink := range []historical.Sink{d.files, d.merge} {
+		if sink != nil {
+			errs = append(errs, sink.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the outputs of every open day
+func (s *CandleSinks) Close() error {
+	var errs []error
+	for interval, d := range s.days {
+		errs = append(errs, d.close())
+		delete(s.days, interval)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,343 @@
+package ticks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/sabarim/kitedata/internal/instruments"
+	kiteticker "github.com/zerodha/gokiteconnect/v4/ticker"
+)
+
+// session is 09:15 IST on a trading day
+var session = time.Date(2024, 6, 14, 9, 15, 0, 0, instruments.IST)
+
+// testTick is a trade at an offset from the session open
+type testTick struct {
+	at     time.Duration
+	price  float64
+	volume uint32
+}
+
+// tick builds a tick of token 1 in the given mode
+func (tt testTick) tick(mode kiteticker.Mode) Tick {
+	at := session.Add(tt.at)
+	return Tick{
+		ReceivedAt:      at,
+		Timestamp:       &at,
+		InstrumentToken: 1,
+		Symbol:          "RELIANCE",
+		Exchange:        "NSE",
+		Mode:            string(mode),
+		LastPrice:       tt.price,
+		VolumeTraded:    tt.volume,
+	}
+}
+
+// ohlcv is a candle starting at an offset from the session open
+type ohlcv struct {
+	at                     time.Duration
+	open, high, low, close float64
+	volume                 int64
+}
+
+func (c ohlcv) candle() historical.HistoricalCandle {
+	return historical.HistoricalCandle{Timestamp: session.Add(c.at), Open: c.open, High: c.high, Low: c.low, Close: c.close, Volume: c.volume}
+}
+
+// newTestBuilder builds minute candles for RELIANCE
+func newTestBuilder(t *testing.T, intervals ...string) *CandleBuilder {
+	t.Helper()
+	if len(intervals) == 0 {
+		intervals = []string{"minute"}
+	}
+	b, err := NewCandleBuilder(intervals, []instruments.Instrument{{InstrumentToken: 1, TradingSymbol: "RELIANCE", Exchange: "NSE", TickSize: 0.05}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// updatedCandles returns the changed candles of each interval
+func updatedCandles(b *CandleBuilder) map[string][]historical.HistoricalCandle {
+	changed := make(map[string][]historical.HistoricalCandle)
+	for _, sc := range b.Updated() {
+		changed[sc.Series.Interval] = sc.Changed
+	}
+	return changed
+}
+
+func sameCandles(t *testing.T, what string, got []historical.HistoricalCandle, want []ohlcv) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d candles %+v, want %d", what, len(got), got, len(want))
+		return
+	}
+	for i, w := range want {
+		if g := got[i]; g != w.candle() {
+			t.Errorf("%s: candle %d is %+v, want %+v", what, i, g, w.candle())
+		}
+	}
+}
+
+func TestCandleBuilder(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  kiteticker.Mode
+		ticks []testTick
+		want  []ohlcv
+	}{
+		{
+			name: "first tick of the session counts the volume before it",
+			mode: kiteticker.ModeQuote,
+			ticks: []testTick{
+				{5 * time.Second, 100, 1000},
+				{30 * time.Second, 101, 1500},
+			},
+			want: []ohlcv{{0, 100, 101, 100, 101, 1500}},
+		},
+		{
+			name: "first tick after the first minute starts without volume",
+			mode: kiteticker.ModeQuote,
+			ticks: []testTick{
+				{5 * time.Minute, 100, 5000},
+				{5*time.Minute + 10*time.Second, 102, 5200},
+			},
+			want: []ohlcv{{5 * time.Minute, 100, 102, 100, 102, 200}},
+		},
+		{
+			name: "unchanged volume is a quote, not a trade",
+			mode: kiteticker.ModeQuote,
+			ticks: []testTick{
+				{5 * time.Second, 100, 100},
+				{10 * time.Second, 105, 100},
+				{20 * time.Second, 101, 150},
+			},
+			want: []ohlcv{{0, 100, 101, 100, 101, 150}},
+		},
+		{
+			name: "volume reset moves the price without volume",
+			mode: kiteticker.ModeQuote,
+			ticks: []testTick{
+				{5 * time.Second, 100, 1000},
+				{10 * time.Second, 99, 400},
+				{20 * time.Second, 98, 500},
+			},
+			want: []ohlcv{{0, 100, 100, 98, 98, 1100}},
+		},
+		{
+			name: "ltp ticks count without volume",
+			mode: kiteticker.ModeLTP,
+			ticks: []testTick{
+				{5 * time.Second, 100, 0},
+				{10 * time.Second, 100, 0},
+				{20 * time.Second, 97, 0},
+			},
+			want: []ohlcv{{0, 100, 100, 97, 97, 0}},
+		},
+		{
+			name: "candles split at interval boundaries",
+			mode: kiteticker.ModeFull,
+			ticks: []testTick{
+				{10 * time.Second, 100, 10},
+				{59 * time.Second, 101, 20},
+				{60 * time.Second, 102, 35},
+				{3*time.Minute + time.Second, 99, 40},
+			},
+			want: []ohlcv{
+				{0, 100, 101, 100, 101, 20},
+				{time.Minute, 102, 102, 102, 102, 15},
+				{3 * time.Minute, 99, 99, 99, 99, 5},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBuilder(t)
+			for _, tick := range tt.ticks {
+				b.Add(tick.tick(tt.mode))
+			}
+			b.Finish()
+			sameCandles(t, "candles", updatedCandles(b)["minute"], tt.want)
+		})
+	}
+}
+
+// TestCandleBuilderUpdates follows which candles each Updated reports as
+// ticks complete, amend and expire them
+func TestCandleBuilderUpdates(t *testing.T) {
+	b := newTestBuilder(t, "minute", "5minute")
+	add := func(tt testTick) { b.Add(tt.tick(kiteticker.ModeQuote)) }
+
+	add(testTick{10 * time.Second, 100, 100})
+	if updated := b.Updated(); len(updated) != 0 {
+		t.Fatalf("open candles were reported: %+v", updated)
+	}
+
+	// A tick of the next minute completes the first
+	add(testTick{70 * time.Second, 101, 200})
+	changed := updatedCandles(b)
+	sameCandles(t, "completed", changed["minute"], []ohlcv{{0, 100, 100, 100, 100, 100}})
+	if len(changed["5minute"]) != 0 {
+		t.Errorf("5minute candle completed early: %+v", changed["5minute"])
+	}
+
+	// A late trade amends the completed candle and only it is reported
+	add(testTick{50 * time.Second, 99, 250})
+	updated := b.Updated()
+	if len(updated) != 1 {
+		t.Fatalf("got %d updated series, want the minute one", len(updated))
+	}
+	sameCandles(t, "amended", updated[0].Changed, []ohlcv{{0, 100, 100, 99, 99, 150}})
+	sameCandles(t, "whole day", updated[0].Candles, []ohlcv{{0, 100, 100, 99, 99, 150}})
+	if updated[0].Day != "2024-06-14" {
+		t.Errorf("day %q, want 2024-06-14", updated[0].Day)
+	}
+
+	// Expire waits for the grace period after the interval ends
+	b.Expire(session.Add(2*time.Minute + barGrace - time.Second))
+	if updated := b.Updated(); len(updated) != 0 {
+		t.Fatalf("expired within the grace period: %+v", updated)
+	}
+	b.Expire(session.Add(2*time.Minute + barGrace))
+	sameCandles(t, "expired", updatedCandles(b)["minute"], []ohlcv{{time.Minute, 101, 101, 101, 101, 100}})
+
+	// A trade older than the latest candle that is not held is dropped,
+	// though its volume is no longer pending
+	add(testTick{-time.Minute, 90, 260})
+	if updated := b.Updated(); len(updated) != 0 {
+		t.Fatalf("old trade was applied: %+v", updated)
+	}
+
+	// Finish completes the open candles of every interval
+	add(testTick{3 * time.Minute, 102, 300})
+	b.Finish()
+	changed = updatedCandles(b)
+	sameCandles(t, "finished minute", changed["minute"], []ohlcv{{3 * time.Minute, 102, 102, 102, 102, 40}})
+	sameCandles(t, "finished 5minute", changed["5minute"], []ohlcv{{0, 100, 102, 99, 102, 290}})
+}
+
+// TestCandleBuilderResume rebuilds the day's candles from the ticks
+// recorded before a restart
+func TestCandleBuilderResume(t *testing.T) {
+	now := time.Now().In(instruments.IST)
+	dir := t.TempDir()
+	w := NewWriter(dir, instruments.IST)
+	for _, tt := range []testTick{{10 * time.Second, 100, 100}, {20 * time.Second, 102, 150}, {70 * time.Second, 101, 180}} {
+		tick := tt.tick(kiteticker.ModeQuote)
+		tick.ReceivedAt = now
+		if err := w.Write(tick); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := testTick{30 * time.Second, 5, 10}.tick(kiteticker.ModeQuote)
+	other.InstrumentToken, other.ReceivedAt = 2, now
+	if err := w.Write(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Capture{Writer: NewWriter(dir, instruments.IST), Candles: newTestBuilder(t)}
+	if err := c.resumeCandles(map[uint32]instruments.Instrument{1: {InstrumentToken: 1}}); err != nil {
+		t.Fatalf("resumeCandles: %v", err)
+	}
+	c.Candles.Finish()
+	updated := c.Candles.Updated()
+	if len(updated) != 1 || updated[0].Series.Symbol != "RELIANCE" {
+		t.Fatalf("got %+v, want only RELIANCE", updated)
+	}
+	sameCandles(t, "resumed", updated[0].Candles, []ohlcv{{0, 100, 102, 100, 102, 150}, {time.Minute, 101, 101, 101, 101, 30}})
+
+	// Without a tick file for the day there is nothing to resume
+	c = &Capture{Writer: NewWriter(t.TempDir(), instruments.IST), Candles: newTestBuilder(t)}
+	if err := c.resumeCandles(nil); err != nil {
+		t.Errorf("resumeCandles without a tick file: %v", err)
+	}
+}
+
+// recordingSink keeps every write for inspection
+type recordingSink struct {
+	opened, closed bool
+	writes         [][]historical.HistoricalCandle
+}
+
+func (r *recordingSink) Open(ctx context.Context) error { r.opened = true; return nil }
+
+func (r *recordingSink) Write(ctx context.Context, series historical.Series, candles []historical.HistoricalCandle) error {
+	r.writes = append(r.writes, candles)
+	return nil
+}
+
+func (r *recordingSink) Close() error { r.closed = true; return nil }
+
+// TestCandleSinksDays sends each day to its own outputs, the whole day to
+// the files and only the changes to the merging sinks
+func TestCandleSinksDays(t *testing.T) {
+	ctx := context.Background()
+	type outputs struct{ files, merge *recordingSink }
+	opened := make(map[string]outputs)
+	sinks := &CandleSinks{Open: func(interval, day string) (historical.Sink, historical.Sink, error) {
+		o := outputs{&recordingSink{}, &recordingSink{}}
+		opened[interval+"/"+day] = o
+		return o.files, o.merge, nil
+	}}
+
+	b := newTestBuilder(t)
+	write := func() {
+		for _, sc := range b.Updated() {
+			if err := sinks.Write(ctx, sc); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	add := func(day int, tt testTick) {
+		tick := tt.tick(kiteticker.ModeLTP)
+		at := tick.Timestamp.AddDate(0, 0, day)
+		tick.Timestamp, tick.ReceivedAt = &at, at
+		b.Add(tick)
+	}
+
+	add(0, testTick{0, 100, 0})
+	add(0, testTick{time.Minute, 101, 0})
+	add(0, testTick{2 * time.Minute, 102, 0})
+	write()
+	add(0, testTick{30 * time.Second, 99, 0})
+	add(0, testTick{3 * time.Minute, 103, 0})
+	write()
+	add(1, testTick{0, 200, 0})
+	add(1, testTick{time.Minute, 201, 0})
+	write()
+	if err := sinks.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	first, next := opened["minute/2024-06-14"], opened["minute/2024-06-15"]
+	if first.files == nil || next.files == nil || len(opened) != 2 {
+		t.Fatalf("opened outputs %v, want one per day", opened)
+	}
+	for day, o := range opened {
+		if !o.files.opened || !o.files.closed || !o.merge.opened || !o.merge.closed {
+			t.Errorf("%s outputs were not opened and closed", day)
+		}
+	}
+
+	if len(first.files.writes) != 2 || len(first.merge.writes) != 2 {
+		t.Fatalf("first day got %d file and %d merge writes, want 2 each", len(first.files.writes), len(first.merge.writes))
+	}
+	sameCandles(t, "first day files", first.files.writes[1], []ohlcv{
+		{0, 100, 100, 99, 99, 0},
+		{time.Minute, 101, 101, 101, 101, 0},
+		{2 * time.Minute, 102, 102, 102, 102, 0},
+	})
+	sameCandles(t, "first day merge", first.merge.writes[1], []ohlcv{
+		{0, 100, 100, 99, 99, 0},
+		{2 * time.Minute, 102, 102, 102, 102, 0},
+	})
+	if len(next.files.writes) != 1 || len(next.files.writes[0]) != 1 || next.files.writes[0][0].Open != 200 {
+		t.Errorf("next day files got %+v, want only its own candle", next.files.writes)
+	}
+}
@@ -0,0 +1,255 @@
+package ticks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/instruments"
+	"github.com/zerodha/gokiteconnect/v4/models"
+	kiteticker "github.com/zerodha/gokiteconnect/v4/ticker"
+)
+
+// MaxInstruments is how many instruments Kite streams on one connection
+const MaxInstruments = 3000
+
+// tickBuffer is how many ticks may wait for the writer before the ticker's
+// read loop blocks
+const tickBuffer = 4096
+
+// flushInterval is how often buffered ticks are written to disk
+const flushInterval = time.Second
+
+// statsInterval is how often the number of captured ticks is logged
+const statsInterval = time.Minute
+
+// candleInterval is how often completed candles are written to the sink
+const candleInterval = 5 * time.Second
+
+// Capture streams ticks for a set of instruments into a Writer
+type Capture struct {
+	APIKey      string
+	AccessToken string
+	// RootURL is the ticker endpoint; empty means Kite's
+	RootURL     string
+	Mode        kiteticker.Mode
+	Instruments []instruments.Instrument
+	Writer      *Writer
+	// Candles, when set, builds candles from the ticks, which are written
+	// to Sinks as they complete. The day's ticks already recorded are
+	// replayed into it first, so a restarted capture keeps the day's candles.
+	Candles *CandleBuilder
+	Sinks   *CandleSinks
+}
+
+// Run connects, subscribes and writes ticks until ctx is cancelled. The
+// ticker reconnects on its own after dropped connections and subscribes to
+// the instruments again. Run only fails when subscribing fails, the ticker
+// gives up reconnecting or ticks cannot be written.
+func (c *Capture) Run(ctx context.Context) error {
+	if len(c.Instruments) == 0 {
+		return fmt.Errorf("no instruments to subscribe to")
+	}
+	if len(c.Instruments) > MaxInstruments {
+		return fmt.Errorf("%d instruments selected, but Kite streams at most %d per connection", len(c.Instruments), MaxInstruments)
+	}
+
+	byToken := make(map[uint32]instruments.Instrument, len(c.Instruments))
+	tokens := make([]uint32, 0, len(c.Instruments))
+	for _, inst := range c.Instruments {
+		token := uint32(inst.InstrumentToken)
+		if _, ok := byToken[token]; !ok {
+			tokens = append(tokens, token)
+		}
+		byToken[token] = inst
+	}
+
+	if c.Candles != nil {
+		if err := c.resumeCandles(byToken); err != nil {
+			return err
+		}
+		defer func() {
+			if err := c.Sinks.Close(); err != nil {
+				log.Printf("Error closing candle output: %v", err)
+			}
+		}()
+	}
+
+	t := kiteticker.New(c.APIKey, c.AccessToken)
+	if c.RootURL != "" {
+		u, err := url.Parse(c.RootURL)
+		if err != nil {
+			return fmt.Errorf("invalid ticker URL %q: %w", c.RootURL, err)
+		}
+		t.SetRootURL(*u)
+	}
+
+	ticks := make(chan Tick, tickBuffer)
+	gaveUp := make(chan int, 1)
+	subscribeFailed := make(chan error, 1)
+	serveCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// The ticker subscribes again by itself after reconnecting, so only the
+	// first connect needs to subscribe
+	subscribed := false
+	t.OnConnect(func() {
+		if subscribed {
+			log.Printf("Reconnected to ticker")
+			return
+		}
+		log.Printf("Connected to ticker, subscribing to %d instruments in %s mode", len(tokens), c.Mode)
+		err := t.Subscribe(tokens)
+		if err != nil {
+			err = fmt.Errorf("failed to subscribe: %w", err)
+		} else if err = t.SetMode(c.Mode, tokens); err != nil {
+			err = fmt.Errorf("failed to set %s mode: %w", c.Mode, err)
+		}
+		if err != nil {
+			// Without a subscription the connection would stay idle forever
+			select {
+			case subscribeFailed <- err:
+			default:
+			}
+			return
+		}
+		subscribed = true
+	})
+	t.OnError(func(err error) {
+		if serveCtx.Err() == nil {
+			log.Printf("Ticker error: %v", err)
+		}
+	})
+	t.OnClose(func(code int, reason string) {
+		log.Printf("Ticker connection closed: %d %s", code, reason)
+	})
+	t.OnReconnect(func(attempt int, delay time.Duration) {
+		log.Printf("Reconnecting to ticker in %s (attempt %d)", delay, attempt)
+	})
+	t.OnNoReconnect(func(attempt int) {
+		gaveUp <- attempt
+	})
+	t.OnTick(func(kt models.Tick) {
+		inst := byToken[kt.InstrumentToken]
+		tick := FromKite(kt, inst.TradingSymbol, inst.Exchange, time.Now().In(c.Writer.Location))
+		select {
+		case ticks <- tick:
+		case <-serveCtx.Done():
+		}
+	})
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		t.ServeWithContext(serveCtx)
+	}()
+
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	stats := time.NewTicker(statsInterval)
+	defer stats.Stop()
+	candles := time.NewTicker(candleInterval)
+	defer candles.Stop()
+
+	var err error
+	count := 0
+loop:
+	for {
+		select {
+		case tick := <-ticks:
+			if err = c.Writer.Write(tick); err != nil {
+				break loop
+			}
+			if c.Candles != nil {
+				c.Candles.Add(tick)
+			}
+			count++
+		case <-flush.C:
+			if err = c.Writer.Flush(); err != nil {
+				break loop
+			}
+		case now := <-candles.C:
+			if c.Candles != nil {
+				c.Candles.Expire(now)
+				c.writeCandles(ctx)
+			}
+		case <-stats.C:
+			log.Printf("Captured %d ticks in the last %s", count, statsInterval)
+			count = 0
+		case attempt := <-gaveUp:
+			err = fmt.Errorf("ticker gave up after %d reconnect attempts", attempt)
+			break loop
+		case err = <-subscribeFailed:
+			break loop
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	// Stop the ticker, closing the connection so its read loop returns
+	stop()
+	if t.Conn != nil {
+		t.Close()
+	}
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		log.Printf("Ticker did not stop in time")
+	}
+
+	// Keep the ticks already received
+	for len(ticks) > 0 {
+		tick := <-ticks
+		if err == nil {
+			err = c.Writer.Write(tick)
+		}
+		if c.Candles != nil {
+			c.Candles.Add(tick)
+		}
+	}
+	if c.Candles != nil {
+		// The candles still open end with the capture
+		c.Candles.Finish()
+		c.writeCandles(context.WithoutCancel(ctx))
+	}
+	if closeErr := c.Writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// resumeCandles rebuilds the candles of the day from the ticks recorded
+// before the capture started
+func (c *Capture) resumeCandles(byToken map[uint32]instruments.Instrument) error {
+	filename := Path(c.Writer.Dir, time.Now().In(c.Writer.Location))
+	count := 0
+	err := ReadFile(filename, func(tick Tick) error {
+		if _, ok := byToken[tick.InstrumentToken]; ok {
+			c.Candles.Add(tick)
+			count++
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Rebuilt the day's candles from %d recorded ticks", count)
+	return nil
+}
+
+// writeCandles saves every series that gained candles. Failures are logged;
+// they must not stop the capture.
+func (c *Capture) writeCandles(ctx context.Context) {
+	for _, sc := range c.Candles.Updated() {
+		if err := c.Sinks.Write(ctx, sc); err != nil {
+			log.Printf("Error saving %s candles for %s: %v", sc.Series.Interval, sc.Series.Symbol, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package ticks
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/instruments"
+	"github.com/zerodha/gokiteconnect/v4/models"
+	kiteticker "github.com/zerodha/gokiteconnect/v4/ticker"
+)
+
+// TestCaptureFromReplay captures a recorded day served by Replay, which
+// speaks Kite's ticker protocol, and checks the ticks arrive unchanged
+func TestCaptureFromReplay(t *testing.T) {
+	loc := instruments.IST
+	recordedDir, capturedDir := t.TempDir(), t.TempDir()
+
+	// RELIANCE on NSE, whose prices travel in paise
+	const token = 738561
+	day := time.Date(2024, 6, 14, 0, 0, 0, 0, loc)
+	recorded := NewWriter(recordedDir, loc)
+	var want []Tick
+	for i := 0; i < 3; i++ {
+		at := day.Add(9*time.Hour + 15*time.Minute + time.Duration(i)*time.Second)
+		tick := Tick{
+			ReceivedAt:      at,
+			Timestamp:       &at,
+			InstrumentToken: token,
+			Symbol:          "RELIANCE",
+			Exchange:        "NSE",
+			Mode:            string(kiteticker.ModeFull),
+			Tradable:        true,
+			LastPrice:       2900.05 + float64(i),
+			VolumeTraded:    uint32(1000 * (i + 1)),
+			OHLC:            &models.OHLC{Open: 2890, High: 2905.5, Low: 2885.25, Close: 2880},
+		}
+		if err := recorded.Write(tick); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, tick)
+	}
+	if err := recorded.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := &Replay{Dir: recordedDir, Location: loc, From: day, To: day.AddDate(0, 0, 1).Add(-time.Nanosecond)}
+	srv := httptest.NewServer(replay)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	capture := &Capture{
+		APIKey:      "key",
+		AccessToken: "token",
+		RootURL:     "ws" + strings.TrimPrefix(srv.URL, "http"),
+		Mode:        kiteticker.ModeFull,
+		Instruments: []instruments.Instrument{{InstrumentToken: token, TradingSymbol: "RELIANCE", Exchange: "NSE"}},
+		Writer:      NewWriter(capturedDir, loc),
+	}
+	done := make(chan error, 1)
+	go func() { done <- capture.Run(ctx) }()
+
+	// Captured ticks are flushed every second into the file of the day they arrived
+	var got []Tick
+	deadline := time.Now().Add(10 * time.Second)
+	for len(got) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		files, _ := filepath.Glob(filepath.Join(capturedDir, "ticks_*.jsonl"))
+		got = nil
+		for _, file := range files {
+			err := ReadFile(file, func(tick Tick) error {
+				got = append(got, tick)
+				return nil
+			})
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("captured %d ticks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.InstrumentToken != w.InstrumentToken || g.Symbol != w.Symbol || g.Exchange != w.Exchange || g.Mode != w.Mode {
+			t.Errorf("tick %d: got %d %s %s %s, want %d %s %s %s", i,
+				g.InstrumentToken, g.Symbol, g.Exchange, g.Mode, w.InstrumentToken, w.Symbol, w.Exchange, w.Mode)
+		}
+		if g.LastPrice != w.LastPrice || g.VolumeTraded != w.VolumeTraded {
+			t.Errorf("tick %d: got price %v volume %d, want %v %d", i, g.LastPrice, g.VolumeTraded, w.LastPrice, w.VolumeTraded)
+		}
+		if g.OHLC == nil || *g.OHLC != *w.OHLC {
+			t.Errorf("tick %d: got OHLC %+v, want %+v", i, g.OHLC, w.OHLC)
+		}
+		if g.Timestamp == nil || !g.Timestamp.Equal(*w.Timestamp) {
+			t.Errorf("tick %d: got exchange timestamp %v, want %v", i, g.Timestamp, w.Timestamp)
+		}
+	}
+}
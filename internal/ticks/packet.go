@@ -0,0 +1,152 @@
+package ticks
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	kiteticker "github.com/zerodha/gokiteconnect/v4/ticker"
+)
+
+// Packet lengths of Kite's binary ticker format
+const (
+	ltpPacketLength        = 8
+	indexQuotePacketLength = 28
+	indexFullPacketLength  = 32
+	quotePacketLength      = 44
+	fullPacketLength       = 184
+)
+
+// heartbeat is the one byte message Kite sends when there are no ticks, so
+// clients can tell a quiet market from a dead connection
+var heartbeat = []byte{0}
+
+// modeRank orders the modes by how many fields they carry
+var modeRank = map[string]int{
+	string(kiteticker.ModeLTP):   1,
+	string(kiteticker.ModeQuote): 2,
+	string(kiteticker.ModeFull):  3,
+}
+
+// EncodePacket writes a tick as a Kite ticker packet in mode. A tick stored
+// in a smaller mode is written in its own mode, since the fields the larger
+// one needs were never received.
+func EncodePacket(tick Tick, mode kiteticker.Mode) []byte {
+	if modeRank[tick.Mode] < modeRank[string(mode)] {
+		mode = kiteticker.Mode(tick.Mode)
+	}
+	segment := tick.InstrumentToken & 0xFF
+	price := func(v float64) uint32 {
+		return uint32(int64(math.Round(v * priceDivisor(segment))))
+	}
+	var ohlc struct{ open, high, low, close float64 }
+	if tick.OHLC != nil {
+		ohlc.open, ohlc.high, ohlc.low, ohlc.close = tick.OHLC.Open, tick.OHLC.High, tick.OHLC.Low, tick.OHLC.Close
+	}
+
+	if mode != kiteticker.ModeQuote && mode != kiteticker.ModeFull {
+		b := make([]byte, ltpPacketLength)
+		binary.BigEndian.PutUint32(b[0:4], tick.InstrumentToken)
+		binary.BigEndian.PutUint32(b[4:8], price(tick.LastPrice))
+		return b
+	}
+
+	// Indices have their own, shorter packets without volume or depth
+	if segment == kiteticker.Indices {
+		length := indexQuotePacketLength
+		if mode == kiteticker.ModeFull {
+			length = indexFullPacketLength
+		}
+		b := make([]byte, length)
+		binary.BigEndian.PutUint32(b[0:4], tick.InstrumentToken)
+		binary.BigEndian.PutUint32(b[4:8], price(tick.LastPrice))
+		binary.BigEndian.PutUint32(b[8:12], price(ohlc.high))
+		binary.BigEndian.PutUint32(b[12:16], price(ohlc.low))
+		binary.BigEndian.PutUint32(b[16:20], price(ohlc.open))
+		binary.BigEndian.PutUint32(b[20:24], price(ohlc.close))
+		binary.BigEndian.PutUint32(b[24:28], price(tick.LastPrice-ohlc.close))
+		if mode == kiteticker.ModeFull {
+			binary.BigEndian.PutUint32(b[28:32], unixTime(tick.Timestamp))
+		}
+		return b
+	}
+
+	length := quotePacketLength
+	if mode == kiteticker.ModeFull {
+		length = fullPacketLength
+	}
+	b := make([]byte, length)
+	binary.BigEndian.PutUint32(b[0:4], tick.InstrumentToken)
+	binary.BigEndian.PutUint32(b[4:8], price(tick.LastPrice))
+	binary.BigEndian.PutUint32(b[8:12], tick.LastTradedQuantity)
+	binary.BigEndian.PutUint32(b[12:16], price(tick.AverageTradePrice))
+	binary.BigEndian.PutUint32(b[16:20], tick.VolumeTraded)
+	binary.BigEndian.PutUint32(b[20:24], tick.TotalBuyQuantity)
+	binary.BigEndian.PutUint32(b[24:28], tick.TotalSellQuantity)
+	binary.BigEndian.PutUint32(b[28:32], price(ohlc.open))
+	binary.BigEndian.PutUint32(b[32:36], price(ohlc.high))
+	binary.BigEndian.PutUint32(b[36:40], price(ohlc.low))
+	binary.BigEndian.PutUint32(b[40:44], price(ohlc.close))
+	if mode != kiteticker.ModeFull {
+		return b
+	}
+
+	binary.BigEndian.PutUint32(b[44:48], unixTime(tick.LastTradeTime))
+	binary.BigEndian.PutUint32(b[48:52], tick.OI)
+	binary.BigEndian.PutUint32(b[52:56], tick.OIDayHigh)
+	binary.BigEndian.PutUint32(b[56:60], tick.OIDayLow)
+	binary.BigEndian.PutUint32(b[60:64], unixTime(tick.Timestamp))
+	if tick.Depth != nil {
+		// Five buy levels from byte 64, then five sell levels, 12 bytes each
+		for i := range tick.Depth.Buy {
+			buy, sell := tick.Depth.Buy[i], tick.Depth.Sell[i]
+			pos := 64 + i*12
+			binary.BigEndian.PutUint32(b[pos:pos+4], buy.Quantity)
+			binary.BigEndian.PutUint32(b[pos+4:pos+8], price(buy.Price))
+			binary.BigEndian.PutUint16(b[pos+8:pos+10], uint16(buy.Orders))
+			pos += 60
+			binary.BigEndian.PutUint32(b[pos:pos+4], sell.Quantity)
+			binary.BigEndian.PutUint32(b[pos+4:pos+8], price(sell.Price))
+			binary.BigEndian.PutUint16(b[pos+8:pos+10], uint16(sell.Orders))
+		}
+	}
+	return b
+}
+
+// EncodeMessage frames packets into one binary message: the packet count,
+// then each packet preceded by its length
+func EncodeMessage(packets [][]byte) []byte {
+	size := 2
+	for _, p := range packets {
+		size += 2 + len(p)
+	}
+	b := make([]byte, 2, size)
+	binary.BigEndian.PutUint16(b, uint16(len(packets)))
+	for _, p := range packets {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(p)))
+		b = append(b, p...)
+	}
+	return b
+}
+
+// priceDivisor is how Kite scales prices to integers in a segment: currency
+// derivatives carry more decimals than the paise used everywhere else
+func priceDivisor(segment uint32) float64 {
+	switch segment {
+	case kiteticker.NseCD:
+		return 10000000
+	case kiteticker.BseCD:
+		return 10000
+	default:
+		return 100
+	}
+}
+
+// unixTime writes a timestamp as Kite's seconds since the epoch, zero when
+// the tick has none
+func unixTime(t *time.Time) uint32 {
+	if t == nil {
+		return 0
+	}
+	return uint32(t.Unix())
+}
@@ -0,0 +1,140 @@
+package ticks
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/historical"
+)
+
+// Fields reported by Reconcile
+const (
+	FieldMissing = "missing" // a candle only Kite has
+	FieldExtra   = "extra"   // a candle only built from ticks
+	FieldOpen    = "open"
+	FieldHigh    = "high"
+	FieldLow     = "low"
+	FieldClose   = "close"
+	FieldVolume  = "volume"
+)
+
+// ReconcileFields lists the fields in report order
+var ReconcileFields = []string{FieldMissing, FieldExtra, FieldOpen, FieldHigh, FieldLow, FieldClose, FieldVolume}
+
+// Difference is one field that differs between a built and a fetched
+// candle. Missing and extra candles report their close price on the side
+// that has them.
+type Difference struct {
+	Timestamp time.Time
+	Field     string
+	Built     float64
+	Kite      float64
+}
+
+// Reconciliation compares one series' candles built from ticks with the
+// ones Kite's historical API returns for the same day
+type Reconciliation struct {
+	Series      historical.Series
+	Built       int
+	Fetched     int
+	Differences []Difference
+}
+
+// Count returns how many differences concern field
+func (r Reconciliation) Count(field string) int {
+	n := 0
+	for _, d := range r.Differences {
+		if d.Field == field {
+			n++
+		}
+	}
+	return n
+}
+
+// Reconcile compares built candles with fetched ones. Both must be sorted
+// by time. Prices are equal when they are within half a tick.
+func Reconcile(series historical.Series, built, fetched []historical.HistoricalCandle) Reconciliation {
+	r := Reconciliation{Series: series, Built: len(built), Fetched: len(fetched)}
+	tolerance := series.TickSize / 2
+	if tolerance <= 0 {
+		tolerance = 1e-6
+	}
+
+	i, j := 0, 0
+	for i < len(built) || j < len(fetched) {
+		switch {
+		case j == len(fetched) || (i < len(built) && built[i].Timestamp.Before(fetched[j].Timestamp)):
+			r.Differences = append(r.Differences, Difference{Timestamp: built[i].Timestamp, Field: FieldExtra, Built: built[i].Close})
+			i++
+		case i == len(built) || fetched[j].Timestamp.Before(built[i].Timestamp):
+			r.Differences = append(r.Differences, Difference{Timestamp: fetched[j].Timestamp, Field: FieldMissing, Kite: fetched[j].Close})
+			j++
+		default:
+			b, k := built[i], fetched[j]
+			prices := []struct {
+				field       string
+				built, kite float64
+			}{
+				{FieldOpen, b.Open, k.Open},
+				{FieldHigh, b.High, k.High},
+				{FieldLow, b.Low, k.Low},
+				{FieldClose, b.Close, k.Close},
+			}
+			for _, p := range prices {
+				if math.Abs(p.built-p.kite) > tolerance {
+					r.Differences = append(r.Differences, Difference{Timestamp: b.Timestamp, Field: p.field, Built: p.built, Kite: p.kite})
+				}
+			}
+			if b.Volume != k.Volume {
+				r.Differences = append(r.Differences, Difference{Timestamp: b.Timestamp, Field: FieldVolume, Built: float64(b.Volume), Kite: float64(k.Volume)})
+			}
+			i++
+			j++
+		}
+	}
+	return r
+}
+
+// ReportPath returns the reconciliation report for a day's ticks
+func ReportPath(dir string, day time.Time) string {
+	return filepath.Join(dir, "reconcile_"+day.Format("2006-01-02")+".csv")
+}
+
+// WriteReport writes every difference as a CSV row, with the timestamps in loc
+func WriteReport(w io.Writer, results []Reconciliation, loc *time.Location) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"symbol", "interval", "timestamp", "field", "built", "kite", "difference"}); err != nil {
+		return err
+	}
+	for _, r := range results {
+		for _, d := range r.Differences {
+			built, kite, diff := formatValue(d.Built), formatValue(d.Kite), formatValue(d.Built-d.Kite)
+			switch d.Field {
+			case FieldMissing:
+				built, diff = "", ""
+			case FieldExtra:
+				kite, diff = "", ""
+			}
+			row := []string{r.Series.Symbol, r.Series.Interval, d.Timestamp.In(loc).Format(time.RFC3339), d.Field, built, kite, diff}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// formatValue writes a price or volume without trailing zeros, rounding
+// away float noise from subtracting prices
+func formatValue(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1e8)/1e8, 'f', -1, 64)
+}
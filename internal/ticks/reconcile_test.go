@@ -0,0 +1,98 @@
+package ticks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/historical"
+)
+
+func TestReconcile(t *testing.T) {
+	series := historical.Series{Symbol: "RELIANCE", Interval: "minute", TickSize: 0.05}
+	base := []ohlcv{
+		{0, 100, 101, 99, 100.5, 1000},
+		{time.Minute, 100.5, 102, 100, 101, 800},
+	}
+	candles := func(list ...ohlcv) []historical.HistoricalCandle {
+		var out []historical.HistoricalCandle
+		for _, c := range list {
+			out = append(out, c.candle())
+		}
+		return out
+	}
+
+	tests := []struct {
+		name    string
+		built   []ohlcv
+		fetched []ohlcv
+		want    []Difference
+	}{
+		{
+			name:    "identical",
+			built:   base,
+			fetched: base,
+		},
+		{
+			name:    "prices within half a tick",
+			built:   []ohlcv{{0, 100.02, 101, 99, 100.5, 1000}},
+			fetched: []ohlcv{{0, 100, 101.01, 99, 100.5, 1000}},
+		},
+		{
+			name:    "prices and volume that differ",
+			built:   []ohlcv{{0, 100, 101.05, 99, 100.5, 990}},
+			fetched: []ohlcv{{0, 100, 101, 99, 100.45, 1000}},
+			want: []Difference{
+				{session, FieldHigh, 101.05, 101},
+				{session, FieldClose, 100.5, 100.45},
+				{session, FieldVolume, 990, 1000},
+			},
+		},
+		{
+			name:    "candle only Kite has",
+			built:   base[1:],
+			fetched: base,
+			want:    []Difference{{session, FieldMissing, 0, 100.5}},
+		},
+		{
+			name:    "candle only built from ticks",
+			built:   append(append([]ohlcv(nil), base...), ohlcv{2 * time.Minute, 101, 101, 101, 101, 5}),
+			fetched: base,
+			want:    []Difference{{session.Add(2 * time.Minute), FieldExtra, 101, 0}},
+		},
+		{
+			name:    "no candles fetched",
+			built:   base[:1],
+			fetched: nil,
+			want:    []Difference{{session, FieldExtra, 100.5, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Reconcile(series, candles(tt.built...), candles(tt.fetched...))
+			if r.Built != len(tt.built) || r.Fetched != len(tt.fetched) {
+				t.Errorf("counted %d built and %d fetched, want %d and %d", r.Built, r.Fetched, len(tt.built), len(tt.fetched))
+			}
+			if len(r.Differences) != len(tt.want) {
+				t.Fatalf("got differences %+v, want %+v", r.Differences, tt.want)
+			}
+			for i, want := range tt.want {
+				got := r.Differences[i]
+				if !got.Timestamp.Equal(want.Timestamp) || got.Field != want.Field || got.Built != want.Built || got.Kite != want.Kite {
+					t.Errorf("difference %d is %+v, want %+v", i, got, want)
+				}
+			}
+			for _, field := range ReconcileFields {
+				want := 0
+				for _, d := range tt.want {
+					if d.Field == field {
+						want++
+					}
+				}
+				if got := r.Count(field); got != want {
+					t.Errorf("Count(%s) = %d, want %d", field, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package ticks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	kiteticker "github.com/zerodha/gokiteconnect/v4/ticker"
+)
+
+// heartbeatInterval is how often a replay sends a heartbeat while no ticks
+// are due. Kite's client reconnects after five quiet seconds.
+const heartbeatInterval = time.Second
+
+// writeTimeout bounds how long one message may take to reach a client
+const writeTimeout = 10 * time.Second
+
+// settleDelay is how long a replay waits after the first subscription, so
+// the mode requests clients send right after subscribing apply to the first
+// ticks too
+const settleDelay = 200 * time.Millisecond
+
+// maxPackets is how many ticks a replay puts into one message
+const maxPackets = 1000
+
+// errReplayEnd stops reading a tick file once its ticks are past the range
+var errReplayEnd = errors.New("end of replay range")
+
+// Replay serves stored ticks over a WebSocket that speaks Kite's ticker
+// protocol, so clients written for the live ticker run against recorded
+// days. Every connection replays the range from its start once it first
+// subscribes, and only receives the instruments it subscribed to.
+type Replay struct {
+	Dir string
+	// Location decides which daily files the range covers
+	Location *time.Location
+	From, To time.Time
+	// Speed multiplies the recorded pace; 0 sends ticks as fast as the
+	// client reads them
+	Speed float64
+}
+
+// ParseSpeed reads a replay speed such as 1x, 10x or 0.5; max replays as
+// fast as possible
+func ParseSpeed(value string) (float64, error) {
+	if value == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("invalid replay speed %q (use e.g. 1x, 10x or max)", value)
+	}
+	return speed, nil
+}
+
+// Files returns the tick files of the days in the range that exist
+func (r *Replay) Files() []string {
+	var files []string
+	from, to := r.From.In(r.Location), r.To.In(r.Location)
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, r.Location)
+	for ; !day.After(to); day = day.AddDate(0, 0, 1) {
+		filename := Path(r.Dir, day)
+		if _, err := os.Stat(filename); err == nil {
+			files = append(files, filename)
+		}
+	}
+	return files
+}
+
+// ListenAndServe accepts ticker connections on addr until ctx is cancelled
+func (r *Replay) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("Replaying ticks on ws://%s", addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Hijacked WebSocket connections are not waited for; they end with ctx
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down replay server: %w", err)
+	}
+	log.Println("Replay server stopped")
+	return nil
+}
+
+// upgrader accepts connections from any origin; Kite's clients send
+// api_key and access_token, which a replay does not check
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// ServeHTTP upgrades a ticker connection and replays the range on it
+func (r *Replay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// The upgrader has already answered the request
+		return
+	}
+	defer conn.Close()
+
+	client := req.RemoteAddr
+	log.Printf("Replay client %s connected", client)
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+
+	subs := newSubscriptions()
+	go func() {
+		defer cancel()
+		subs.read(conn)
+	}()
+
+	p := &player{replay: r, conn: conn, subs: subs}
+	err = p.run(ctx)
+	switch {
+	case err == nil, ctx.Err() != nil:
+		log.Printf("Replay client %s disconnected", client)
+	default:
+		log.Printf("Replay to %s failed: %v", client, err)
+	}
+}
+
+// subscriptions tracks the instruments and modes a client asked for
+type subscriptions struct {
+	mu    sync.Mutex
+	modes map[uint32]kiteticker.Mode
+	// first is closed by the first subscription
+	first     chan struct{}
+	firstOnce sync.Once
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{modes: make(map[uint32]kiteticker.Mode), first: make(chan struct{})}
+}
+
+// mode returns the mode token is subscribed in
+func (s *subscriptions) mode(token uint32) (kiteticker.Mode, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mode, ok := s.modes[token]
+	return mode, ok
+}
+
+// read applies the client's subscribe, unsubscribe and mode requests until
+// the connection fails or is closed
+func (s *subscriptions) read(conn *websocket.Conn) {
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req struct {
+			Action string          `json:"a"`
+			Value  json.RawMessage `json:"v"`
+		}
+		if err := json.Unmarshal(msg, &req); err != nil {
+			log.Printf("Ignoring malformed ticker request: %s", msg)
+			continue
+		}
+		if err := s.apply(req.Action, req.Value); err != nil {
+			log.Printf("Ignoring ticker request %s: %v", msg, err)
+		}
+	}
+}
+
+// apply changes the subscriptions for one request. New subscriptions start
+// in quote mode, as on Kite, and modes only apply to subscribed tokens.
+func (s *subscriptions) apply(action string, value json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch action {
+	case "subscribe":
+		var tokens []uint32
+		if err := json.Unmarshal(value, &tokens); err != nil {
+			return err
+		}
+		for _, token := range tokens {
+			if _, ok := s.modes[token]; !ok {
+				s.modes[token] = kiteticker.ModeQuote
+			}
+		}
+		if len(tokens) > 0 {
+			s.firstOnce.Do(func() { close(s.first) })
+		}
+	case "unsubscribe":
+		var tokens []uint32
+		if err := json.Unmarshal(value, &tokens); err != nil {
+			return err
+		}
+		for _, token := range tokens {
+			delete(s.modes, token)
+		}
+	case "mode":
+		var args []json.RawMessage
+		if err := json.Unmarshal(value, &args); err != nil || len(args) != 2 {
+			return fmt.Errorf("mode needs a mode and a list of tokens")
+		}
+		var name string
+		var tokens []uint32
+		if err := json.Unmarshal(args[0], &name); err != nil {
+			return err
+		}
+		mode, err := ParseMode(name)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(args[1], &tokens); err != nil {
+			return err
+		}
+		for _, token := range tokens {
+			if _, ok := s.modes[token]; ok {
+				s.modes[token] = mode
+			}
+		}
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	return nil
+}
+
+// player replays the range on one connection
+type player struct {
+	replay *Replay
+	conn   *websocket.Conn
+	subs   *subscriptions
+
+	packets [][]byte
+	// start and base pair the wall clock with the recorded time of the
+	// first tick of the current day
+	start, base time.Time
+	heartbeat   *time.Ticker
+	sent        bool
+}
+
+// run waits for the first subscription, then sends every tick in the range
+// at the replay's pace. Afterwards the connection is kept alive with
+// heartbeats until the client leaves.
+func (p *player) run(ctx context.Context) error {
+	p.heartbeat = time.NewTicker(heartbeatInterval)
+	defer p.heartbeat.Stop()
+
+	if err := p.wait(ctx, p.subs.first); err != nil {
+		return err
+	}
+	settled, cancel := context.WithTimeout(ctx, settleDelay)
+	defer cancel()
+	if err := p.wait(ctx, settled.Done()); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sent := 0
+	for _, filename := range p.replay.Files() {
+		// Each day's clock starts at its first tick, so the nights between
+		// days are skipped
+		p.base = time.Time{}
+		err := ReadFile(filename, func(tick Tick) error {
+			if tick.ReceivedAt.Before(p.replay.From) {
+				return nil
+			}
+			if tick.ReceivedAt.After(p.replay.To) {
+				return errReplayEnd
+			}
+			if err := p.pace(ctx, tick.ReceivedAt); err != nil {
+				return err
+			}
+			mode, ok := p.subs.mode(tick.InstrumentToken)
+			if !ok {
+				return nil
+			}
+			p.packets = append(p.packets, EncodePacket(tick, mode))
+			sent++
+			if len(p.packets) >= maxPackets {
+				return p.flush()
+			}
+			return nil
+		})
+		if errors.Is(err, errReplayEnd) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := p.flush(); err != nil {
+		return err
+	}
+	log.Printf("Replayed %d ticks to %s", sent, p.conn.RemoteAddr())
+
+	// Clients that listen for text messages learn that the replay is over
+	done, _ := json.Marshal(map[string]string{"type": "message", "data": "replay finished"})
+	if err := p.write(websocket.TextMessage, done); err != nil {
+		return err
+	}
+	return p.wait(ctx, nil)
+}
+
+// pace waits until a tick recorded at received is due
+func (p *player) pace(ctx context.Context, received time.Time) error {
+	if p.base.IsZero() {
+		p.start, p.base = time.Now(), received
+	}
+	if p.replay.Speed == 0 {
+		return nil
+	}
+	due := p.start.Add(time.Duration(float64(received.Sub(p.base)) / p.replay.Speed))
+	if !time.Now().Before(due) {
+		return nil
+	}
+	if err := p.flush(); err != nil {
+		return err
+	}
+	dueCtx, cancel := context.WithDeadline(ctx, due)
+	defer cancel()
+	if err := p.wait(ctx, dueCtx.Done()); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// wait sends heartbeats until done fires or ctx ends; a nil done waits for ctx
+func (p *player) wait(ctx context.Context, done <-chan struct{}) error {
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-p.heartbeat.C:
+			// Only quiet seconds need a heartbeat
+			if !p.sent {
+				if err := p.write(websocket.BinaryMessage, heartbeat); err != nil {
+					return err
+				}
+			}
+			p.sent = false
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// flush sends the buffered packets as one message
+func (p *player) flush() error {
+	if len(p.packets) == 0 {
+		return nil
+	}
+	err := p.write(websocket.BinaryMessage, EncodeMessage(p.packets))
+	p.packets = p.packets[:0]
+	p.sent = true
+	return err
+}
+
+func (p *player) write(messageType int, data []byte) error {
+	p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := p.conn.WriteMessage(messageType, data); err != nil {
+		return fmt.Errorf("failed to send: %w", err)
+	}
+	return nil
+}
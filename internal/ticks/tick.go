@@ -0,0 +1,86 @@
+// Package ticks captures live ticks from the Kite WebSocket ticker and
+// stores them in daily JSON Lines files
+package ticks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zerodha/gokiteconnect/v4/models"
+	kiteticker "github.com/zerodha/gokiteconnect/v4/ticker"
+)
+
+// Tick is one stored tick. Fields a mode does not carry are left zero:
+// ltp ticks only have a last price, quote ticks add volume and OHLC, and
+// full ticks add exchange timestamps, open interest and market depth.
+type Tick struct {
+	// ReceivedAt is when the tick arrived; only full ticks have another timestamp
+	ReceivedAt         time.Time     `json:"received_at"`
+	Timestamp          *time.Time    `json:"timestamp,omitempty"`
+	LastTradeTime      *time.Time    `json:"last_trade_time,omitempty"`
+	InstrumentToken    uint32        `json:"instrument_token"`
+	Symbol             string        `json:"symbol"`
+	Exchange           string        `json:"exchange"`
+	Mode               string        `json:"mode"`
+	Tradable           bool          `json:"tradable"`
+	LastPrice          float64       `json:"last_price"`
+	LastTradedQuantity uint32        `json:"last_traded_quantity"`
+	AverageTradePrice  float64       `json:"average_trade_price"`
+	VolumeTraded       uint32        `json:"volume_traded"`
+	TotalBuyQuantity   uint32        `json:"total_buy_quantity"`
+	TotalSellQuantity  uint32        `json:"total_sell_quantity"`
+	OI                 uint32        `json:"oi"`
+	OIDayHigh          uint32        `json:"oi_day_high"`
+	OIDayLow           uint32        `json:"oi_day_low"`
+	NetChange          float64       `json:"net_change"`
+	OHLC               *models.OHLC  `json:"ohlc,omitempty"`
+	Depth              *models.Depth `json:"depth,omitempty"`
+}
+
+// FromKite converts a tick decoded by the ticker package
+func FromKite(t models.Tick, symbol, exchange string, received time.Time) Tick {
+	tick := Tick{
+		ReceivedAt:         received,
+		InstrumentToken:    t.InstrumentToken,
+		Symbol:             symbol,
+		Exchange:           exchange,
+		Mode:               t.Mode,
+		Tradable:           t.IsTradable,
+		LastPrice:          t.LastPrice,
+		LastTradedQuantity: t.LastTradedQuantity,
+		AverageTradePrice:  t.AverageTradePrice,
+		VolumeTraded:       t.VolumeTraded,
+		TotalBuyQuantity:   t.TotalBuyQuantity,
+		TotalSellQuantity:  t.TotalSellQuantity,
+		OI:                 t.OI,
+		OIDayHigh:          t.OIDayHigh,
+		OIDayLow:           t.OIDayLow,
+		NetChange:          t.NetChange,
+	}
+	if !t.Timestamp.IsZero() {
+		ts := t.Timestamp.Time
+		tick.Timestamp = &ts
+	}
+	if !t.LastTradeTime.IsZero() {
+		ts := t.LastTradeTime.Time
+		tick.LastTradeTime = &ts
+	}
+	if t.Mode != string(kiteticker.ModeLTP) {
+		ohlc := t.OHLC
+		tick.OHLC = &ohlc
+	}
+	if t.Mode == string(kiteticker.ModeFull) {
+		depth := t.Depth
+		tick.Depth = &depth
+	}
+	return tick
+}
+
+// ParseMode validates a configured ticker mode
+func ParseMode(name string) (kiteticker.Mode, error) {
+	switch mode := kiteticker.Mode(name); mode {
+	case kiteticker.ModeLTP, kiteticker.ModeQuote, kiteticker.ModeFull:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid tick mode %q (use ltp, quote or full)", name)
+}
@@ -0,0 +1,133 @@
+package ticks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/fsutil"
+)
+
+// Path returns the file holding the ticks received on a day
+func Path(dir string, day time.Time) string {
+	return filepath.Join(dir, "ticks_"+day.Format("2006-01-02")+".jsonl")
+}
+
+// Writer appends ticks to one JSON Lines file per day, switching files when
+// a tick arrives on a new day. Files are only ever appended to, so a
+// restarted capture continues the day's file.
+type Writer struct {
+	Dir string
+	// Location decides where days begin
+	Location *time.Location
+
+	day      string
+	file     *os.File
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+// NewWriter creates a writer for dir, splitting days in loc
+func NewWriter(dir string, loc *time.Location) *Writer {
+	return &Writer{Dir: dir, Location: loc}
+}
+
+// Write appends a tick to the file of the day it was received
+func (w *Writer) Write(tick Tick) error {
+	received := tick.ReceivedAt.In(w.Location)
+	if day := received.Format("2006-01-02"); day != w.day {
+		if err := w.rotate(received); err != nil {
+			return err
+		}
+	}
+	if err := w.encoder.Encode(tick); err != nil {
+		return fmt.Errorf("failed to write tick: %w", err)
+	}
+	return nil
+}
+
+// rotate closes the current file and opens the one for day
+func (w *Writer) rotate(day time.Time) error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(w.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	filename := Path(w.Dir, day)
+	file, dropped, err := fsutil.OpenAppend(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open tick file: %w", err)
+	}
+	if dropped > 0 {
+		log.Printf("Removed %d bytes of an incomplete last tick from %s", dropped, filename)
+	}
+	log.Printf("Writing ticks to %s", filename)
+
+	w.day = day.Format("2006-01-02")
+	w.file = file
+	w.buffered = bufio.NewWriterSize(file, 64*1024)
+	w.encoder = json.NewEncoder(w.buffered)
+	return nil
+}
+
+// Flush writes buffered ticks to the current file
+func (w *Writer) Flush() error {
+	if w.buffered == nil {
+		return nil
+	}
+	if err := w.buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write ticks: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the current file
+func (w *Writer) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.Flush()
+	if closeErr := w.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close tick file: %w", closeErr)
+	}
+	w.file, w.buffered, w.encoder, w.day = nil, nil, nil, ""
+	return err
+}
+
+// ReadFile calls fn for every tick stored in a tick file, in file order.
+// Lines that are not a tick, such as the partial last line of a capture that
+// was killed, are skipped with a warning.
+func ReadFile(filename string, fn func(Tick) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open tick file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read %s: %w", filename, err)
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			var tick Tick
+			if jsonErr := json.Unmarshal(data, &tick); jsonErr != nil {
+				log.Printf("Skipping malformed tick on line %d of %s: %v", line, filename, jsonErr)
+			} else if err := fn(tick); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
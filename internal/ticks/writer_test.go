@@ -0,0 +1,83 @@
+package ticks
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/instruments"
+)
+
+// TestWriterRepairsTruncatedFile appends to a day's file that a killed
+// capture left ending in half a tick
+func TestWriterRepairsTruncatedFile(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 6, 14, 9, 15, 0, 0, instruments.IST)
+	tick := func(price float64) Tick {
+		return Tick{ReceivedAt: at, InstrumentToken: 738561, Symbol: "RELIANCE", LastPrice: price}
+	}
+
+	w := NewWriter(dir, instruments.IST)
+	if err := w.Write(tick(2900)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The killed capture's last flush stopped in the middle of a tick
+	filename := Path(dir, at)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"received_at":"2024-06-14T09:15:01+05:30","instrument_tok`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	w = NewWriter(dir, instruments.IST)
+	if err := w.Write(tick(2901)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var prices []float64
+	err = ReadFile(filename, func(tick Tick) error {
+		prices = append(prices, tick.LastPrice)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(prices) != 2 || prices[0] != 2900 || prices[1] != 2901 {
+		t.Errorf("read prices %v, want [2900 2901]", prices)
+	}
+}
+
+// TestReadFileSkipsMalformedLines reads a file whose half tick already had
+// another tick appended to it
+func TestReadFileSkipsMalformedLines(t *testing.T) {
+	filename := Path(t.TempDir(), time.Now())
+	data := `{"received_at":"2024-06-14T09:15:00+05:30","instrument_token":1,"last_price":10}
+{"received_at":"2024-06-14T09:15:01+05:30","instrum{"received_at":"2024-06-14T09:15:02+05:30","instrument_token":1,"last_price":12}
+{"received_at":"2024-06-14T09:15:03+05:30","instrument_token":1,"last_price":13}
+{"received_at":"2024-06-14T09:15:04+05:30","instrument_token":1,"last_pr`
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var prices []float64
+	err := ReadFile(filename, func(tick Tick) error {
+		prices = append(prices, tick.LastPrice)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(prices) != 2 || prices[0] != 10 || prices[1] != 13 {
+		t.Errorf("read prices %v, want [10 13]", prices)
+	}
+}
@@ -0,0 +1,232 @@
+// Package kitedata downloads historical candles from Zerodha Kite for use in
+// other Go programs. It wraps the same authentication, instrument lookup and
+// chunked, retried downloads as the kitedata command, but returns candles in
+// memory instead of writing files.
+//
+//	client, err := kitedata.New(ctx, kitedata.Options{APIKey: key, AccessToken: token})
+//	candles, err := client.Candles(ctx, "RELIANCE", "minute", from, to)
+package kitedata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sabarim/kitedata/internal/auth"
+	"github.com/sabarim/kitedata/internal/config"
+	"github.com/sabarim/kitedata/internal/historical"
+	"github.com/sabarim/kitedata/internal/instruments"
+	kiteconnect "github.com/zerodha/gokiteconnect/v4"
+)
+
+// Candle is a single OHLCV candle; OI is only set for futures and options
+type Candle = historical.HistoricalCandle
+
+// Series describes the instrument and interval a batch of candles belongs to
+type Series = historical.Series
+
+// Instrument is an entry of Kite's instruments dump
+type Instrument = instruments.Instrument
+
+// Options configures a Client. Zero values fall back to the same defaults
+// as the command line tool.
+type Options struct {
+	// APIKey and AccessToken authenticate directly with Kite
+	APIKey      string
+	AccessToken string
+	// AuthServiceURL and AuthServiceKey fetch credentials from an auth
+	// service instead; direct credentials are used if it fails
+	AuthServiceURL string
+	AuthServiceKey string
+	Broker         string
+
+	// KiteBaseURL overrides the Kite API root, e.g. for a test server
+	KiteBaseURL string
+	// HTTPClient is used for Kite API and instrument dump requests when set
+	HTTPClient *http.Client
+
+	// Exchanges whose instruments are loaded; defaults to NSE and NFO
+	Exchanges []string
+	// InstrumentsPath, when set, is where downloaded instrument dumps are
+	// saved; other exchanges than NSE get a suffixed sibling file. Empty
+	// keeps them in memory only.
+	InstrumentsPath    string
+	InstrumentsBaseURL string
+
+	// RequestDelay is the pause between Kite requests; defaults to 500ms
+	RequestDelay time.Duration
+	// MaxRetries is how often a failed request is tried; defaults to 3
+	MaxRetries int
+}
+
+// config translates the options into the internal configuration
+func (o Options) config() *config.Config {
+	cfg := config.Config{
+		Auth: config.AuthConfig{
+			AuthServiceURL:    o.AuthServiceURL,
+			AuthServiceAPIKey: o.AuthServiceKey,
+			BrokerName:        o.Broker,
+			ApiKey:            o.APIKey,
+			SessionToken:      o.AccessToken,
+		},
+		Broker: config.BrokerConfig{
+			InstrumentsBaseURL: o.InstrumentsBaseURL,
+			Exchanges:          append([]string(nil), o.Exchanges...),
+		},
+	}
+	if o.InstrumentsBaseURL != "" {
+		cfg.Broker.InstrumentsNSEURL = o.InstrumentsBaseURL + "/NSE"
+	}
+	// Start from the defaults LoadConfig uses, including true booleans
+	// such as csv_header that ApplyDefaults cannot tell from unset
+	cfg.Historical = config.Defaults().Historical
+	cfg.Historical.RequestDelay = int(o.RequestDelay / time.Millisecond)
+	cfg.Historical.MaxRetries = o.MaxRetries
+	config.ApplyDefaults(&cfg)
+	// Unlike the command, a library only writes files it is asked to
+	cfg.Historical.InstrumentsPath = o.InstrumentsPath
+	return &cfg
+}
+
+// Client downloads instruments and candles. It is safe for concurrent use,
+// though Kite's rate limits make parallel downloads of little benefit.
+type Client struct {
+	cfg         *config.Config
+	kite        *kiteconnect.Client
+	downloader  *historical.HistoricalDownloader
+	instruments *instruments.InstrumentManager
+
+	mu     sync.Mutex
+	loaded bool
+	// loading is closed when the download in progress ends
+	loading chan struct{}
+}
+
+// New authenticates with Kite and returns a client. Instruments are
+// downloaded on first use.
+func New(ctx context.Context, opts Options) (*Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cfg := opts.config()
+
+	var kite *kiteconnect.Client
+	if cfg.Auth.AuthServiceURL == "" {
+		if opts.APIKey == "" || opts.AccessToken == "" {
+			return nil, fmt.Errorf("kitedata: APIKey and AccessToken or AuthServiceURL are required")
+		}
+		kite = kiteconnect.New(opts.APIKey)
+		kite.SetAccessToken(opts.AccessToken)
+	} else {
+		client, err := auth.NewAuthManager(cfg).GetClient()
+		if err != nil {
+			return nil, fmt.Errorf("kitedata: %w", err)
+		}
+		kite = client
+	}
+	if opts.KiteBaseURL != "" {
+		kite.SetBaseURI(opts.KiteBaseURL)
+	}
+	if opts.HTTPClient != nil {
+		kite.SetHTTPClient(opts.HTTPClient)
+	}
+
+	manager := instruments.NewInstrumentManager(cfg)
+	if opts.HTTPClient != nil {
+		manager.SetHTTPClient(opts.HTTPClient)
+	}
+
+	return &Client{
+		cfg:         cfg,
+		kite:        kite,
+		downloader:  historical.NewHistoricalDownloaderWithSink(cfg, kite, nil),
+		instruments: manager,
+	}, nil
+}
+
+// Kite returns the underlying Kite Connect client for calls this package
+// does not wrap
+func (c *Client) Kite() *kiteconnect.Client {
+	return c.kite
+}
+
+// loadInstruments downloads the instrument dumps once. Concurrent callers
+// wait for the download in progress without holding the lock, so each can
+// give up when its ctx ends; if that download fails, the next caller
+// downloads again with its own ctx.
+func (c *Client) loadInstruments(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.loaded {
+			c.mu.Unlock()
+			return nil
+		}
+		if loading := c.loading; loading != nil {
+			c.mu.Unlock()
+			select {
+			case <-loading:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		loading := make(chan struct{})
+		c.loading = loading
+		c.mu.Unlock()
+
+		err := c.instruments.DownloadInstruments(ctx)
+
+		c.mu.Lock()
+		c.loaded = err == nil
+		c.loading = nil
+		close(loading)
+		c.mu.Unlock()
+		return err
+	}
+}
+
+// Instruments returns every instrument of the configured exchanges
+func (c *Client) Instruments(ctx context.Context) ([]Instrument, error) {
+	if err := c.loadInstruments(ctx); err != nil {
+		return nil, err
+	}
+	return c.instruments.Instruments(), nil
+}
+
+// Resolve looks up instruments. Symbols are tradingsymbols, optionally
+// written EXCHANGE:SYMBOL, or the sources the command line accepts, such as
+// "filter:segment == \"NFO-FUT\"" or "file://symbols.txt".
+func (c *Client) Resolve(ctx context.Context, symbols ...string) ([]Instrument, error) {
+	if err := c.loadInstruments(ctx); err != nil {
+		return nil, err
+	}
+	return c.instruments.Resolve(instruments.Selection{Include: symbols})
+}
+
+// Candles downloads one symbol's candles between from and to. Interval is a
+// Kite interval such as minute, 5minute, 60minute (or hour) and day.
+func (c *Client) Candles(ctx context.Context, symbol, interval string, from, to time.Time) ([]Candle, error) {
+	list, err := c.Resolve(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("kitedata: unknown symbol %q", symbol)
+	}
+	_, candles, err := c.InstrumentCandles(ctx, list[0], interval, from, to)
+	return candles, err
+}
+
+// InstrumentCandles downloads an already resolved instrument's candles
+func (c *Client) InstrumentCandles(ctx context.Context, instrument Instrument, interval string, from, to time.Time) (Series, []Candle, error) {
+	kiteInterval, err := historical.KiteInterval(interval)
+	if err != nil {
+		return Series{}, nil, err
+	}
+	if !from.Before(to) {
+		return Series{}, nil, fmt.Errorf("kitedata: from %s is not before to %s", from, to)
+	}
+	return c.downloader.FetchCandles(ctx, instrument, kiteInterval, from, to)
+}
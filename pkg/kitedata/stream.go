@@ -0,0 +1,121 @@
+package kitedata
+
+import (
+	"context"
+	"time"
+)
+
+// Request selects the candles a Stream downloads
+type Request struct {
+	// Symbols accepts the same entries as Client.Resolve
+	Symbols  []string
+	Interval string
+	From     time.Time
+	To       time.Time
+}
+
+// Batch is one instrument's candles
+type Batch struct {
+	Series  Series
+	Candles []Candle
+}
+
+// Iterator steps through a Stream one instrument at a time. Each batch is
+// downloaded when Next is called, so a slow consumer never buffers more
+// than one instrument.
+//
+//	it := client.Stream(ctx, req)
+//	defer it.Close()
+//	for it.Next() {
+//		batch := it.Batch()
+//	}
+//	if err := it.Err(); err != nil { ... }
+type Iterator struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	client *Client
+	req    Request
+
+	resolved bool
+	pending  []Instrument
+	batch    Batch
+	err      error
+}
+
+// Stream returns an iterator over the requested instruments' candles.
+// The iterator stops at the first error; Close releases it early.
+func (c *Client) Stream(ctx context.Context, req Request) *Iterator {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Iterator{ctx: ctx, cancel: cancel, client: c, req: req}
+}
+
+// Next downloads the next instrument's candles, reporting false when all
+// instruments are done or an error occurred
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.resolved {
+		it.resolved = true
+		it.pending, it.err = it.client.Resolve(it.ctx, it.req.Symbols...)
+		if it.err != nil {
+			return false
+		}
+	}
+	if len(it.pending) == 0 {
+		it.Close()
+		return false
+	}
+
+	instrument := it.pending[0]
+	it.pending = it.pending[1:]
+	series, candles, err := it.client.InstrumentCandles(it.ctx, instrument, it.req.Interval, it.req.From, it.req.To)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.batch = Batch{Series: series, Candles: candles}
+
+	// Pace requests like the command line downloader does
+	if len(it.pending) > 0 {
+		select {
+		case <-it.ctx.Done():
+			it.err = it.ctx.Err()
+		case <-time.After(time.Duration(it.client.cfg.Historical.RequestDelay) * time.Millisecond):
+		}
+	}
+	return true
+}
+
+// Batch returns the batch downloaded by the last call to Next
+func (it *Iterator) Batch() Batch {
+	return it.batch
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close stops the iteration
+func (it *Iterator) Close() {
+	it.cancel()
+}
+
+// Channel streams batches over a channel for consumers that select on
+// several sources. The channel is closed when the iterator is done; check
+// Err afterwards.
+func (it *Iterator) Channel() <-chan Batch {
+	ch := make(chan Batch)
+	go func() {
+		defer close(ch)
+		for it.Next() {
+			select {
+			case ch <- it.Batch():
+			case <-it.ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}